@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AECG_MDC_SYSTEM     = "2.16.840.1.113883.6.24"
+	AECG_ACTCODE_SYSTEM = "2.16.840.1.113883.5.4"
+	AECG_CPT_SYSTEM     = "2.16.840.1.113883.6.12"
+	AECG_LEAD           = "MDC_ECG_LEAD_I"
+	AECG_DIGITS_RANGE   = 65534
+	AECG_TIME_FORMAT    = "20060102150405.000-0700"
+)
+
+// aecgEncoder writes the ECG signal as an HL7 annotated ECG (aECG)
+// document with a single rhythm series. Each run of consecutive seconds
+// becomes a sequence set whose TIME_ABSOLUTE sequence starts at the first
+// interpolated timestamp of the run, with the increment of the sample
+// rate. Seconds are resampled to that rate like in the EDF output, and
+// the lead values are stored as scaled integer digits.
+type aecgEncoder struct {
+	spool
+	w    io.Writer
+	name string
+}
+
+func newAECGEncoder(w io.Writer, opts *Options) encoder {
+	return &aecgEncoder{w: w, name: opts.Name}
+}
+
+func (e *aecgEncoder) Header(s *signal, v interface{}) error {
+	return e.open(s)
+}
+
+func (e *aecgEncoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *aecgEncoder) Close() error {
+	defer e.release()
+
+	rate := e.sampleRate()
+	min, max := e.physical(0)
+	origin := (max + min) / 2
+	scale := (max - min) / AECG_DIGITS_RANGE
+
+	var low, high time.Time
+	if l := len(e.seconds); l > 0 {
+		low = time.Unix(e.seconds[0].ztime, 0)
+		high = time.Unix(e.seconds[l-1].ztime+1, 0)
+	}
+
+	w := bufio.NewWriter(e.w)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<AnnotatedECG xmlns="urn:hl7-org:v3" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" type="Observation" classCode="OBS" moodCode="EVN">
+  <id root="%s"/>
+  <code code="93000" codeSystem="%s" codeSystemName="CPT-4"/>
+  <effectiveTime>
+    <low value="%s" inclusive="true"/>
+    <high value="%s" inclusive="false"/>
+  </effectiveTime>
+  <componentOf>
+    <timepointEvent>
+      <componentOf>
+        <subjectAssignment>
+          <subject>
+            <trialSubject>
+              <id root="%s" extension="%s"/>
+            </trialSubject>
+          </subject>
+        </subjectAssignment>
+      </componentOf>
+    </timepointEvent>
+  </componentOf>
+  <component>
+    <series>
+      <id root="%s"/>
+      <code code="RHYTHM" codeSystem="%s"/>
+      <effectiveTime>
+        <low value="%s" inclusive="true"/>
+        <high value="%s" inclusive="false"/>
+      </effectiveTime>
+`,
+		aecgUUID(), AECG_CPT_SYSTEM,
+		low.In(outputZone).Format(AECG_TIME_FORMAT), high.In(outputZone).Format(AECG_TIME_FORMAT),
+		aecgUUID(), aecgEscape(e.name),
+		aecgUUID(), AECG_ACTCODE_SYSTEM,
+		low.In(outputZone).Format(AECG_TIME_FORMAT), high.In(outputZone).Format(AECG_TIME_FORMAT))
+
+	var prev int64
+	inSet := false
+	err := e.each(rate, func(sec spoolSecond, chs [][]float64) error {
+		if inSet && sec.ztime != prev+1 {
+			w.WriteString(aecgSequenceSetEnd)
+			inSet = false
+		}
+		if !inSet {
+			fmt.Fprintf(w, aecgSequenceSetStart,
+				AECG_ACTCODE_SYSTEM, time.Unix(sec.ztime, 0).In(outputZone).Format(AECG_TIME_FORMAT),
+				strconv.FormatFloat(1/float64(rate), 'f', -1, 64),
+				AECG_LEAD, AECG_MDC_SYSTEM,
+				strconv.FormatFloat(origin, 'f', -1, 64), e.channels[0].unit,
+				strconv.FormatFloat(scale, 'f', -1, 64), e.channels[0].unit)
+			inSet = true
+		}
+		prev = sec.ztime
+
+		for _, x := range chs[0] {
+			d := math.Round((x - origin) / scale)
+			w.WriteString(strconv.FormatFloat(d, 'f', 0, 64))
+			w.WriteByte(' ')
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if inSet {
+		w.WriteString(aecgSequenceSetEnd)
+	}
+	w.WriteString("    </series>\n  </component>\n</AnnotatedECG>\n")
+	return w.Flush()
+}
+
+const aecgSequenceSetStart = `      <component>
+        <sequenceSet>
+          <component>
+            <sequence>
+              <code code="TIME_ABSOLUTE" codeSystem="%s"/>
+              <value xsi:type="GLIST_TS">
+                <head value="%s"/>
+                <increment value="%s" unit="s"/>
+              </value>
+            </sequence>
+          </component>
+          <component>
+            <sequence>
+              <code code="%s" codeSystem="%s"/>
+              <value xsi:type="SLIST_PQ">
+                <origin value="%s" unit="%s"/>
+                <scale value="%s" unit="%s"/>
+                <digits>`
+
+const aecgSequenceSetEnd = `</digits>
+              </value>
+            </sequence>
+          </component>
+        </sequenceSet>
+      </component>
+`
+
+// aecgUUID returns a random (version 4) UUID for the id roots.
+func aecgUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func aecgEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	APPLE_HEALTH_EXPORT    = "export.xml"
+	APPLE_HEALTH_ECG_DIR   = "electrocardiograms"
+	APPLE_HEALTH_DATE      = "2006-01-02 15:04:05 -0700"
+	APPLE_HEALTH_MICROVOLT = "µV"
+)
+
+// The header keys of the ECG files are localized, so the sample rate is
+// recognized by its value ("512 hertz") rather than by its key.
+var appleHealthRate = regexp.MustCompile(`^([0-9]+(?:[.,][0-9]+)?) *(?:hertz|Hz)`)
+
+// isAppleHealth reports whether path is the export.xml of an Apple Health
+// export.
+func isAppleHealth(path string) bool {
+	return strings.EqualFold(filepath.Base(path), APPLE_HEALTH_EXPORT)
+}
+
+// appleHealthSource reads an unzipped Apple Health export. The ECG
+// voltages are not part of export.xml but are written next to it, one
+// csv file per recording in the electrocardiograms directory. The
+// recordings are concatenated in time order; zfok_timestamp is the sample
+// index within a recording. There is no accel in a Health export.
+type appleHealthSource struct {
+	ecg []vitalRow
+}
+
+type appleHealthECG struct {
+	start  time.Time
+	rate   float64
+	values []float64 // mV
+}
+
+func openAppleHealth(path string) (*appleHealthSource, error) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(path), APPLE_HEALTH_ECG_DIR, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No ECG recordings in %s", filepath.Join(filepath.Dir(path), APPLE_HEALTH_ECG_DIR))
+	}
+
+	var ecgs []appleHealthECG
+	for _, f := range files {
+		ecg, err := readAppleHealthECG(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		ecgs = append(ecgs, ecg)
+	}
+	sort.Slice(ecgs, func(i, j int) bool { return ecgs[i].start.Before(ecgs[j].start) })
+
+	s := &appleHealthSource{}
+	for _, ecg := range ecgs {
+		for i, v := range ecg.values {
+			t := ecg.start.Add(time.Duration(float64(i) / ecg.rate * float64(time.Second)))
+			s.ecg = append(s.ecg, vitalRow{Ztime: t.Unix(), Nanos: int64(t.Nanosecond()), ZFokTimestamp: int64(i), Value: v})
+		}
+	}
+	return s, nil
+}
+
+func readAppleHealthECG(path string) (appleHealthECG, error) {
+	var ecg appleHealthECG
+	f, err := os.Open(path)
+	if err != nil {
+		return ecg, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	scale := 1.0
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ecg, err
+		}
+		// Header lines are "key,value", samples a single number.
+		if len(rec) == 1 {
+			v, err := strconv.ParseFloat(strings.TrimSpace(rec[0]), 64)
+			if err != nil {
+				return ecg, fmt.Errorf("Invalid sample: %q", rec[0])
+			}
+			ecg.values = append(ecg.values, v*scale)
+			continue
+		}
+		value := strings.TrimSpace(rec[1])
+		if t, err := time.Parse(APPLE_HEALTH_DATE, value); err == nil && ecg.start.IsZero() {
+			ecg.start = t
+		}
+		if m := appleHealthRate.FindStringSubmatch(value); m != nil {
+			ecg.rate, _ = strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+		}
+		if value == APPLE_HEALTH_MICROVOLT {
+			scale = 1e-3
+		}
+	}
+	if ecg.start.IsZero() || ecg.rate == 0 || math.IsInf(ecg.rate, 0) {
+		return ecg, fmt.Errorf("Recorded date or sample rate not found")
+	}
+	return ecg, nil
+}
+
+func (s *appleHealthSource) query(t int) (rowScanner, error) {
+	if t == ECG_TYPE {
+		return &sliceRows{rows: s.ecg}, nil
+	}
+	return &sliceRows{}, nil
+}
+
+func (s *appleHealthSource) Close() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// isArchive reports whether path is an archive of vital databases.
+func isArchive(p string) bool {
+	p = strings.ToLower(p)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(p, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// eachArchived calls f with the name of every vital database in the
+// archive at p and a temporary copy of it. SQLite can only open files, so
+// each database is extracted right before f and removed after it, one at
+// a time.
+func eachArchived(p string, f func(name, vital string)) error {
+	if strings.HasSuffix(strings.ToLower(p), ".zip") {
+		return eachZipped(p, f)
+	}
+	return eachTarred(p, f)
+}
+
+func eachZipped(p string, f func(name, vital string)) error {
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() || !isVital(zf.Name) {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = extract(zf.Name, r, f)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eachTarred(p string, f func(name, vital string)) error {
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if lp := strings.ToLower(p); strings.HasSuffix(lp, ".gz") || strings.HasSuffix(lp, ".tgz") {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg || !isVital(h.Name) {
+			continue
+		}
+		if err := extract(h.Name, tr, f); err != nil {
+			return err
+		}
+	}
+}
+
+// extract copies r to a temporary file and calls f with it.
+func extract(name string, r io.Reader, f func(name, vital string)) error {
+	tmp, err := os.CreateTemp("", "vital2csv-*"+VITAL_FILE_EXT)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	f(name, tmp.Name())
+	return nil
+}
+
+func isVital(name string) bool {
+	return strings.EqualFold(path.Ext(name), VITAL_FILE_EXT)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// Rows are collected into record batches of this size before they are
+// written, as one batch per second would bloat the file.
+const ARROW_BATCH_SIZE = 1 << 16
+
+var (
+	arrowSecond = &arrow.TimestampType{Unit: arrow.Second, TimeZone: "UTC"}
+	arrowNano   = &arrow.TimestampType{Unit: arrow.Nanosecond, TimeZone: "UTC"}
+)
+
+// arrowEncoder writes Arrow IPC files (Feather v2). Like the parquet
+// output, timestamps are typed columns rather than formatted strings.
+type arrowEncoder struct {
+	w  io.Writer
+	fw *ipc.FileWriter
+	b  *array.RecordBuilder
+	n  int
+}
+
+func newArrowEncoder(w io.Writer, opts *Options) encoder {
+	return &arrowEncoder{w: w}
+}
+
+func (e *arrowEncoder) Header(s *signal, v interface{}) error {
+	fields := []arrow.Field{
+		{Name: "timestamp", Type: arrowSecond},
+		{Name: "z_fok_timestamp", Type: arrow.PrimitiveTypes.Int64},
+	}
+	for _, c := range s.channels() {
+		fields = append(fields, arrow.Field{Name: c.name, Type: arrow.PrimitiveTypes.Float64})
+	}
+	fields = append(fields, arrow.Field{Name: "detailed_timestamp", Type: arrowNano})
+	schema := arrow.NewSchema(fields, nil)
+
+	mem := memory.NewGoAllocator()
+	fw, err := ipc.NewFileWriter(e.w, ipc.WithSchema(schema), ipc.WithAllocator(mem))
+	if err != nil {
+		return err
+	}
+	e.fw, e.b = fw, array.NewRecordBuilder(mem, schema)
+	return nil
+}
+
+func (e *arrowEncoder) Encode(s *signal, v interface{}) error {
+	eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		e.append(ztime, zfok, detailed.UnixNano(), vs...)
+		return nil
+	})
+	if e.n >= ARROW_BATCH_SIZE {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *arrowEncoder) append(ztime, zfok, detailed int64, vs ...float64) {
+	e.b.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(ztime))
+	e.b.Field(1).(*array.Int64Builder).Append(zfok)
+	for i, v := range vs {
+		e.b.Field(2 + i).(*array.Float64Builder).Append(v)
+	}
+	e.b.Field(2 + len(vs)).(*array.TimestampBuilder).Append(arrow.Timestamp(detailed))
+	e.n++
+}
+
+func (e *arrowEncoder) flush() error {
+	rec := e.b.NewRecordBatch()
+	defer rec.Release()
+	e.n = 0
+	return e.fw.Write(rec)
+}
+
+func (e *arrowEncoder) Close() error {
+	defer e.b.Release()
+	if e.n > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	return e.fw.Close()
+}
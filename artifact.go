@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const ARTIFACTS_FILE_SUFFIX = ".artifacts.csv"
+
+// Heuristics of the artifacts of -artifacts. A second is a flatline if
+// all of its samples have the same values. It is clipped if a run of
+// CLIP_RUN or more samples of a channel holds the highest or lowest value
+// of the second, as a signal does when saturating its ADC. It has a
+// motion artifact if the range of a channel is over MOTION_FACTOR times
+// the median range of the last MOTION_SECONDS seconds, of which at least
+// MOTION_MIN_SECONDS are known.
+const (
+	CLIP_RUN           = 4
+	MOTION_FACTOR      = 4
+	MOTION_SECONDS     = 30
+	MOTION_MIN_SECONDS = 10
+)
+
+// artifactSecond is a second of ztime of a signal, with the artifacts
+// found in it.
+type artifactSecond struct {
+	ztime                     int64
+	flatline, clipped, motion bool
+}
+
+// artifactEncoder finds the artifacts of the samples of a signal passed
+// to its encoder, second by second of their ztime. flush finds those of
+// the last second.
+type artifactEncoder struct {
+	encoder
+	seconds []artifactSecond
+	ranges  []float64 // of the last seconds, latest last
+	// The samples of the current second, by channel.
+	ztime     int64
+	n         int
+	low, high []float64
+	first     []float64
+	flat      bool
+	last      []float64
+	run       []int
+	clipped   [][]float64 // values of the runs of CLIP_RUN or more
+}
+
+func (e *artifactEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		vs = vs[:s.axes]
+		if e.n > 0 && ztime != e.ztime {
+			e.flush(s)
+		}
+		if e.n == 0 {
+			e.start(ztime, len(vs))
+		}
+		e.n++
+		for i, x := range vs {
+			if math.IsNaN(x) {
+				continue
+			}
+			if math.IsNaN(e.first[i]) {
+				e.first[i], e.low[i], e.high[i] = x, x, x
+			}
+			e.low[i], e.high[i] = math.Min(e.low[i], x), math.Max(e.high[i], x)
+			if x != e.first[i] {
+				e.flat = false
+			}
+			if x != e.last[i] {
+				e.endRun(i)
+			}
+			e.last[i] = x
+			e.run[i]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// start starts a second of samples of nc channels.
+func (e *artifactEncoder) start(ztime int64, nc int) {
+	e.ztime, e.n, e.flat = ztime, 0, true
+	e.low, e.high = make([]float64, nc), make([]float64, nc)
+	e.first, e.last = make([]float64, nc), make([]float64, nc)
+	for i := range e.first {
+		e.first[i], e.last[i] = math.NaN(), math.NaN()
+	}
+	e.run, e.clipped = make([]int, nc), make([][]float64, nc)
+}
+
+// endRun ends the run of equal values of channel i.
+func (e *artifactEncoder) endRun(i int) {
+	if e.run[i] >= CLIP_RUN {
+		e.clipped[i] = append(e.clipped[i], e.last[i])
+	}
+	e.run[i] = 0
+}
+
+// flush finds the artifacts of the current second.
+func (e *artifactEncoder) flush(s *signal) {
+	if e.n == 0 {
+		return
+	}
+	a := artifactSecond{ztime: e.ztime, flatline: e.n > 1 && e.flat}
+	r := 0.0
+	for i := range e.first {
+		e.endRun(i)
+		if math.IsNaN(e.first[i]) {
+			continue
+		}
+		r = math.Max(r, e.high[i]-e.low[i])
+		for _, x := range e.clipped[i] {
+			if !a.flatline && (x == e.high[i] || x == e.low[i]) {
+				a.clipped = true
+			}
+		}
+	}
+	if len(e.ranges) >= MOTION_MIN_SECONDS {
+		ranges := append([]float64(nil), e.ranges...)
+		sort.Float64s(ranges)
+		median := ranges[len(ranges)/2]
+		a.motion = median > 0 && r > MOTION_FACTOR*median
+	}
+	e.ranges = append(e.ranges, r)
+	if len(e.ranges) > MOTION_SECONDS {
+		e.ranges = e.ranges[1:]
+	}
+	e.seconds = append(e.seconds, a)
+	e.n = 0
+}
+
+// writeArtifacts writes the seconds of the signals written, by their
+// encoders finding their artifacts, to <name>.artifacts.csv with a
+// column of 0 or 1 for each artifact, and an artifact column for any.
+func writeArtifacts(encs map[int]*artifactEncoder, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"signal", "time", "timestamp", "flatline", "clipped", "motion", "artifact"})
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	for _, t := range opts.Signals {
+		e := encs[t]
+		if e == nil {
+			continue
+		}
+		for _, a := range e.seconds {
+			w.Write([]string{
+				signalTypes[t].name,
+				timeLayout.formatTime(time.Unix(a.ztime, 0)),
+				strconv.FormatInt(a.ztime, 10),
+				flag(a.flatline),
+				flag(a.clipped),
+				flag(a.motion),
+				flag(a.flatline || a.clipped || a.motion),
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+ARTIFACTS_FILE_SUFFIX), b.Bytes())
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// Rows are appended in blocks of this size, as one block per second of
+// samples would bloat the file.
+const AVRO_BLOCK_SIZE = 4096
+
+// The record schema of a signal, named after it, with a double field per
+// channel.
+const AVRO_SCHEMA = `{
+  "type": "record",
+  "name": "%s",
+  "namespace": "vital2csv",
+  "fields": [
+    {"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "z_fok_timestamp", "type": "long"},%s
+    {"name": "detailed_timestamp", "type": {"type": "long", "logicalType": "timestamp-micros"}}
+  ]
+}`
+
+// avroEncoder writes Avro object container files (deflate compressed)
+// with the schema of the signal embedded in the header.
+type avroEncoder struct {
+	w    io.Writer
+	ocf  *goavro.OCFWriter
+	rows []interface{}
+}
+
+func newAvroEncoder(w io.Writer, opts *Options) encoder {
+	return &avroEncoder{w: w}
+}
+
+func (e *avroEncoder) Header(s *signal, v interface{}) error {
+	var fields string
+	for _, c := range s.channels() {
+		fields += fmt.Sprintf("\n    {\"name\": %q, \"type\": \"double\"},", c.name)
+	}
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               e.w,
+		Schema:          fmt.Sprintf(AVRO_SCHEMA, strings.ToUpper(s.name[:1])+s.name[1:], fields),
+		CompressionName: goavro.CompressionDeflateLabel,
+	})
+	e.ocf = ocf
+	return err
+}
+
+func (e *avroEncoder) Encode(s *signal, v interface{}) error {
+	chs := s.channels()
+	eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		row := map[string]interface{}{
+			"timestamp":          time.Unix(ztime, 0),
+			"z_fok_timestamp":    zfok,
+			"detailed_timestamp": detailed,
+		}
+		for i, c := range chs {
+			row[c.name] = vs[i]
+		}
+		e.rows = append(e.rows, row)
+		return nil
+	})
+	if len(e.rows) < AVRO_BLOCK_SIZE {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *avroEncoder) flush() error {
+	err := e.ocf.Append(e.rows)
+	e.rows = e.rows[:0]
+	return err
+}
+
+func (e *avroEncoder) Close() error {
+	if len(e.rows) == 0 {
+		return nil
+	}
+	return e.flush()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const RR_FILE_SUFFIX = ".rr.csv"
+
+// beatRecord is a row of the beats statement of a vitalSchema: a beat
+// detected by the device, with the time as stored in the database and
+// the RR interval and beat type if recorded.
+type beatRecord struct {
+	Time     float64         `db:"ztime"`
+	Interval sql.NullFloat64 `db:"interval"`
+	Type     sql.NullString  `db:"type"`
+}
+
+// hasBeats reports whether a schema knows the beat table.
+func hasBeats() bool {
+	for _, s := range vitalSchemas {
+		if s.beats != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// beat is a heart beat, recorded by the device or detected in the ECG,
+// with the RR interval since the previous one in milliseconds, NaN if
+// none, and its type if recorded.
+type beat struct {
+	time     time.Time
+	interval float64
+	kind     string
+}
+
+// beats returns the beats of the databases within the window read.
+// Intervals not recorded are those since the previous beat, and NaN for
+// the first of the databases.
+func (s *vitalSource) beats() ([]beat, error) {
+	var beats []beat
+	for i, db := range s.dbs {
+		if s.schemas[i].beats == "" {
+			continue
+		}
+		var recs []beatRecord
+		if err := db.Select(&recs, s.schemas[i].beats); err != nil {
+			return nil, err
+		}
+		for _, r := range recs {
+			row := vitalRecord{Time: r.Time}.row(s.epochs[i])
+			b := beat{time: s.drift.correct(time.Unix(row.Ztime, row.Nanos)), interval: math.NaN(), kind: r.Type.String}
+			switch {
+			case r.Interval.Valid:
+				b.interval = r.Interval.Float64
+			case len(beats) > 0:
+				b.interval = intervalMs(beats[len(beats)-1].time, b.time)
+			}
+			beats = append(beats, b)
+		}
+	}
+	return slices.DeleteFunc(beats, func(b beat) bool { return !s.window.contains(b.time) }), nil
+}
+
+// intervalMs returns the interval from prev to t in milliseconds, to the
+// microsecond, below which times stored as floating point seconds are not
+// exact.
+func intervalMs(prev, t time.Time) float64 {
+	return math.Round(float64(t.Sub(prev))/float64(time.Microsecond)) / 1000
+}
+
+// writeBeats writes the beats of the databases to <name>.rr.csv, with
+// the time columns of the samples, the RR interval in milliseconds and
+// the beat type. Intervals not recorded are those since the previous
+// beat, and empty for the first.
+func writeBeats(src *vitalSource, opts *Options) error {
+	beats, err := src.beats()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"time", "timestamp", "detailed_timestamp", "interval_ms", "beat_type"})
+	for _, r := range beats {
+		interval := ""
+		if !math.IsNaN(r.interval) {
+			interval = strconv.FormatFloat(round(r.interval), 'g', -1, 64)
+		}
+		w.Write([]string{
+			timeLayout.formatTime(time.Unix(r.time.Unix(), 0)),
+			strconv.FormatInt(r.time.Unix(), 10),
+			timeLayout.formatDetailed(r.time),
+			interval,
+			r.kind,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+RR_FILE_SUFFIX), b.Bytes())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The entry of a -calibration file for the devices not listed.
+const CALIBRATION_DEFAULT = "*"
+
+// channelCalibration calibrates the values of a channel, in the unit
+// recorded: value = (v - offset - temperature_coefficient * (T - T0)) *
+// scale, where T is the temperature recorded at the time of the sample
+// and T0 the reference temperature of its signal.
+type channelCalibration struct {
+	Offset                 float64 `yaml:"offset"`
+	Scale                  float64 `yaml:"scale"` // 1 if not given
+	TemperatureCoefficient float64 `yaml:"temperature_coefficient"`
+}
+
+// signalCalibration is the calibration of a signal: of its channels by
+// name, value or x, y and z.
+type signalCalibration struct {
+	ReferenceTemperature float64                       `yaml:"reference_temperature"` // °C
+	Channels             map[string]channelCalibration `yaml:",inline"`
+}
+
+// calibrations are the entries of a -calibration file, the calibration
+// of each signal by device id.
+type calibrations map[string]map[string]signalCalibration
+
+// loadCalibration reads a -calibration file, YAML or JSON, giving the
+// calibration of the signals of each device by its id, and of the
+// devices not listed by "*":
+//
+//	"*":
+//	  ecg:
+//	    value: {scale: 1.002}
+//	A1B2C3:
+//	  accel:
+//	    reference_temperature: 25
+//	    x: {offset: 0.012, scale: 0.998, temperature_coefficient: 0.0004}
+//	    z: {offset: -0.02}
+func loadCalibration(path string) (calibrations, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c calibrations
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for device, signals := range c {
+		for name, sc := range signals {
+			for ch, cc := range sc.Channels {
+				if ch != "value" && ch != "x" && ch != "y" && ch != "z" {
+					return nil, fmt.Errorf("%s: unknown channel of %s of device %s: %q, give value or x, y and z", path, name, device, ch)
+				}
+				if cc.Scale == 0 {
+					cc.Scale = 1
+				}
+				sc.Channels[ch] = cc
+			}
+		}
+	}
+	return c, nil
+}
+
+// calibration is the calibration of a signal applied by a conversion:
+// of each channel, in channel order, with the temperatures recorded if
+// compensated.
+type calibration struct {
+	channels     []channelCalibration
+	reference    float64
+	temperatures []temperature
+}
+
+// temperature is a sample of the temperature signal.
+type temperature struct {
+	t     int64 // Unix time
+	value float64
+}
+
+// calibrate returns the value v of channel i of a sample at Unix time t
+// calibrated.
+func (c *calibration) calibrate(i int, t int64, v float64) float64 {
+	cc := c.channels[i]
+	if cc.TemperatureCoefficient != 0 && len(c.temperatures) > 0 {
+		// The temperature is the last recorded at or before t, or the
+		// first.
+		j := sort.Search(len(c.temperatures), func(j int) bool { return c.temperatures[j].t > t })
+		v -= cc.TemperatureCoefficient * (c.temperatures[max(j-1, 0)].value - c.reference)
+	}
+	return (v - cc.Offset) * cc.Scale
+}
+
+// calibrate returns the value v of channel i of a sample of s at Unix
+// time t calibrated by -calibration, if given for s.
+func (s *signal) calibrate(i int, t int64, v float64) float64 {
+	if s.calibration == nil {
+		return v
+	}
+	return s.calibration.calibrate(i, t, v)
+}
+
+// setCalibration sets the calibration of the signals of opts to that of
+// the device converted: the device of -by-device, or the only device
+// recorded, or else the entry "*".
+func setCalibration(src source, opts *Options) error {
+	device := opts.Device
+	if vs, ok := src.(*vitalSource); ok && device == "" && hasDevices() {
+		devices, err := vs.devices()
+		if err != nil {
+			return err
+		}
+		if len(devices) == 1 {
+			device = devices[0]
+		}
+	}
+	signals, ok := opts.Calibration[device]
+	if !ok {
+		signals, device = opts.Calibration[CALIBRATION_DEFAULT], CALIBRATION_DEFAULT
+	}
+
+	var temperatures []temperature
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		s.calibration = nil
+		sc, ok := signals[s.name]
+		if !ok {
+			continue
+		}
+		c := &calibration{reference: sc.ReferenceTemperature}
+		compensated := false
+		for _, ch := range s.channels() {
+			cc, ok := sc.Channels[ch.name]
+			if !ok {
+				cc = channelCalibration{Scale: 1}
+			}
+			c.channels = append(c.channels, cc)
+			compensated = compensated || cc.TemperatureCoefficient != 0
+		}
+		for ch := range sc.Channels {
+			if !hasChannel(s, ch) {
+				return fmt.Errorf("%s has no channel %s", s.name, ch)
+			}
+		}
+		if compensated && temperatures == nil {
+			var err error
+			if temperatures, err = readTemperatures(src); err != nil {
+				return err
+			}
+			if len(temperatures) == 0 {
+				log.Printf("No temperature is recorded, %s is calibrated without temperature compensation", s.label)
+			}
+		}
+		c.temperatures = temperatures
+		s.calibration = c
+		if device == CALIBRATION_DEFAULT {
+			log.Printf("%s: calibrated by the default calibration", s.label)
+		} else {
+			log.Printf("%s: calibrated by the calibration of device %s", s.label, device)
+		}
+	}
+	return nil
+}
+
+// hasChannel reports whether s has a channel named name.
+func hasChannel(s *signal, name string) bool {
+	for _, ch := range s.channels() {
+		if ch.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readTemperatures reads the samples of the temperature signal, in the
+// order of their time.
+func readTemperatures(src source) ([]temperature, error) {
+	rows, err := src.query(TEMP_TYPE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	temperatures := []temperature{}
+	for rows.Next() {
+		var r vitalRow
+		if err := rows.StructScan(&r); err != nil {
+			return nil, err
+		}
+		temperatures = append(temperatures, temperature{r.Ztime, r.Value})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(temperatures, func(i, j int) bool { return temperatures[i].t < temperatures[j].t })
+	return temperatures, nil
+}
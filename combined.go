@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// combinedEncoder writes all signals to one file in long format: one
+// Sample row per channel value, named by the signal column (ecg, accel_x,
+// accel_y, accel_z, hr). The wrapped encoder receives *[]Sample batches.
+//
+// Rows are written in the order of their detailed time, those of one
+// time in the order of the signals and their channels. The signals are
+// written from concurrent goroutines, so, as by joinedEncoder, the rows
+// are held until every signal has passed their time or finished, or
+// until closed.
+type combinedEncoder struct {
+	mu      sync.Mutex
+	enc     encoder
+	header  bool
+	signals []*signal
+	held    map[*signal][]Sample // of each signal, in time order
+	passed  map[*signal]time.Time
+	done    map[*signal]bool
+	rows    []Sample
+}
+
+func newCombinedEncoder(enc encoder, opts *Options) encoder {
+	e := &combinedEncoder{
+		enc: enc, held: make(map[*signal][]Sample),
+		passed: make(map[*signal]time.Time), done: make(map[*signal]bool),
+	}
+	for _, t := range opts.Signals {
+		e.signals = append(e.signals, signalTypes[t])
+	}
+	return e
+}
+
+func (e *combinedEncoder) Header(s *signal, v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.enc.Header(s, &[]Sample{})
+}
+
+func (e *combinedEncoder) Encode(s *signal, v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	chs := s.channels()
+	eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		for i, value := range vs {
+			// Missing values of optional channels have no row.
+			if math.IsNaN(value) {
+				continue
+			}
+			e.held[s] = append(e.held[s], Sample{
+				OriginalTimestamp: timeLayout.formatTime(time.Unix(ztime, 0)), Ztime: ztime, ZFokTimestamp: zfok,
+				Signal: s.column(chs[i]), Value: value,
+				DetailedTimestamp: timeLayout.formatDetailed(detailed), Detailed: detailed,
+			})
+		}
+		e.passed[s] = detailed
+		return nil
+	})
+	return e.writePassed()
+}
+
+// finish ends signal s, whose rows no longer hold back those of the
+// others.
+func (e *combinedEncoder) finish(s *signal) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.done[s] = true
+	return e.writePassed()
+}
+
+// writePassed writes the rows of the times passed by every signal not
+// finished.
+func (e *combinedEncoder) writePassed() error {
+	var until time.Time
+	for _, s := range e.signals {
+		if e.done[s] {
+			continue
+		}
+		t, ok := e.passed[s]
+		if !ok {
+			return nil
+		}
+		if until.IsZero() || t.Before(until) {
+			until = t
+		}
+	}
+	return e.write(until)
+}
+
+// write writes the held rows up to until, or all if it is zero, merging
+// the signals by time.
+func (e *combinedEncoder) write(until time.Time) error {
+	e.rows = e.rows[:0]
+	var last *signal
+	for {
+		// The first signal whose next row is the earliest.
+		var next *signal
+		for _, s := range e.signals {
+			rows := e.held[s]
+			if len(rows) == 0 || !until.IsZero() && rows[0].Detailed.After(until) {
+				continue
+			}
+			if next == nil || rows[0].Detailed.Before(e.held[next][0].Detailed) {
+				next = s
+			}
+		}
+		if next == nil {
+			break
+		}
+		// The channels of the sample.
+		rows := e.held[next]
+		n := 1
+		for n < len(rows) && rows[n].Detailed.Equal(rows[0].Detailed) {
+			n++
+		}
+		e.rows = append(e.rows, rows[:n]...)
+		e.held[next], last = rows[n:], next
+	}
+	if len(e.rows) == 0 {
+		return nil
+	}
+	return e.enc.Encode(last, &e.rows)
+}
+
+func (e *combinedEncoder) Close() error {
+	if err := e.write(time.Time{}); err != nil {
+		return err
+	}
+	return e.enc.Close()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"text/tabwriter"
+)
+
+// commands are the subcommands by name. Without one, the arguments are
+// those of export.
+var commands = map[string]func(args []string){
+	"export":     exportCommand,
+	"validate":   validateCommand,
+	"list-types": listTypesCommand,
+	"info":       infoCommand,
+	"version":    versionCommand,
+}
+
+// signalType is a signal listed by list-types, written as a line of
+// JSON with -json.
+type signalType struct {
+	Ztype      *int    `json:"ztype"` // nil if no schema knows it
+	Name       string  `json:"name"`
+	Label      string  `json:"label"`
+	Axes       int     `json:"axes"`
+	Unit       string  `json:"unit"`
+	SampleRate float64 `json:"sample_rate"` // Hz, 0 if unknown
+	Sparse     bool    `json:"sparse"`
+}
+
+// ztypeOf returns the ztype code of signal type t in the first schema
+// that knows it.
+func ztypeOf(t int) (int, bool) {
+	for _, s := range vitalSchemas {
+		if code, ok := s.ztypes[t]; ok {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// loadSignalOptions loads the -schema and -signals files of a
+// subcommand and adds the signals of -ztype, each if given.
+func loadSignalOptions(schema, signalsFile, ztypes string) {
+	if schema != "" {
+		s, err := loadSchema(schema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vitalSchemas = []vitalSchema{s}
+	}
+	if signalsFile != "" {
+		if err := loadSignals(signalsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if ztypes != "" {
+		if err := addZtypeSignals(ztypes); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// listTypesCommand lists the signals the tool exports with their ztype
+// codes, as given by the schema, -signals and -ztype, in a table or as
+// lines of JSON.
+func listTypesCommand(args []string) {
+	fs := flag.NewFlagSet("list-types", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+Usage of %s list-types:
+  %s list-types [options]
+
+
+`, path.Base(os.Args[0]), os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+	var schema, signalsFile, ztypes string
+	var asJSON bool
+	fs.StringVar(&schema, "schema", "", "JSON file naming the tables and columns of a database variant")
+	fs.StringVar(&signalsFile, "signals", "", "YAML or JSON file mapping ztype codes to signals(name, axes, unit, sample_rate)")
+	fs.StringVar(&ztypes, "ztype", "", "More signals of one channel by ztype code, as comma separated code:name pairs(e.g. 12:emg)")
+	fs.BoolVar(&asJSON, "json", false, "Write a line of JSON per signal")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	loadSignalOptions(schema, signalsFile, ztypes)
+
+	var types []signalType
+	for _, t := range signalOrder {
+		s := signalTypes[t]
+		st := signalType{Name: s.name, Label: s.label, Axes: s.axes, Unit: s.unit, SampleRate: s.rate, Sparse: s.sparse}
+		if code, ok := ztypeOf(t); ok {
+			st.Ztype = &code
+		}
+		types = append(types, st)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, st := range types {
+			if err := enc.Encode(st); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ZTYPE\tNAME\tAXES\tUNIT\tSAMPLE RATE")
+	for _, st := range types {
+		ztype, rate := "-", "-"
+		if st.Ztype != nil {
+			ztype = strconv.Itoa(*st.Ztype)
+		}
+		if st.SampleRate > 0 {
+			rate = strconv.FormatFloat(st.SampleRate, 'f', -1, 64) + " Hz"
+		} else if st.Sparse {
+			rate = "sparse"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", ztype, st.Name, st.Axes, st.Unit, rate)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type compression struct {
+	ext    string
+	writer func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+var compressions = map[string]compression{
+	"gzip": {".gz", newGzipWriter},
+	"zstd": {".zst", newZstdWriter},
+}
+
+func newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// newZstdWriter takes the zstd command line levels (1-22), which are
+// mapped to the nearest level of the encoder.
+func newZstdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		return zstd.NewWriter(w)
+	}
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
+
+// compressedEncoder closes the compressor after the encoder has written
+// its last bytes.
+type compressedEncoder struct {
+	encoder
+	c io.Closer
+}
+
+// finish passes the end of signal s to the encoder, if it holds back the
+// samples of the others until then.
+func (e *compressedEncoder) finish(s *signal) error {
+	if f, ok := e.encoder.(interface{ finish(*signal) error }); ok {
+		return f.finish(s)
+	}
+	return nil
+}
+
+func (e *compressedEncoder) Close() error {
+	if err := e.encoder.Close(); err != nil {
+		return err
+	}
+	return e.c.Close()
+}
+
+// newEncoder returns the encoder of the output format writing to f,
+// compressed if requested.
+func newEncoder(f io.Writer, opts *Options) (encoder, error) {
+	if opts.Compression == nil {
+		return opts.Format.encoder(f, opts), nil
+	}
+	cw, err := opts.Compression.writer(f, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedEncoder{opts.Format.encoder(cw, opts), cw}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+const (
+	CSVW_CONTEXT         = "http://www.w3.org/ns/csvw"
+	CSVW_METADATA_SUFFIX = "-metadata.json"
+)
+
+// csvwDatetimeFormats maps the time layouts to CSVW (UAX #35) date
+// patterns: the first for time, the second for detailed_timestamp.
+var csvwDatetimeFormats = map[string][2]string{
+	timeFormats["local"].layout:        {"yyyy-MM-dd HH:mm:ss", "yyyy-MM-dd HH:mm:ss.SSSSSSSSS"},
+	timeFormats["rfc3339"].layout:      {"yyyy-MM-ddTHH:mm:ssXXX", "yyyy-MM-ddTHH:mm:ss.SSSSSSSSSXXX"},
+	timeFormats["local-offset"].layout: {"yyyy-MM-dd HH:mm:ssXXX", "yyyy-MM-dd HH:mm:ss.SSSSSSSSSXXX"},
+}
+
+// csvwDatatypes maps the column kinds to CSVW datatypes.
+var csvwDatatypes = map[string]string{
+	"integer":  "integer",
+	"number":   "double",
+	"string":   "string",
+	"datetime": "datetime",
+}
+
+type csvwDatatype struct {
+	Base   string `json:"base"`
+	Format string `json:"format,omitempty"`
+}
+
+type csvwColumn struct {
+	Name        string       `json:"name"`
+	Titles      string       `json:"titles"`
+	Datatype    csvwDatatype `json:"datatype"`
+	Required    bool         `json:"required"`
+	Description string       `json:"dc:description,omitempty"`
+	Unit        string       `json:"schema:unitText,omitempty"`
+}
+
+type csvwTable struct {
+	Context string `json:"@context"`
+	URL     string `json:"url"`
+	Dialect struct {
+		Delimiter string `json:"delimiter"`
+		Header    bool   `json:"header"`
+	} `json:"dialect"`
+	TableSchema struct {
+		Columns []csvwColumn `json:"columns"`
+	} `json:"tableSchema"`
+}
+
+// writeCSVW writes a CSV on the Web metadata file next to each csv file
+// in paths, named after it with the "-metadata.json" suffix that CSVW
+// processors look for.
+func writeCSVW(opts *Options, paths map[int][]string) error {
+	for _, out := range outputsOf(opts, paths) {
+		t := csvwTable{Context: CSVW_CONTEXT}
+		t.Dialect.Delimiter = string(opts.Delimiter)
+		t.Dialect.Header = true
+		for _, c := range columnsOf(out, opts) {
+			cc := csvwColumn{
+				Name: c.name, Titles: c.name, Required: true,
+				Datatype:    csvwDatatype{Base: csvwDatatypes[c.kind]},
+				Description: c.description, Unit: c.unit,
+			}
+			if c.kind == "datetime" {
+				f := csvwDatetimeFormats[timeLayout.layout]
+				cc.Datatype.Format = f[0]
+				if c.name == "detailed_timestamp" {
+					cc.Datatype.Format = f[1]
+				}
+			}
+			t.TableSchema.Columns = append(t.TableSchema.Columns, cc)
+		}
+
+		for _, path := range out.paths {
+			t.URL = filepath.Base(path)
+			b, err := json.MarshalIndent(t, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := writeOutput(path+CSVW_METADATA_SUFFIX, append(b, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const DATAPACKAGE_FILE = "datapackage.json"
+
+type dataPackageField struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Format      string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+}
+
+type dataPackageResource struct {
+	Name        string      `json:"name"`
+	Path        interface{} `json:"path"` // a path, or a list of paths of split files
+	Profile     string      `json:"profile"`
+	Format      string      `json:"format"`
+	MediaType   string      `json:"mediatype"`
+	Encoding    string      `json:"encoding"`
+	Compression string      `json:"compression,omitempty"`
+	Dialect     struct {
+		Delimiter string `json:"delimiter"`
+	} `json:"dialect"`
+	Schema struct {
+		Fields []dataPackageField `json:"fields"`
+	} `json:"schema"`
+}
+
+type dataPackageSource struct {
+	Title string `json:"title"`
+	Path  string `json:"path"`
+}
+
+type dataPackage struct {
+	Profile   string                `json:"profile"`
+	Name      string                `json:"name"`
+	Created   string                `json:"created"`
+	Sources   []dataPackageSource   `json:"sources"`
+	Resources []dataPackageResource `json:"resources"`
+}
+
+// writeDataPackage writes a Frictionless Tabular Data Package descriptor
+// of the csv files in paths next to them. The unit of value columns is
+// kept as a custom field property. When several databases are converted
+// into one directory, each descriptor is prefixed with its recording name.
+func writeDataPackage(opts *Options, paths map[int][]string) error {
+	dir := outputDir(opts.Outputs[opts.Signals[0]])
+	dp := dataPackage{
+		Profile: "tabular-data-package",
+		Name:    identifier(opts.Name),
+		Created: time.Now().Format(time.RFC3339),
+		Sources: []dataPackageSource{{Title: filepath.Base(opts.Vital), Path: filepath.ToSlash(opts.Vital)}},
+	}
+	format, mediaType := "csv", "text/csv"
+	if opts.Delimiter == '\t' {
+		format, mediaType = "tsv", "text/tab-separated-values"
+	}
+	for _, out := range outputsOf(opts, paths) {
+		r := dataPackageResource{
+			Name:      out.name,
+			Profile:   "tabular-data-resource",
+			Format:    format,
+			MediaType: mediaType,
+			Encoding:  "utf-8",
+		}
+		r.Path = relPaths(dir, out.paths)
+		if len(out.paths) == 1 {
+			r.Path = relPaths(dir, out.paths)[0]
+		}
+		if opts.Compression != nil {
+			r.Compression = strings.TrimPrefix(opts.Compression.ext, ".")
+		}
+		r.Dialect.Delimiter = string(opts.Delimiter)
+		for _, c := range columnsOf(out, opts) {
+			f := dataPackageField{Name: c.name, Type: c.kind, Description: c.description, Unit: c.unit}
+			if c.kind == "datetime" {
+				f.Format = "any"
+			}
+			r.Schema.Fields = append(r.Schema.Fields, f)
+		}
+		dp.Resources = append(dp.Resources, r)
+	}
+
+	b, err := json.MarshalIndent(dp, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := DATAPACKAGE_FILE
+	if opts.Batch {
+		file = opts.Name + "." + DATAPACKAGE_FILE
+	}
+	return writeOutput(joinOutput(dir, file), append(b, '\n'))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+)
+
+// Modes of -dedup for the rows of databases synced twice: "exact" drops
+// the rows repeating another of the same time and z_fok_timestamp with
+// the same value, "fuzzy" those repeating its time and z_fok_timestamp
+// whatever their value, keeping the first.
+var dedupModes = []string{"exact", "fuzzy"}
+
+// dedupRows drops the duplicate rows of a vital database, which are
+// read next to each other in (timestamp, zfok_timestamp) order, and logs
+// how many were dropped when closed.
+type dedupRows struct {
+	rowScanner
+	fuzzy   bool
+	label   string
+	kept    []vitalRow // of the key of the last row kept
+	cur     vitalRow
+	err     error
+	dropped int
+}
+
+func newDedupRows(rows rowScanner, mode, label string) *dedupRows {
+	return &dedupRows{rowScanner: rows, fuzzy: mode == "fuzzy", label: label}
+}
+
+func (d *dedupRows) Next() bool {
+	for d.rowScanner.Next() {
+		var r vitalRow
+		if d.err = d.rowScanner.StructScan(&r); d.err != nil {
+			return true
+		}
+		if d.duplicate(r) {
+			d.dropped++
+			continue
+		}
+		d.cur = r
+		return true
+	}
+	return false
+}
+
+// duplicate reports whether r repeats a row kept, and keeps it if not.
+func (d *dedupRows) duplicate(r vitalRow) bool {
+	if len(d.kept) > 0 {
+		k := d.kept[0]
+		if r.Ztime != k.Ztime || r.Nanos != k.Nanos || r.ZFokTimestamp != k.ZFokTimestamp {
+			d.kept = d.kept[:0]
+		}
+	}
+	for _, k := range d.kept {
+		if d.fuzzy || r.Value == k.Value {
+			return true
+		}
+	}
+	d.kept = append(d.kept, r)
+	return false
+}
+
+func (d *dedupRows) StructScan(dest interface{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.cur.scan(dest)
+	return nil
+}
+
+func (d *dedupRows) Close() error {
+	if d.dropped > 0 {
+		log.Printf("%s: dropped %d duplicate rows", d.label, d.dropped)
+	}
+	return d.rowScanner.Close()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DOWNLOAD_RETRIES    = 5
+	DOWNLOAD_RETRY_WAIT = 2 * time.Second
+	DOWNLOAD_NAME       = "download" // name of the output files of URLs without a file name
+)
+
+// isURL reports whether the input is a database to download.
+func isURL(p string) bool {
+	lp := strings.ToLower(p)
+	return strings.HasPrefix(lp, "https://") || strings.HasPrefix(lp, "http://")
+}
+
+// download fetches the database at rawURL into a temporary file and calls
+// f with it, like extract. An interrupted download is resumed with a
+// range request, as long as the server still has the same file. The file
+// is verified against the SHA-256 given as URL fragment (#sha256=hex) and
+// the one the server sends in a Repr-Digest or Digest header.
+func download(rawURL string, f func(name, vital string)) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	want, err := fragmentChecksum(u.Fragment)
+	if err != nil {
+		return err
+	}
+	u.Fragment = ""
+
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = DOWNLOAD_NAME + VITAL_FILE_EXT
+	}
+	ext := path.Ext(name)
+	if ext == "" {
+		ext = VITAL_FILE_EXT
+	}
+	tmp, err := os.CreateTemp("", "vital2csv-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	served, err := fetch(u.String(), tmp, h)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	sum := h.Sum(nil)
+	for _, c := range [][]byte{want, served} {
+		if c != nil && !bytes.Equal(c, sum) {
+			return fmt.Errorf("Checksum mismatch: got sha256 %x, want %x", sum, c)
+		}
+	}
+	f(name, tmp.Name())
+	return nil
+}
+
+// fetch writes the body of u to tmp and h, resuming after errors. A
+// partial response that does not start where the file ends, as from a
+// server ignoring the range, fetches the file again from its start.
+// Transport errors and server errors (5xx) are retried up to
+// DOWNLOAD_RETRIES times, waiting twice as long after each. It returns
+// the SHA-256 the server sent, if any.
+func fetch(u string, tmp *os.File, h hash.Hash) ([]byte, error) {
+	var (
+		n         int64
+		validator string
+		served    []byte
+	)
+	restart := func() error {
+		n = 0
+		h.Reset()
+		if err := tmp.Truncate(0); err != nil {
+			return err
+		}
+		_, err := tmp.Seek(0, 0)
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", n))
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			switch {
+			case n > 0 && resp.StatusCode == http.StatusPartialContent:
+				cr := resp.Header.Get("Content-Range")
+				if start, ok := contentRangeStart(cr); !ok || start != n {
+					err = fmt.Errorf("GET %s: Content-Range %q does not resume at byte %d", u, cr, n)
+					if rerr := restart(); rerr != nil {
+						resp.Body.Close()
+						return nil, rerr
+					}
+				}
+			case resp.StatusCode == http.StatusOK:
+				// First request, or the file changed and is sent again.
+				if err := restart(); err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
+				validator = resp.Header.Get("ETag")
+				if validator == "" || strings.HasPrefix(validator, "W/") {
+					validator = resp.Header.Get("Last-Modified")
+				}
+				served, err = headerChecksum(resp.Header)
+				if err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
+			case resp.StatusCode >= 500:
+				err = fmt.Errorf("GET %s: %s", u, resp.Status)
+			default:
+				resp.Body.Close()
+				return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+			}
+			if err == nil {
+				var c int64
+				c, err = io.Copy(tmp, io.TeeReader(resp.Body, h))
+				n += c
+			}
+			resp.Body.Close()
+			if err == nil {
+				return served, nil
+			}
+		}
+		if attempt == DOWNLOAD_RETRIES {
+			return nil, err
+		}
+		wait := DOWNLOAD_RETRY_WAIT << attempt
+		log.Printf("Download: %v, resuming at byte %d in %v", err, n, wait)
+		time.Sleep(wait)
+	}
+}
+
+// contentRangeStart returns the first byte of a "bytes first-last/size"
+// Content-Range header.
+func contentRangeStart(cr string) (int64, bool) {
+	r, ok := strings.CutPrefix(cr, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(r, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// fragmentChecksum parses a "sha256=hex" URL fragment.
+func fragmentChecksum(fragment string) ([]byte, error) {
+	if fragment == "" {
+		return nil, nil
+	}
+	hx, ok := strings.CutPrefix(fragment, "sha256=")
+	if !ok {
+		return nil, fmt.Errorf("Unknown checksum: %s", fragment)
+	}
+	return hex.DecodeString(hx)
+}
+
+// headerChecksum returns the sha-256 of a Repr-Digest (RFC 9530) or
+// Digest (RFC 3230) header.
+func headerChecksum(hdr http.Header) ([]byte, error) {
+	for _, name := range []string{"Repr-Digest", "Digest"} {
+		for _, d := range strings.Split(hdr.Get(name), ",") {
+			alg, v, ok := strings.Cut(strings.TrimSpace(d), "=")
+			if ok && strings.EqualFold(alg, "sha-256") {
+				return base64.StdEncoding.DecodeString(strings.Trim(v, ":"))
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// downsampleEncoder keeps every nth sample of a signal, low-pass filtered
+// against aliasing first: by a FIR filter of 20n+1 taps, a Hamming
+// windowed sinc cut off at the Nyquist frequency of the rate kept, as
+// scipy.signal.decimate does. The filter is centered on the sample kept,
+// so the samples keep their times. Samples more than a second of ztime
+// apart are a gap, at which the filter starts again; at both ends of a
+// run the first and last samples are repeated.
+//
+// The samples kept are passed on as rows of their type, with the quality
+// of the sample unfiltered. flush writes those held back for the filter.
+type downsampleEncoder struct {
+	encoder
+	n     int
+	taps  []float64 // of the samples from -m to m around the sample kept
+	rows  interface{}
+	buf   []resamplePoint
+	first int // index in the run of the first sample held
+	next  int // index in the run of the next sample kept
+	out   []resamplePoint
+}
+
+func newDownsampleEncoder(enc encoder, n int) *downsampleEncoder {
+	m := 10 * n
+	taps := make([]float64, 2*m+1)
+	fc := 0.5 / float64(n)
+	sum := 0.0
+	for k := -m; k <= m; k++ {
+		h := 2 * fc
+		if k != 0 {
+			h = math.Sin(2*math.Pi*fc*float64(k)) / (math.Pi * float64(k))
+		}
+		h *= 0.54 + 0.46*math.Cos(math.Pi*float64(k)/float64(m))
+		taps[k+m] = h
+		sum += h
+	}
+	for i := range taps {
+		taps[i] /= sum
+	}
+	return &downsampleEncoder{encoder: enc, n: n, taps: taps}
+}
+
+func (e *downsampleEncoder) Encode(s *signal, v interface{}) error {
+	e.rows = v
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		p := resamplePoint{detailed, ztime, zfok, append([]float64(nil), vs...)}
+		if n := len(e.buf); n > 0 && p.ztime-e.buf[n-1].ztime > 1 {
+			if err := e.filter(s, true); err != nil {
+				return err
+			}
+			e.buf, e.first, e.next = e.buf[:0], 0, 0
+		}
+		e.buf = append(e.buf, p)
+		return e.filter(s, false)
+	})
+}
+
+// filter writes the samples kept whose filter has all its samples held,
+// or up to the last sample held if final.
+func (e *downsampleEncoder) filter(s *signal, final bool) error {
+	m := len(e.taps) / 2
+	end := e.first + len(e.buf) // index after the last sample held
+	for e.next < end && (final || e.next+m < end) {
+		c := e.buf[e.next-e.first]
+		p := resamplePoint{t: c.t, ztime: c.ztime, zfok: c.zfok, vs: make([]float64, len(c.vs))}
+		for ch := range p.vs {
+			// Samples without the value, such as axes missing, are left
+			// out of the sum.
+			var v, w float64
+			for k, h := range e.taps {
+				j := min(max(e.next+k-m, 0), end-1)
+				if x := e.buf[j-e.first].vs[ch]; !math.IsNaN(x) {
+					v += h * x
+					w += h
+				}
+			}
+			p.vs[ch] = math.NaN()
+			if w != 0 {
+				p.vs[ch] = v / w
+			}
+		}
+		// The quality of SpO2 is not filtered.
+		if len(p.vs) == 2 {
+			p.vs[1] = c.vs[1]
+		}
+		e.out = append(e.out, p)
+		e.next += e.n
+		if err := writeSeconds(e.encoder, s, e.rows, &e.out, false); err != nil {
+			return err
+		}
+	}
+	// Keep the samples the filter of the next one needs.
+	if drop := e.next - m - e.first; drop > 0 {
+		drop = min(drop, len(e.buf)-1)
+		e.buf = append(e.buf[:0], e.buf[drop:]...)
+		e.first += drop
+	}
+	return nil
+}
+
+// flush writes the samples kept up to the last.
+func (e *downsampleEncoder) flush(s *signal) error {
+	if len(e.buf) > 0 {
+		if err := e.filter(s, true); err != nil {
+			return err
+		}
+	}
+	return writeSeconds(e.encoder, s, e.rows, &e.out, true)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// clockSync is a sync of the clock of the device with that of the phone:
+// at time t, as recorded, the times recorded are behind the phone by
+// offset.
+type clockSync struct {
+	t      time.Time
+	offset time.Duration
+}
+
+// syncRecord is a row of the syncs statement of a vitalSchema, with the
+// time as stored in the database and the offset in milliseconds.
+type syncRecord struct {
+	Time   float64 `db:"ztime"`
+	Offset float64 `db:"offset"`
+}
+
+// hasSyncs reports whether a schema knows the clock sync table.
+func hasSyncs() bool {
+	for _, s := range vitalSchemas {
+		if s.syncs != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// driftCorrection corrects the times recorded by the clock syncs, in time
+// order, for -drift-correction: the offset is interpolated linearly
+// between the syncs, and held before the first and after the last.
+type driftCorrection []clockSync
+
+// correct returns the time recorded t corrected.
+func (d driftCorrection) correct(t time.Time) time.Time {
+	if len(d) == 0 {
+		return t
+	}
+	i := sort.Search(len(d), func(i int) bool { return d[i].t.After(t) })
+	switch i {
+	case 0:
+		return t.Add(d[0].offset)
+	case len(d):
+		return t.Add(d[i-1].offset)
+	}
+	a, b := d[i-1], d[i]
+	f := float64(t.Sub(a.t)) / float64(b.t.Sub(a.t))
+	return t.Add(a.offset + time.Duration(math.Round(f*float64(b.offset-a.offset))))
+}
+
+// syncs returns the clock syncs of the databases in time order.
+func (s *vitalSource) syncs() (driftCorrection, error) {
+	var d driftCorrection
+	for i, db := range s.dbs {
+		if s.schemas[i].syncs == "" {
+			continue
+		}
+		var recs []syncRecord
+		if err := db.Select(&recs, s.schemas[i].syncs); err != nil {
+			return nil, err
+		}
+		for _, r := range recs {
+			row := vitalRecord{Time: r.Time}.row(s.epochs[i])
+			offset := time.Duration(math.Round(r.Offset * float64(time.Millisecond)))
+			d = append(d, clockSync{time.Unix(row.Ztime, row.Nanos), offset})
+		}
+	}
+	sort.SliceStable(d, func(i, j int) bool { return d[i].t.Before(d[j].t) })
+	return d, nil
+}
+
+// setDriftCorrection reads the clock syncs by which the times of s are
+// corrected, and logs the range of their offsets.
+func (s *vitalSource) setDriftCorrection() error {
+	d, err := s.syncs()
+	if err != nil {
+		return err
+	}
+	if len(d) == 0 {
+		log.Print("No clock syncs are recorded, times are not corrected")
+		return nil
+	}
+	lo, hi := d[0].offset, d[0].offset
+	for _, c := range d {
+		lo, hi = min(lo, c.offset), max(hi, c.offset)
+	}
+	log.Printf("Correcting clock drift by %d syncs, offsets from %v to %v", len(d), lo, hi)
+	s.drift = d
+	return nil
+}
+
+// driftRows corrects the times of the rows of a vital database.
+type driftRows struct {
+	rowScanner
+	drift driftCorrection
+	row   vitalRow
+	err   error
+}
+
+func (r *driftRows) Next() bool {
+	if !r.rowScanner.Next() {
+		return false
+	}
+	if r.err = r.rowScanner.StructScan(&r.row); r.err == nil {
+		t := r.drift.correct(time.Unix(r.row.Ztime, r.row.Nanos))
+		r.row.Ztime, r.row.Nanos = t.Unix(), int64(t.Nanosecond())
+	}
+	return true
+}
+
+func (r *driftRows) StructScan(dest interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.row.scan(dest)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// recordingSpan returns the times of the first and the last second
+// logged in the databases, within the window read. ok is false if none
+// is logged.
+func (s *vitalSource) recordingSpan() (from, to time.Time, ok bool, err error) {
+	for i, db := range s.dbs {
+		var first, last sql.NullFloat64
+		if err := db.QueryRow(s.schemas[i].span).Scan(&first, &last); err != nil {
+			return from, to, false, err
+		}
+		if !first.Valid {
+			continue
+		}
+		f := vitalRecord{Time: first.Float64}.row(s.epochs[i])
+		l := vitalRecord{Time: last.Float64}.row(s.epochs[i])
+		ft, lt := time.Unix(f.Ztime, f.Nanos), time.Unix(l.Ztime, l.Nanos)
+		if !ok || ft.Before(from) {
+			from = ft
+		}
+		if !ok || lt.After(to) {
+			to = lt
+		}
+		ok = true
+	}
+	if s.window.bounded() && ok {
+		if !s.window.from.IsZero() && from.Before(s.window.from) {
+			from = s.window.from
+		}
+		if !s.window.to.IsZero() && to.After(s.window.to) {
+			to = s.window.to
+		}
+	}
+	return from, to, ok, nil
+}
+
+// offsetChanges reports whether the UTC offset of loc changes between
+// from and to, as at a daylight saving time transition. Transitions are
+// at least hours apart, so the offset is compared hour by hour.
+func offsetChanges(loc *time.Location, from, to time.Time) bool {
+	_, offset := from.In(loc).Zone()
+	for t := from; ; t = t.Add(time.Hour) {
+		if t.After(to) {
+			t = to
+		}
+		if _, o := t.In(loc).Zone(); o != offset {
+			return true
+		}
+		if !t.Before(to) {
+			return false
+		}
+	}
+}
+
+// dstSafeLayout returns the time format of the times of the recording
+// of src: the local times of recordings spanning a change of the UTC
+// offset, whose local times repeat or skip an hour, are written with
+// their offset so that they stay unambiguous and in order. Times are
+// Unix times, which have no leap seconds, so they are continuous across
+// them too.
+func dstSafeLayout(src source) (timeFormat, error) {
+	vs, ok := src.(*vitalSource)
+	if !ok || timeLayout != timeFormats["local"] {
+		return timeLayout, nil
+	}
+	from, to, ok, err := vs.recordingSpan()
+	if err != nil || !ok || !offsetChanges(outputZone, from, to) {
+		return timeLayout, err
+	}
+	log.Printf("The recording spans a change of the UTC offset of %s, times are written with their offset", outputZone)
+	return timeFormats["local-offset"], nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EDF_EQUIPMENT         = "vital2csv"
+	EDF_RECORD_DURATION   = 1
+	EDF_HEADER_FIELD_SIZE = 256
+)
+
+// edfVariant holds what differs between EDF+ and its 24 bit BioSemi
+// counterpart BDF+.
+type edfVariant struct {
+	version         string
+	kind            string // prefix of the reserved field and annotation label
+	sampleBytes     int
+	digitalMin      int
+	digitalMax      int
+	annotationBytes int // a multiple of sampleBytes
+}
+
+var (
+	edfPlus = edfVariant{
+		version: "0", kind: "EDF", sampleBytes: 2,
+		digitalMin: -32768, digitalMax: 32767, annotationBytes: 64,
+	}
+	bdfPlus = edfVariant{
+		version: "\xffBIOSEMI", kind: "BDF", sampleBytes: 3,
+		digitalMin: -8388608, digitalMax: 8388607, annotationBytes: 63,
+	}
+)
+
+// edfPatient is the patient of the EDF+ header, by its subfields: the
+// code, sex (F or M), birthdate (dd-MMM-yyyy) and name. Those that are
+// "" are unknown.
+type edfPatient struct {
+	code, sex, birthdate, name string
+}
+
+// edfEncoder writes EDF+ or BDF+ files. Each second of the recording
+// becomes one data record. EDF requires a fixed number of samples per data
+// record, so each second is resampled to the most frequent per-second
+// sample count. Missing seconds are expressed with EDF+D time-keeping
+// annotations. The events of -events are annotated in the data record of
+// their second, or the last one before it. The recording name is the
+// administration code of the recording field.
+type edfEncoder struct {
+	spool
+	edfVariant
+	w       io.Writer
+	name    string
+	patient edfPatient
+	events  []event
+}
+
+func newEDFEncoder(w io.Writer, opts *Options) encoder {
+	return &edfEncoder{edfVariant: edfPlus, w: w, name: opts.Name, patient: opts.Patient, events: opts.Markers}
+}
+
+func newBDFEncoder(w io.Writer, opts *Options) encoder {
+	return &edfEncoder{edfVariant: bdfPlus, w: w, name: opts.Name, patient: opts.Patient, events: opts.Markers}
+}
+
+func (e *edfEncoder) Header(s *signal, v interface{}) error {
+	return e.open(s)
+}
+
+func (e *edfEncoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *edfEncoder) Close() error {
+	defer e.release()
+
+	rate := e.sampleRate()
+	tals := e.annotations()
+	for _, sec := range e.seconds {
+		if n := len(e.timeKeeping(sec.ztime-e.seconds[0].ztime) + tals[sec.ztime]); n > e.annotationBytes {
+			e.annotationBytes = (n + e.sampleBytes - 1) / e.sampleBytes * e.sampleBytes
+		}
+	}
+	w := bufio.NewWriter(e.w)
+	if _, err := w.WriteString(e.header(rate)); err != nil {
+		return err
+	}
+
+	sample := make([]byte, e.sampleBytes)
+	err := e.each(rate, func(sec spoolSecond, chs [][]float64) error {
+		for i, ch := range chs {
+			for _, x := range ch {
+				// Little endian two's complement of sampleBytes bytes.
+				d := int32(e.digital(i, x))
+				for k := range sample {
+					sample[k] = byte(d >> (8 * k))
+				}
+				if _, err := w.Write(sample); err != nil {
+					return err
+				}
+			}
+		}
+		_, err := w.Write(e.annotation(sec.ztime-e.seconds[0].ztime, tals[sec.ztime]))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// digital scales v to the digital range. EDF has no missing values, so
+// NaN is written as the digital minimum.
+func (e *edfEncoder) digital(i int, v float64) float64 {
+	min, max := e.physical(i)
+	dmin, dmax := float64(e.digitalMin), float64(e.digitalMax)
+	if math.IsNaN(v) {
+		return dmin
+	}
+	d := (v-min)/(max-min)*(dmax-dmin) + dmin
+	return math.Max(dmin, math.Min(dmax, math.Round(d)))
+}
+
+func (e *edfEncoder) header(rate int) string {
+	var start time.Time
+	if len(e.seconds) > 0 {
+		start = time.Unix(e.seconds[0].ztime, 0).In(outputZone)
+	}
+
+	reserved := e.kind + "+C"
+	for i := 1; i < len(e.seconds); i++ {
+		if e.seconds[i].ztime-e.seconds[i-1].ztime != EDF_RECORD_DURATION {
+			reserved = e.kind + "+D"
+			break
+		}
+	}
+
+	ns := len(e.channels) + 1
+	var b strings.Builder
+	b.WriteString(edfField(e.version, 8))
+	p := e.patient
+	b.WriteString(edfField(strings.Join([]string{edfSubfield(p.code), edfSubfield(p.sex), edfSubfield(p.birthdate), edfSubfield(p.name)}, " "), 80))
+	b.WriteString(edfField("Startdate "+strings.ToUpper(start.Format("02-Jan-2006"))+" "+edfSubfield(e.name)+" X "+EDF_EQUIPMENT, 80))
+	b.WriteString(edfField(start.Format("02.01.06"), 8))
+	b.WriteString(edfField(start.Format("15.04.05"), 8))
+	b.WriteString(edfField(strconv.Itoa(EDF_HEADER_FIELD_SIZE*(ns+1)), 8))
+	b.WriteString(edfField(reserved, 44))
+	b.WriteString(edfField(strconv.Itoa(len(e.seconds)), 8))
+	b.WriteString(edfField(strconv.Itoa(EDF_RECORD_DURATION), 8))
+	b.WriteString(edfField(strconv.Itoa(ns), 4))
+
+	each := func(width int, f func(i int) string) {
+		for i := 0; i < ns; i++ {
+			b.WriteString(edfField(f(i), width))
+		}
+	}
+	annotation := func(i int) bool { return i == ns-1 }
+	each(16, func(i int) string {
+		if annotation(i) {
+			return e.kind + " Annotations"
+		}
+		return e.channels[i].label
+	})
+	each(80, func(i int) string { return "" })
+	each(8, func(i int) string {
+		if annotation(i) {
+			return ""
+		}
+		return asciiUnit(e.channels[i].unit)
+	})
+	each(8, func(i int) string {
+		if annotation(i) {
+			return "-1"
+		}
+		min, _ := e.physical(i)
+		return edfNumber(min)
+	})
+	each(8, func(i int) string {
+		if annotation(i) {
+			return "1"
+		}
+		_, max := e.physical(i)
+		return edfNumber(max)
+	})
+	each(8, func(i int) string { return strconv.Itoa(e.digitalMin) })
+	each(8, func(i int) string { return strconv.Itoa(e.digitalMax) })
+	each(80, func(i int) string { return "" })
+	each(8, func(i int) string {
+		if annotation(i) {
+			return strconv.Itoa(e.annotationBytes / e.sampleBytes)
+		}
+		return strconv.Itoa(rate * EDF_RECORD_DURATION)
+	})
+	each(32, func(i int) string { return "" })
+
+	return b.String()
+}
+
+// annotations returns the TALs of the events by the time of the data
+// record they are annotated in. The annotation signal is sized so that
+// the record with the most events holds them.
+func (e *edfEncoder) annotations() map[int64]string {
+	tals := make(map[int64]string)
+	if len(e.seconds) == 0 {
+		return tals
+	}
+	start := time.Unix(e.seconds[0].ztime, 0)
+	i := 0
+	for _, ev := range e.events {
+		for i+1 < len(e.seconds) && e.seconds[i+1].ztime <= ev.time.Unix() {
+			i++
+		}
+		onset := strconv.FormatFloat(ev.time.Sub(start).Seconds(), 'f', -1, 64)
+		if !strings.HasPrefix(onset, "-") {
+			onset = "+" + onset
+		}
+		tals[e.seconds[i].ztime] += onset + "\x14" + edfAnnotationText(ev.label) + "\x14\x00"
+	}
+	return tals
+}
+
+// timeKeeping returns the time-keeping TAL of a data record, i.e. the
+// record onset relative to the start.
+func (v edfVariant) timeKeeping(onset int64) string {
+	return fmt.Sprintf("+%d\x14\x14\x00", onset)
+}
+
+// annotation returns the annotation signal of a data record: the
+// time-keeping TAL followed by the TALs of its events.
+func (v edfVariant) annotation(onset int64, tals string) []byte {
+	b := make([]byte, v.annotationBytes)
+	copy(b, v.timeKeeping(onset)+tals)
+	return b
+}
+
+// edfAnnotationText replaces the bytes that delimit TALs, which cannot
+// occur in the text of an annotation.
+func edfAnnotationText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func edfField(s string, width int) string {
+	if len(s) > width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// edfSubfield replaces spaces, which separate EDF+ subfields.
+func edfSubfield(s string) string {
+	if s == "" {
+		return "X"
+	}
+	return strings.ReplaceAll(s, " ", "_")
+}
+
+// edfNumber formats v with as many decimals as fit in an 8 byte field.
+func edfNumber(v float64) string {
+	for prec := 7; prec > 0; prec-- {
+		if s := strconv.FormatFloat(v, 'f', prec, 64); len(s) <= 8 {
+			return s
+		}
+	}
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+// encoder writes batches of samples of signal s to an output stream.
+// Header and Encode receive a pointer to a slice of the row type of s,
+// Ecg, Accel or Spo2 (or Sample for the combined long format). Each
+// Encode call holds the samples of a single second.
+//
+// Encoders of single file formats receive all signals from concurrent
+// goroutines, and Header is called once per signal. Close is called once
+// all signals are written.
+type encoder interface {
+	Header(s *signal, v interface{}) error
+	Encode(s *signal, v interface{}) error
+	Close() error
+}
+
+type format struct {
+	ext     string
+	encoder func(w io.Writer, opts *Options) encoder
+	single  bool // all signals are written to one file
+	ecgOnly bool // signals other than ECG are not exported
+	long    bool // supports the combined long format
+	local   bool // written by path to a local, uncompressed file
+	record  bool // base names of the files are WFDB record names
+	patient bool // the header records the patient of the session tables
+}
+
+var formats = map[string]format{
+	"csv":         {ext: ".csv", encoder: newCSVEncoder, long: true},
+	"jsonl":       {ext: ".jsonl", encoder: newJSONLEncoder, long: true},
+	"parquet":     {ext: ".parquet", encoder: newParquetEncoder, long: true},
+	"edf":         {ext: ".edf", encoder: newEDFEncoder, patient: true},
+	"bdf":         {ext: ".bdf", encoder: newBDFEncoder, patient: true},
+	"wfdb":        {ext: ".dat", encoder: newWFDBEncoder, local: true, record: true},
+	"hdf5":        {ext: ".h5", encoder: newHDF5Encoder, local: true},
+	"arrow":       {ext: ".arrow", encoder: newArrowEncoder},
+	"xlsx":        {ext: ".xlsx", encoder: newXLSXEncoder, single: true},
+	"fhir":        {ext: ".fhir.json", encoder: newFHIREncoder},
+	"aecg":        {ext: ".xml", encoder: newAECGEncoder, ecgOnly: true},
+	"influx":      {ext: ".lp", encoder: newInfluxEncoder, single: true},
+	"sqlite":      {ext: ".sqlite", encoder: newSQLiteEncoder, single: true, local: true},
+	"msgpack":     {ext: ".msgpack", encoder: newMsgpackEncoder},
+	"avro":        {ext: ".avro", encoder: newAvroEncoder},
+	"mat":         {ext: ".mat", encoder: newMATEncoder},
+	"npz":         {ext: ".npz", encoder: newNPZEncoder},
+	"opensignals": {ext: ".txt", encoder: newOpenSignalsEncoder},
+	"protobuf":    {ext: ".pb", encoder: newProtobufEncoder},
+}
+
+func formatNames() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// outputPath returns the path of the output file for formats that are
+// written by path rather than through w.
+func outputPath(w io.Writer, format string) (string, error) {
+	f, ok := w.(*os.File)
+	if !ok || f == os.Stdout {
+		return "", fmt.Errorf("%s output requires a file", format)
+	}
+	return f.Name(), nil
+}
+
+type channel struct {
+	name     string // column name
+	label    string
+	unit     string
+	optional bool // NaN where a sample has no value
+}
+
+// asciiUnits spell the units that are not ASCII, for the headers of
+// formats limited to ASCII (EDF, WFDB).
+var asciiUnits = map[string]string{
+	"°C":   "degC",
+	"µT":   "uT",
+	"µV":   "uV",
+	"m/s²": "m/s^2",
+}
+
+func asciiUnit(unit string) string {
+	if u, ok := asciiUnits[unit]; ok {
+		return u
+	}
+	return unit
+}
+
+// eachSample calls f with the time and channel values of every sample
+// in v.
+func eachSample(v interface{}, f func(ztime int64, vs ...float64) error) error {
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		return f(ztime, vs...)
+	})
+}
+
+// eachRow calls f with the times and the channel values, in channel
+// order, of every row in v. A missing SpO2 quality is passed as NaN.
+func eachRow(v interface{}, f func(ztime, zfok int64, detailed time.Time, vs ...float64) error) error {
+	switch rs := v.(type) {
+	case *[]Ecg:
+		for _, r := range *rs {
+			if err := f(r.Ztime, r.ZFokTimestamp, r.Detailed, r.Zvalue); err != nil {
+				return err
+			}
+		}
+	case *[]Accel:
+		for _, r := range *rs {
+			if err := f(r.Ztime, r.ZFokTimestamp, r.Detailed, float64(r.X), float64(r.Y), float64(r.Z)); err != nil {
+				return err
+			}
+		}
+	case *[]Spo2:
+		for _, r := range *rs {
+			quality := math.NaN()
+			if r.Quality != nil {
+				quality = *r.Quality
+			}
+			if err := f(r.Ztime, r.ZFokTimestamp, r.Detailed, r.Zvalue, quality); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type csvEncoder struct {
+	w gocsv.CSVWriter
+}
+
+func newCSVEncoder(w io.Writer, opts *Options) encoder {
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.Delimiter
+	e := &csvEncoder{w: gocsv.NewSafeCSVWriter(cw)}
+	if len(opts.Columns) > 0 {
+		e.w = &columnWriter{CSVWriter: e.w, columns: opts.Columns}
+	}
+	return e
+}
+
+// columnWriter writes the selected columns in the order given. The first
+// row is the header, which maps the column names to their positions;
+// selected columns the signal does not have are left out.
+type columnWriter struct {
+	gocsv.CSVWriter
+	columns []string
+	index   []int
+	row     []string
+}
+
+func (w *columnWriter) Write(row []string) error {
+	if w.index == nil {
+		for _, c := range w.columns {
+			for i, h := range row {
+				if c == h {
+					w.index = append(w.index, i)
+				}
+			}
+		}
+		if len(w.index) == 0 {
+			return fmt.Errorf("None of the columns are found in: %s", strings.Join(row, ","))
+		}
+		w.row = make([]string, len(w.index))
+	}
+	for i, j := range w.index {
+		w.row[i] = row[j]
+	}
+	return w.CSVWriter.Write(w.row)
+}
+
+// csvColumns returns the names of all columns of the csv output.
+func csvColumns() []string {
+	names := []string{SAMPLE_INDEX_COLUMN}
+	seen := make(map[string]bool)
+	for _, v := range []interface{}{Ecg{}, Accel{}, Spo2{}, Sample{}} {
+		t := reflect.TypeOf(v)
+		for i := 0; i < t.NumField(); i++ {
+			name := t.Field(i).Tag.Get("csv")
+			if name != "" && name != "-" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return append(names, FILTERED_COLUMN, MAGNITUDE_COLUMN, ENMO_COLUMN, X_SMOOTH_COLUMN, Y_SMOOTH_COLUMN, Z_SMOOTH_COLUMN)
+}
+
+func (e *csvEncoder) Header(s *signal, v interface{}) error {
+	return gocsv.MarshalCSV(v, e.w)
+}
+
+func (e *csvEncoder) Encode(s *signal, v interface{}) error {
+	return gocsv.MarshalCSVWithoutHeaders(v, e.w)
+}
+
+func (e *csvEncoder) Close() error {
+	return nil
+}
+
+// jsonlEncoder writes one JSON object per sample (JSON Lines / NDJSON).
+type jsonlEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLEncoder(w io.Writer, opts *Options) encoder {
+	bw := bufio.NewWriter(w)
+	return &jsonlEncoder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (e *jsonlEncoder) Header(s *signal, v interface{}) error {
+	return nil
+}
+
+func (e *jsonlEncoder) Encode(s *signal, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for i := 0; i < rv.Len(); i++ {
+		if err := e.enc.Encode(rv.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *jsonlEncoder) Close() error {
+	return e.w.Flush()
+}
+
+// countingEncoder counts the samples passed to the encoder of a signal.
+type countingEncoder struct {
+	encoder
+	n *int64
+}
+
+func (e *countingEncoder) Encode(s *signal, v interface{}) error {
+	*e.n += int64(reflect.Indirect(reflect.ValueOf(v)).Len())
+	return e.encoder.Encode(s, v)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statistics of -epoch-stats, of the values of each channel in an epoch.
+// std is the population standard deviation.
+var epochStatistics = []string{"mean", "min", "max", "std"}
+
+// parseEpochStats parses -epoch-stats, comma separated statistics.
+func parseEpochStats(spec string) ([]string, error) {
+	var stats []string
+	for _, stat := range strings.Split(spec, ",") {
+		stat = strings.TrimSpace(stat)
+		if !slices.Contains(epochStatistics, stat) {
+			return nil, fmt.Errorf("Unknown statistic of -epoch-stats: %q, give %s", stat, strings.Join(epochStatistics, ", "))
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// epochSummary is the running summary of a channel in an epoch, by
+// Welford's algorithm.
+type epochSummary struct {
+	n        int
+	mean, m2 float64
+	min, max float64
+}
+
+func (e *epochSummary) add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if e.n == 0 {
+		e.min, e.max = v, v
+	}
+	e.n++
+	d := v - e.mean
+	e.mean += d / float64(e.n)
+	e.m2 += d * (v - e.mean)
+	e.min, e.max = math.Min(e.min, v), math.Max(e.max, v)
+}
+
+func (e *epochSummary) stat(name string) float64 {
+	if e.n == 0 {
+		return math.NaN()
+	}
+	switch name {
+	case "mean":
+		return e.mean
+	case "min":
+		return e.min
+	case "max":
+		return e.max
+	}
+	return math.Sqrt(e.m2 / float64(e.n))
+}
+
+// epochEncoder writes the summaries of the samples of a signal by epochs
+// of -epoch instead of the samples, as csv: the start of the epoch, its
+// count of samples and the statistics of -epoch-stats of each channel,
+// in columns named <channel>_<statistic>. Epochs start at multiples of
+// their length since the Unix epoch; those without samples are left out.
+type epochEncoder struct {
+	w         *csv.Writer
+	s         *signal
+	length    time.Duration
+	stats     []string
+	start     time.Time
+	n         int
+	summaries []epochSummary
+}
+
+func newEpochEncoder(w io.Writer, opts *Options) encoder {
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.Delimiter
+	return &epochEncoder{w: cw, length: opts.Epoch, stats: opts.EpochStats}
+}
+
+func (e *epochEncoder) Header(s *signal, v interface{}) error {
+	header := []string{"time", "timestamp", "samples"}
+	for _, c := range s.channels() {
+		for _, stat := range e.stats {
+			header = append(header, c.name+"_"+stat)
+		}
+	}
+	e.s = s
+	e.summaries = make([]epochSummary, len(s.channels()))
+	return e.w.Write(header)
+}
+
+func (e *epochEncoder) Encode(s *signal, v interface{}) error {
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		start := detailed.Truncate(e.length)
+		if e.n > 0 && !start.Equal(e.start) {
+			if err := e.write(); err != nil {
+				return err
+			}
+		}
+		e.start = start
+		e.n++
+		for i, v := range vs {
+			e.summaries[i].add(v)
+		}
+		return nil
+	})
+}
+
+// write writes the summary of the current epoch.
+func (e *epochEncoder) write() error {
+	row := []string{
+		timeLayout.formatTime(e.start),
+		strconv.FormatInt(e.start.Unix(), 10),
+		strconv.Itoa(e.n),
+	}
+	for i := range e.summaries {
+		for _, stat := range e.stats {
+			v, field := e.summaries[i].stat(stat), ""
+			if !math.IsNaN(v) {
+				field = strconv.FormatFloat(e.s.roundValue(v), 'g', -1, 64)
+			}
+			row = append(row, field)
+		}
+		e.summaries[i] = epochSummary{}
+	}
+	e.n = 0
+	return e.w.Write(row)
+}
+
+func (e *epochEncoder) Close() error {
+	if e.n > 0 {
+		if err := e.write(); err != nil {
+			return err
+		}
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
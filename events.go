@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const EVENTS_FILE_SUFFIX = ".events.csv"
+
+// event is a marker recorded with the samples, such as a button press or
+// a symptom entered by the subject.
+type event struct {
+	time  time.Time
+	label string
+}
+
+// eventRecord is a row of the events statement of a vitalSchema, with the
+// time as stored in the database.
+type eventRecord struct {
+	Time  float64        `db:"ztime"`
+	Label sql.NullString `db:"label"`
+}
+
+// hasEvents reports whether a schema knows the event table.
+func hasEvents() bool {
+	for _, s := range vitalSchemas {
+		if s.events != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// events returns the events of the databases in time order.
+func (s *vitalSource) events() ([]event, error) {
+	var events []event
+	for i, db := range s.dbs {
+		if s.schemas[i].events == "" {
+			continue
+		}
+		var recs []eventRecord
+		if err := db.Select(&recs, s.schemas[i].events); err != nil {
+			return nil, err
+		}
+		for _, r := range recs {
+			row := vitalRecord{Time: r.Time}.row(s.epochs[i])
+			if t := s.drift.correct(time.Unix(row.Ztime, row.Nanos)); s.window.contains(t) {
+				events = append(events, event{t, r.Label.String})
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+	return events, nil
+}
+
+// writeEvents writes the events to <name>.events.csv, with the time
+// columns of the samples so that they can be aligned with them.
+func writeEvents(opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"time", "timestamp", "detailed_timestamp", "label"})
+	for _, e := range opts.Markers {
+		w.Write([]string{
+			timeLayout.formatTime(time.Unix(e.time.Unix(), 0)),
+			strconv.FormatInt(e.time.Unix(), 10),
+			timeLayout.formatDetailed(e.time),
+			e.label,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+EVENTS_FILE_SUFFIX), b.Bytes())
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FHIR_MDC_SYSTEM      = "urn:oid:2.16.840.1.113883.6.24"
+	FHIR_CATEGORY_SYSTEM = "http://terminology.hl7.org/CodeSystem/observation-category"
+	FHIR_UCUM_SYSTEM     = "http://unitsofmeasure.org"
+	FHIR_ID_SYSTEM       = "urn:vital2csv:"
+)
+
+// fhirUCUM maps the channel units to UCUM codes.
+var fhirUCUM = map[string]string{
+	"mV":    "mV",
+	"g":     "[g]",
+	"bpm":   "/min",
+	"%":     "%",
+	"°C":    "Cel",
+	"deg/s": "deg/s",
+	"µT":    "uT",
+	"count": "{count}",
+}
+
+// fhirCodes are the Observation codes of the signals, by signal name.
+// Other signals are coded by their label only.
+var fhirCodes = map[string]fhirCodeableConcept{
+	"ecg": {
+		Coding: []fhirCoding{{FHIR_MDC_SYSTEM, "131328", "MDC_ECG_ELEC_POTL"}},
+		Text:   "ECG",
+	},
+	"accel": {Text: "Accelerometer"},
+	"gyro":  {Text: "Gyroscope"},
+	"mag":   {Text: "Magnetometer"},
+	"hr": {
+		Coding: []fhirCoding{{FHIR_MDC_SYSTEM, "147842", "MDC_ECG_HEART_RATE"}},
+		Text:   "Heart rate",
+	},
+	"spo2": {
+		Coding: []fhirCoding{{FHIR_MDC_SYSTEM, "150456", "MDC_PULS_OXIM_SAT_O2"}},
+		Text:   "SpO2",
+	},
+}
+
+type fhirCoding struct {
+	System  string `json:"system"`
+	Code    string `json:"code"`
+	Display string `json:"display,omitempty"`
+}
+
+type fhirCodeableConcept struct {
+	Coding []fhirCoding `json:"coding,omitempty"`
+	Text   string       `json:"text,omitempty"`
+}
+
+type fhirIdentifier struct {
+	System string `json:"system"`
+	Value  string `json:"value"`
+}
+
+type fhirQuantity struct {
+	Value  float64 `json:"value"`
+	Unit   string  `json:"unit"`
+	System string  `json:"system"`
+	Code   string  `json:"code"`
+}
+
+type fhirSampledData struct {
+	Origin     fhirQuantity `json:"origin"`
+	Period     float64      `json:"period"`
+	Dimensions int          `json:"dimensions"`
+	Data       string       `json:"data"`
+}
+
+type fhirObservation struct {
+	ResourceType      string                `json:"resourceType"`
+	Identifier        []fhirIdentifier      `json:"identifier"`
+	Status            string                `json:"status"`
+	Category          []fhirCodeableConcept `json:"category"`
+	Code              fhirCodeableConcept   `json:"code"`
+	EffectiveDateTime string                `json:"effectiveDateTime"`
+	ValueSampledData  fhirSampledData       `json:"valueSampledData"`
+}
+
+type fhirRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type fhirEntry struct {
+	Resource fhirObservation `json:"resource"`
+	Request  fhirRequest     `json:"request"`
+}
+
+// fhirEncoder writes a FHIR R4 transaction Bundle with one Observation
+// per second of samples. The samples are held in SampledData, whose
+// period is derived from the number of samples in that second; the
+// channels of multi-axis signals are interleaved (dimensions > 1).
+type fhirEncoder struct {
+	w      *bufio.Writer
+	name   string
+	signal string
+	code   fhirCodeableConcept
+	unit   string
+	nc     int
+	n      int
+}
+
+func newFHIREncoder(w io.Writer, opts *Options) encoder {
+	return &fhirEncoder{w: bufio.NewWriter(w), name: opts.Name}
+}
+
+func (e *fhirEncoder) Header(s *signal, v interface{}) error {
+	code, ok := fhirCodes[s.name]
+	if !ok {
+		code = fhirCodeableConcept{Text: s.label}
+	}
+	e.signal, e.code = s.name, code
+	e.nc, e.unit = len(s.channels()), s.outputUnit()
+
+	_, err := e.w.WriteString(`{"resourceType":"Bundle","type":"transaction","entry":[`)
+	return err
+}
+
+func (e *fhirEncoder) Encode(s *signal, v interface{}) error {
+	var (
+		ztime int64
+		n     int
+		data  []string
+	)
+	err := eachSample(v, func(t int64, vs ...float64) error {
+		if n > 0 && t != ztime {
+			if err := e.observation(ztime, n, data); err != nil {
+				return err
+			}
+			n, data = 0, data[:0]
+		}
+		ztime = t
+		n++
+		for _, x := range vs {
+			if math.IsNaN(x) {
+				data = append(data, "E") // no valid value
+				continue
+			}
+			data = append(data, strconv.FormatFloat(x, 'g', -1, 64))
+		}
+		return nil
+	})
+	if err != nil || n == 0 {
+		return err
+	}
+	return e.observation(ztime, n, data)
+}
+
+func (e *fhirEncoder) observation(ztime int64, n int, data []string) error {
+	entry := fhirEntry{
+		Resource: fhirObservation{
+			ResourceType: "Observation",
+			Identifier: []fhirIdentifier{{
+				System: FHIR_ID_SYSTEM + e.name,
+				Value:  e.signal + "-" + strconv.FormatInt(ztime, 10),
+			}},
+			Status: "final",
+			Category: []fhirCodeableConcept{{
+				Coding: []fhirCoding{{System: FHIR_CATEGORY_SYSTEM, Code: "procedure"}},
+			}},
+			Code:              e.code,
+			EffectiveDateTime: time.Unix(ztime, 0).UTC().Format(time.RFC3339),
+			ValueSampledData: fhirSampledData{
+				Origin:     fhirQuantity{Value: 0, Unit: e.unit, System: FHIR_UCUM_SYSTEM, Code: fhirUCUM[e.unit]},
+				Period:     1000 / float64(n),
+				Dimensions: e.nc,
+				Data:       strings.Join(data, " "),
+			},
+		},
+		Request: fhirRequest{Method: "POST", URL: "Observation"},
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if e.n > 0 {
+		e.w.WriteByte(',')
+	}
+	e.n++
+	e.w.WriteByte('\n')
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *fhirEncoder) Close() error {
+	if _, err := e.w.WriteString("\n]}\n"); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
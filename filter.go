@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const FILTERED_COLUMN = "filtered"
+
+// Outputs of -filter-output: "replace" writes the filtered value instead
+// of the value, "column" next to it in a filtered column.
+var filterOutputs = []string{"replace", "column"}
+
+// filterSpec is the filter of -filter and -notch, passing the
+// frequencies from low up to high in Hz, but for those around notch. Each
+// is 0 if not filtered.
+type filterSpec struct {
+	low, high float64
+	notch     float64
+}
+
+// Quality factor of the notch filter: it rejects the band of a 60th of
+// its frequency around it, 0.8 Hz at 50 Hz.
+const NOTCH_Q = 60
+
+// mainsFrequencies are the frequencies of the power grid of the regions
+// of -notch: ISO 3166 country codes, and eu for Europe. Japan has both,
+// 50 Hz in its east and 60 Hz in its west.
+var mainsFrequencies = map[string]float64{
+	"eu": 50, "uk": 50, "ch": 50, "no": 50, "ru": 50, "tr": 50, "cn": 50, "in": 50,
+	"au": 50, "nz": 50, "za": 50, "ar": 50, "jp-east": 50,
+	"us": 60, "ca": 60, "mx": 60, "br": 60, "kr": 60, "tw": 60, "sa": 60, "ph": 60,
+	"jp-west": 60,
+}
+
+// parseNotch parses -notch, the frequency of the power grid in Hz or the
+// region it is of, and adds it to f.
+func parseNotch(notch string, f *filterSpec) (*filterSpec, error) {
+	if notch == "" {
+		return f, nil
+	}
+	hz, ok := mainsFrequencies[strings.ToLower(notch)]
+	switch {
+	case notch == "50":
+		hz = 50
+	case notch == "60":
+		hz = 60
+	case !ok:
+		return nil, fmt.Errorf("Invalid -notch %q, give 50, 60 or a region(%s)", notch, strings.Join(mainsRegions(), ", "))
+	}
+	if f == nil {
+		f = &filterSpec{}
+	}
+	f.notch = hz
+	return f, nil
+}
+
+func mainsRegions() []string {
+	regions := make([]string, 0, len(mainsFrequencies))
+	for r := range mainsFrequencies {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// parseFilter parses -filter: bandpass=low-high, highpass=low or
+// lowpass=high, in Hz.
+func parseFilter(spec string) (*filterSpec, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	kind, band, _ := strings.Cut(spec, "=")
+	invalid := fmt.Errorf("Invalid -filter %q, give bandpass=low-high, highpass=low or lowpass=high in Hz", spec)
+	hz := func(s string) (float64, bool) {
+		v, err := strconv.ParseFloat(s, 64)
+		return v, err == nil && v > 0 && !math.IsInf(v, 0)
+	}
+	var f filterSpec
+	var ok bool
+	switch kind {
+	case "bandpass":
+		low, high, _ := strings.Cut(band, "-")
+		var okLow, okHigh bool
+		f.low, okLow = hz(low)
+		f.high, okHigh = hz(high)
+		ok = okLow && okHigh && f.low < f.high
+	case "highpass":
+		f.low, ok = hz(band)
+	case "lowpass":
+		f.high, ok = hz(band)
+	}
+	if !ok {
+		return nil, invalid
+	}
+	return &f, nil
+}
+
+// biquad is a second order section of an IIR filter, in direct form I.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// newNotch returns a notch section at frequency f of rate, by the Audio
+// EQ Cookbook.
+func newNotch(f, rate float64) biquad {
+	w := 2 * math.Pi * f / rate
+	alpha := math.Sin(w) / (2 * NOTCH_Q)
+	a0 := 1 + alpha
+	return biquad{b0: 1 / a0, b1: -2 * math.Cos(w) / a0, b2: 1 / a0, a1: -2 * math.Cos(w) / a0, a2: (1 - alpha) / a0}
+}
+
+// newBiquad returns a low-pass or high-pass section of quality factor q
+// cut off at frequency f of rate, by the Audio EQ Cookbook.
+func newBiquad(highpass bool, f, rate, q float64) biquad {
+	w := 2 * math.Pi * f / rate
+	alpha := math.Sin(w) / (2 * q)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	b := biquad{a1: -2 * cos / a0, a2: (1 - alpha) / a0}
+	if highpass {
+		b.b0 = (1 + cos) / 2 / a0
+		b.b1 = -(1 + cos) / a0
+	} else {
+		b.b0 = (1 - cos) / 2 / a0
+		b.b1 = (1 - cos) / a0
+	}
+	b.b2 = b.b0
+	return b
+}
+
+// reset sets the state of the section to that of a long run of x, so that
+// the filter does not ring at the start of a run of samples, and returns
+// its output then.
+func (b *biquad) reset(x float64) float64 {
+	y := x * (b.b0 + b.b1 + b.b2) / (1 + b.a1 + b.a2)
+	b.x1, b.x2, b.y1, b.y2 = x, x, y, y
+	return y
+}
+
+func (b *biquad) step(x float64) float64 {
+	y := b.b0*x + b.b1*b.x1 + b.b2*b.x2 - b.a1*b.y1 - b.a2*b.y2
+	b.x1, b.x2, b.y1, b.y2 = x, b.x1, y, b.y1
+	return y
+}
+
+// The quality factors of the sections of a fourth order Butterworth
+// filter.
+var butterworthQ = []float64{0.5411961, 1.3065630}
+
+// The quality factor of a second order Butterworth filter.
+var butterworth2Q = []float64{math.Sqrt2 / 2}
+
+// sections returns the sections of the filter at rate: the notch, then
+// fourth order Butterworth high-pass and low-pass filters in turn.
+func (f *filterSpec) sections(rate float64) ([]biquad, error) {
+	if top := max(f.high, f.notch); top >= rate/2 {
+		return nil, fmt.Errorf("Filter of %s Hz needs a sample rate above %s Hz, not %s Hz",
+			strconv.FormatFloat(top, 'f', -1, 64), strconv.FormatFloat(2*top, 'f', -1, 64),
+			strconv.FormatFloat(rate, 'f', -1, 64))
+	}
+	var bs []biquad
+	if f.notch > 0 {
+		bs = append(bs, newNotch(f.notch, rate))
+	}
+	return append(bs, f.butterworth(rate, butterworthQ)...), nil
+}
+
+// butterworth returns the sections of Butterworth high-pass and low-pass
+// filters at rate in turn, of the quality factors of the order.
+func (f *filterSpec) butterworth(rate float64, qs []float64) []biquad {
+	var bs []biquad
+	for _, q := range qs {
+		if f.low > 0 {
+			bs = append(bs, newBiquad(true, f.low, rate, q))
+		}
+		if f.high > 0 {
+			bs = append(bs, newBiquad(false, f.high, rate, q))
+		}
+	}
+	return bs
+}
+
+// FilteredEcg is an ECG row with the filtered value, written by
+// -filter-output column.
+type FilteredEcg struct {
+	Ecg
+	Filtered float64 `csv:"filtered" json:"filtered"`
+}
+
+// filterEncoder filters the ECG samples as they are written, by -filter
+// and -notch. The filter is causal, as it runs over the samples in one
+// pass, so the filtered waveform lags by its group delay. Its state
+// starts again at a gap, a second of ztime without samples.
+//
+// The sample rate is that of the samples passed, if known, or else the
+// number of samples of the first whole second: the first two seconds are
+// held back until it is known, and flush writes them if there are no
+// more.
+type filterEncoder struct {
+	encoder
+	spec     *filterSpec
+	rate     float64
+	column   bool
+	sections []biquad
+	held     [][]Ecg
+	ztime    int64 // of the last sample filtered
+}
+
+func newFilterEncoder(enc encoder, spec *filterSpec, rate float64, output string) *filterEncoder {
+	return &filterEncoder{encoder: enc, spec: spec, rate: rate, column: output == "column"}
+}
+
+func (e *filterEncoder) Header(s *signal, v interface{}) error {
+	if e.column {
+		return e.encoder.Header(s, &[]FilteredEcg{})
+	}
+	return e.encoder.Header(s, v)
+}
+
+func (e *filterEncoder) Encode(s *signal, v interface{}) error {
+	es, ok := v.(*[]Ecg)
+	if !ok {
+		return fmt.Errorf("No filter of rows: %T", v)
+	}
+	if e.sections == nil {
+		e.held = append(e.held, append([]Ecg(nil), *es...))
+		if e.rate == 0 && len(e.held) < 2 {
+			return nil
+		}
+		return e.flush(s)
+	}
+	return e.filter(s, *es)
+}
+
+// flush filters and writes the samples held back.
+func (e *filterEncoder) flush(s *signal) error {
+	if len(e.held) == 0 {
+		return nil
+	}
+	if e.sections == nil {
+		rate := e.rate
+		if rate == 0 {
+			for _, es := range e.held {
+				rate = max(rate, float64(len(es)))
+			}
+		}
+		sections, err := e.spec.sections(rate)
+		if err != nil {
+			return err
+		}
+		e.sections = sections
+	}
+	for _, es := range e.held {
+		if err := e.filter(s, es); err != nil {
+			return err
+		}
+	}
+	e.held = nil
+	return nil
+}
+
+func (e *filterEncoder) filter(s *signal, es []Ecg) error {
+	var rows []FilteredEcg
+	for i := range es {
+		x := es[i].Zvalue
+		if e.ztime == 0 || es[i].Ztime-e.ztime > 1 {
+			v := x
+			for j := range e.sections {
+				v = e.sections[j].reset(v)
+			}
+		}
+		e.ztime = es[i].Ztime
+		y := x
+		for j := range e.sections {
+			y = e.sections[j].step(y)
+		}
+		y = s.roundValue(y)
+		if e.column {
+			rows = append(rows, FilteredEcg{es[i], y})
+			continue
+		}
+		es[i].Zvalue = y
+	}
+	if e.column {
+		return e.encoder.Encode(s, &rows)
+	}
+	return e.encoder.Encode(s, &es)
+}
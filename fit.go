@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/muktihari/fit/decoder"
+	"github.com/muktihari/fit/profile/mesgdef"
+	"github.com/muktihari/fit/profile/typedef"
+)
+
+const (
+	FIT_FILE_EXT           = ".fit"
+	FIT_ORIENTATION_SCALE  = 65535
+	FIT_COMPRESSED_G_SCALE = 1000 // compressed calibrated readings are in mG
+)
+
+// isFIT reports whether path is a Garmin FIT file.
+func isFIT(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), FIT_FILE_EXT)
+}
+
+type fitSample struct {
+	t       time.Time
+	x, y, z float64 // g
+}
+
+// fitSource reads the accelerometer_data messages of a Garmin FIT file.
+// Raw ADC counts are calibrated with the last three_d_sensor_calibration
+// message for the accelerometer, as described in the FIT SDK:
+// ((raw - level_shift) - offset_cal) * factor / divisor, rotated by the
+// orientation matrix. zfok_timestamp is the sample index in the file.
+type fitSource struct {
+	accel []vitalRow
+}
+
+func openFIT(path string) (*fitSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fit, err := decoder.New(f).Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		cal     *mesgdef.ThreeDSensorCalibration
+		samples []fitSample
+	)
+	for i := range fit.Messages {
+		m := &fit.Messages[i]
+		switch m.Num {
+		case typedef.MesgNumThreeDSensorCalibration:
+			if c := mesgdef.NewThreeDSensorCalibration(m); c.SensorType == typedef.SensorTypeAccelerometer {
+				cal = c
+			}
+		case typedef.MesgNumAccelerometerData:
+			ss, err := fitAccel(mesgdef.NewAccelerometerData(m), cal)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, ss...)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].t.Before(samples[j].t) })
+
+	s := &fitSource{}
+	for i, sm := range samples {
+		for _, v := range []float64{sm.x, sm.y, sm.z} {
+			s.accel = append(s.accel, vitalRow{Ztime: sm.t.Unix(), Nanos: int64(sm.t.Nanosecond()), ZFokTimestamp: int64(i), Value: v})
+		}
+	}
+	return s, nil
+}
+
+// fitAccel returns the samples of an accelerometer_data message, using
+// the calibrated readings if the device recorded them.
+func fitAccel(a *mesgdef.AccelerometerData, cal *mesgdef.ThreeDSensorCalibration) ([]fitSample, error) {
+	start := a.Timestamp.Add(time.Duration(a.TimestampMs) * time.Millisecond)
+	n := len(a.SampleTimeOffset)
+	samples := make([]fitSample, 0, n)
+	for i := 0; i < n; i++ {
+		s := fitSample{t: start.Add(time.Duration(a.SampleTimeOffset[i]) * time.Millisecond)}
+		switch {
+		case len(a.CalibratedAccelX) > i && len(a.CalibratedAccelY) > i && len(a.CalibratedAccelZ) > i:
+			s.x, s.y, s.z = fitFloat(a.CalibratedAccelX[i]), fitFloat(a.CalibratedAccelY[i]), fitFloat(a.CalibratedAccelZ[i])
+		case len(a.CompressedCalibratedAccelX) > i && len(a.CompressedCalibratedAccelY) > i && len(a.CompressedCalibratedAccelZ) > i:
+			s.x = float64(a.CompressedCalibratedAccelX[i]) / FIT_COMPRESSED_G_SCALE
+			s.y = float64(a.CompressedCalibratedAccelY[i]) / FIT_COMPRESSED_G_SCALE
+			s.z = float64(a.CompressedCalibratedAccelZ[i]) / FIT_COMPRESSED_G_SCALE
+		case len(a.AccelX) > i && len(a.AccelY) > i && len(a.AccelZ) > i:
+			if cal == nil || cal.CalibrationDivisor == 0 {
+				return nil, fmt.Errorf("Raw accelerometer data without calibration at %s", start)
+			}
+			s.x, s.y, s.z = fitCalibrate(cal, a.AccelX[i], a.AccelY[i], a.AccelZ[i])
+		default:
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// fitFloat widens v to the float64 with the same shortest decimal
+// representation, so that 0.01 is not written as 0.009999999776482582.
+func fitFloat(v float32) float64 {
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(float64(v), 'g', -1, 32), 64)
+	return f
+}
+
+func fitCalibrate(cal *mesgdef.ThreeDSensorCalibration, raw ...uint16) (x, y, z float64) {
+	var v [3]float64
+	for i, r := range raw {
+		v[i] = (float64(r) - float64(cal.LevelShift) - float64(cal.OffsetCal[i])) *
+			float64(cal.CalibrationFactor) / float64(cal.CalibrationDivisor)
+	}
+	m := cal.OrientationMatrix
+	var o [3]float64
+	for i := range o {
+		for j := range v {
+			o[i] += float64(m[i*3+j]) / FIT_ORIENTATION_SCALE * v[j]
+		}
+	}
+	return o[0], o[1], o[2]
+}
+
+func (s *fitSource) query(t int) (rowScanner, error) {
+	if t == ACCEL_TYPE {
+		return &sliceRows{rows: s.accel}, nil
+	}
+	return &sliceRows{}, nil
+}
+
+func (s *fitSource) Close() error {
+	return nil
+}
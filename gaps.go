@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+)
+
+const GAPS_FILE_SUFFIX = ".gaps.csv"
+
+// gap is a run of seconds of ztime without samples of a signal, from
+// start up to end.
+type gap struct {
+	start, end int64
+}
+
+// gapsOf returns the gaps between the seconds with samples of a signal.
+// Sparse signals, sampled every few seconds, have none.
+func gapsOf(s *signal, counts []secondCount) []gap {
+	if s.sparse {
+		return nil
+	}
+	var gaps []gap
+	for i := 1; i < len(counts); i++ {
+		if counts[i].ztime-counts[i-1].ztime > 1 {
+			gaps = append(gaps, gap{counts[i-1].ztime + 1, counts[i].ztime})
+		}
+	}
+	return gaps
+}
+
+// writeGaps writes the gaps of the signals written to <name>.gaps.csv,
+// with the samples missing by the sample rate detected.
+func writeGaps(opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"signal", "start", "end", "start_timestamp", "end_timestamp", "duration_s", "missing_samples"})
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		counts := *opts.Rates[t]
+		rate, ok := detectRate(s, counts)
+		for _, g := range gapsOf(s, counts) {
+			missing := ""
+			if ok {
+				missing = strconv.FormatFloat(math.Round(rate.hz*float64(g.end-g.start)), 'f', -1, 64)
+			}
+			w.Write([]string{
+				s.name,
+				timeLayout.formatTime(time.Unix(g.start, 0)),
+				timeLayout.formatTime(time.Unix(g.end, 0)),
+				strconv.FormatInt(g.start, 10),
+				strconv.FormatInt(g.end, 10),
+				strconv.FormatInt(g.end-g.start, 10),
+				missing,
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+GAPS_FILE_SUFFIX), b.Bytes())
+}
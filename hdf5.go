@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/scigolib/hdf5"
+)
+
+// hdf5Encoder writes an HDF5 file holding a "time" dataset (nanoseconds
+// since the Unix epoch) and one dataset named after the signal: "ecg"
+// with one value per sample, or "accel" with an x, y, z row per sample.
+// The signal dataset carries the sample rate, start time and units as
+// attributes.
+//
+// HDF5 datasets are written in one piece, so the recording is spooled and
+// loaded into memory on Close.
+type hdf5Encoder struct {
+	spool
+	w       io.Writer
+	path    string
+	dataset string
+}
+
+func newHDF5Encoder(w io.Writer, opts *Options) encoder {
+	return &hdf5Encoder{w: w}
+}
+
+func (e *hdf5Encoder) Header(s *signal, v interface{}) error {
+	path, err := outputPath(e.w, "hdf5")
+	if err != nil {
+		return err
+	}
+	e.path, e.dataset = path, s.name
+	return e.open(s)
+}
+
+func (e *hdf5Encoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *hdf5Encoder) Close() error {
+	defer e.release()
+
+	nc := len(e.channels)
+	ts, chs, err := e.load()
+	if err != nil {
+		return err
+	}
+	vs := make([]float64, 0, len(ts)*nc)
+	for j := range ts {
+		for i := range chs {
+			vs = append(vs, chs[i][j])
+		}
+	}
+
+	fw, err := hdf5.CreateForWrite(e.path, hdf5.CreateTruncate)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	if len(ts) == 0 {
+		return nil
+	}
+
+	n := uint64(len(ts))
+	tds, err := fw.CreateDataset("/time", hdf5.Int64, []uint64{n})
+	if err != nil {
+		return err
+	}
+	if err := tds.Write(ts); err != nil {
+		return err
+	}
+	if err := tds.WriteAttribute("units", "ns since 1970-01-01T00:00:00Z"); err != nil {
+		return err
+	}
+
+	dims := []uint64{n}
+	if nc > 1 {
+		dims = append(dims, uint64(nc))
+	}
+	ds, err := fw.CreateDataset("/"+e.dataset, hdf5.Float64, dims)
+	if err != nil {
+		return err
+	}
+	if err := ds.Write(vs); err != nil {
+		return err
+	}
+
+	labels, units := make([]string, nc), make([]string, nc)
+	for i, c := range e.channels {
+		labels[i], units[i] = c.label, c.unit
+	}
+	attrs := []struct {
+		name  string
+		value interface{}
+	}{
+		{"sample_rate", float64(e.sampleRate())},
+		{"start_time", time.Unix(0, ts[0]).UTC().Format(time.RFC3339Nano)},
+		{"units", units},
+		{"labels", labels},
+	}
+	for _, a := range attrs {
+		if err := ds.WriteAttribute(a.name, a.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const HISTOGRAM_FILE_SUFFIX = ".histogram.csv"
+
+// Bins of the intervals between samples of -histogram: HISTOGRAM_BIN
+// wide, and one from HISTOGRAM_MAX_INTERVAL up for the longer.
+const (
+	HISTOGRAM_BIN          = 100 * time.Microsecond
+	HISTOGRAM_MAX_INTERVAL = 2 * time.Second
+)
+
+// intervalEncoder counts the intervals between the times of successive
+// samples passed to its encoder, by bins of HISTOGRAM_BIN.
+type intervalEncoder struct {
+	encoder
+	last time.Time
+	bins map[int64]int
+}
+
+func (e *intervalEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		if e.bins == nil {
+			e.bins = make(map[int64]int)
+		}
+		if !e.last.IsZero() {
+			d := min(detailed.Sub(e.last), HISTOGRAM_MAX_INTERVAL)
+			e.bins[int64(math.Floor(float64(d)/float64(HISTOGRAM_BIN)))]++
+		}
+		e.last = detailed
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// writeHistogram writes the distributions of the samples per second and
+// of the intervals between samples of the signals written to
+// <name>.histogram.csv: a row by count of samples, with the seconds
+// that have it, and a row by bin of intervals in milliseconds, with the
+// intervals in it. Seconds without samples between the first and the
+// last are counted, but for sparse signals.
+func writeHistogram(encs map[int]*intervalEncoder, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"signal", "measure", "from", "to", "count", "share"})
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(round(float64(d)/float64(time.Millisecond)), 'f', -1, 64)
+	}
+	rows := func(name, measure string, bins map[int64]int, from, to func(k int64) string) {
+		total := 0
+		keys := make([]int64, 0, len(bins))
+		for k, n := range bins {
+			keys = append(keys, k)
+			total += n
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		for _, k := range keys {
+			w.Write([]string{
+				name, measure, from(k), to(k),
+				strconv.Itoa(bins[k]),
+				strconv.FormatFloat(round(float64(bins[k])/float64(total)), 'g', -1, 64),
+			})
+		}
+	}
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		counts := make(map[int64]int)
+		cs := *opts.Rates[t]
+		for i, c := range cs {
+			if i > 0 && !s.sparse {
+				counts[0] += int(c.ztime - cs[i-1].ztime - 1)
+			}
+			counts[int64(c.n)]++
+		}
+		if counts[0] == 0 {
+			delete(counts, 0)
+		}
+		count := func(k int64) string { return strconv.FormatInt(k, 10) }
+		rows(s.name, "samples_per_second", counts, count, count)
+
+		e := encs[t]
+		if e == nil {
+			continue
+		}
+		last := int64(HISTOGRAM_MAX_INTERVAL / HISTOGRAM_BIN)
+		rows(s.name, "interval_ms", e.bins, func(k int64) string {
+			return ms(time.Duration(k) * HISTOGRAM_BIN)
+		}, func(k int64) string {
+			if k >= last {
+				return ""
+			}
+			return ms(time.Duration(k+1) * HISTOGRAM_BIN)
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+HISTOGRAM_FILE_SUFFIX), b.Bytes())
+}
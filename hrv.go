@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+)
+
+const HRV_FILE_SUFFIX = ".hrv.csv"
+
+// Bounds of the RR intervals taken as normal to normal (NN), in
+// milliseconds; those outside are ectopic beats or detection errors.
+const (
+	HRV_MIN_INTERVAL = 300
+	HRV_MAX_INTERVAL = 2000
+)
+
+// Frequency bands of the spectrum of the NN intervals in Hz, and the
+// shortest window they are computed over.
+const (
+	HRV_LF_LOW            = 0.04
+	HRV_LF_HIGH           = 0.15
+	HRV_HF_HIGH           = 0.4
+	HRV_MIN_SPECTRUM_SPAN = 2 * time.Minute
+)
+
+// Frequency step of the spectrum in Hz.
+const HRV_FREQUENCY_STEP = 0.001
+
+// hrv holds the heart rate variability of the beats of a window.
+type hrv struct {
+	start, end   time.Time
+	beats, nn    int
+	meanNN, sdnn float64
+	rmssd, pnn50 float64
+	lf, hf       float64 // NaN if the window is too short
+}
+
+// hrvOf returns the heart rate variability of beats, in time order: the
+// mean and standard deviation of the NN intervals, the root mean square
+// of the differences of successive ones and the share of those
+// differing by more than 50 ms, and the power of the low and high
+// frequency bands of their spectrum, a Lomb-Scargle periodogram as the
+// intervals are not evenly spaced.
+func hrvOf(beats []beat, start, end time.Time) hrv {
+	h := hrv{start: start, end: end, beats: len(beats), lf: math.NaN(), hf: math.NaN()}
+	var ts, nns []float64
+	var diffs, over50 int
+	var sumSq float64
+	prev := math.NaN()
+	for _, b := range beats {
+		nn := b.interval
+		if math.IsNaN(nn) || nn < HRV_MIN_INTERVAL || nn > HRV_MAX_INTERVAL {
+			prev = math.NaN()
+			continue
+		}
+		if !math.IsNaN(prev) {
+			d := nn - prev
+			sumSq += d * d
+			diffs++
+			if math.Abs(d) > 50 {
+				over50++
+			}
+		}
+		prev = nn
+		ts = append(ts, b.time.Sub(start).Seconds())
+		nns = append(nns, nn)
+	}
+	h.nn = len(nns)
+	if h.nn == 0 {
+		h.meanNN, h.sdnn, h.rmssd, h.pnn50 = math.NaN(), math.NaN(), math.NaN(), math.NaN()
+		return h
+	}
+	for _, nn := range nns {
+		h.meanNN += nn
+	}
+	h.meanNN /= float64(h.nn)
+	for _, nn := range nns {
+		h.sdnn += (nn - h.meanNN) * (nn - h.meanNN)
+	}
+	h.sdnn = math.Sqrt(h.sdnn / float64(h.nn))
+	h.rmssd, h.pnn50 = math.NaN(), math.NaN()
+	if diffs > 0 {
+		h.rmssd = math.Sqrt(sumSq / float64(diffs))
+		h.pnn50 = 100 * float64(over50) / float64(diffs)
+	}
+
+	span := ts[len(ts)-1] - ts[0]
+	if span < HRV_MIN_SPECTRUM_SPAN.Seconds() {
+		return h
+	}
+	h.lf, h.hf = 0, 0
+	for f := HRV_FREQUENCY_STEP; f < HRV_HF_HIGH; f += HRV_FREQUENCY_STEP {
+		// The periodogram scaled to a density whose integral is the
+		// variance of the intervals.
+		p := lombScargle(ts, nns, h.meanNN, 2*math.Pi*f) * 2 * span / float64(h.nn) * HRV_FREQUENCY_STEP
+		switch {
+		case f >= HRV_LF_LOW && f < HRV_LF_HIGH:
+			h.lf += p
+		case f >= HRV_LF_HIGH:
+			h.hf += p
+		}
+	}
+	return h
+}
+
+// lombScargle returns the power of the periodogram of the values xs at
+// times ts, less their mean, at angular frequency w.
+func lombScargle(ts, xs []float64, mean, w float64) float64 {
+	var s2, c2 float64
+	for _, t := range ts {
+		s2 += math.Sin(2 * w * t)
+		c2 += math.Cos(2 * w * t)
+	}
+	tau := math.Atan2(s2, c2) / (2 * w)
+	var c, s, cc, ss float64
+	for i, t := range ts {
+		cos, sin := math.Cos(w*(t-tau)), math.Sin(w*(t-tau))
+		c += (xs[i] - mean) * cos
+		s += (xs[i] - mean) * sin
+		cc += cos * cos
+		ss += sin * sin
+	}
+	return (c*c/cc + s*s/ss) / 2
+}
+
+// writeHRV writes the heart rate variability of the beats to
+// <name>.hrv.csv, by windows of the given length from the first beat, or
+// over all if 0.
+func writeHRV(beats []beat, window time.Duration, opts *Options) error {
+	var hs []hrv
+	if len(beats) > 0 {
+		start := beats[0].time.Truncate(time.Second)
+		if window == 0 {
+			hs = append(hs, hrvOf(beats, start, beats[len(beats)-1].time))
+		}
+		for i := 0; window > 0 && i < len(beats); {
+			for !beats[i].time.Before(start.Add(window)) {
+				start = start.Add(window)
+			}
+			j := i
+			for j < len(beats) && beats[j].time.Before(start.Add(window)) {
+				j++
+			}
+			hs = append(hs, hrvOf(beats[i:j], start, start.Add(window)))
+			i = j
+		}
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{
+		"start", "end", "start_timestamp", "end_timestamp", "beats", "nn_intervals",
+		"mean_nn_ms", "sdnn_ms", "rmssd_ms", "pnn50", "lf_ms2", "hf_ms2", "lf_hf",
+	})
+	value := func(v float64) string {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(round(v), 'g', -1, 64)
+	}
+	for _, h := range hs {
+		w.Write([]string{
+			timeLayout.formatDetailed(h.start),
+			timeLayout.formatDetailed(h.end),
+			strconv.FormatInt(h.start.Unix(), 10),
+			strconv.FormatInt(h.end.Unix(), 10),
+			strconv.Itoa(h.beats),
+			strconv.Itoa(h.nn),
+			value(h.meanNN),
+			value(h.sdnn),
+			value(h.rmssd),
+			value(h.pnn50),
+			value(h.lf),
+			value(h.hf),
+			value(h.lf / h.hf),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+HRV_FILE_SUFFIX), b.Bytes())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+)
+
+const SAMPLE_INDEX_COLUMN = "sample_index"
+
+// The rows written with -sample-index: those of the signal preceded by
+// the index of the sample in the signal.
+type indexedEcg struct {
+	Index int64 `csv:"sample_index" json:"sample_index"`
+	Ecg
+}
+
+type indexedAccel struct {
+	Index int64 `csv:"sample_index" json:"sample_index"`
+	Accel
+}
+
+type indexedSpo2 struct {
+	Index int64 `csv:"sample_index" json:"sample_index"`
+	Spo2
+}
+
+type indexedFilteredEcg struct {
+	Index int64 `csv:"sample_index" json:"sample_index"`
+	FilteredEcg
+}
+
+type indexedMagnitudeAccel struct {
+	Index int64 `csv:"sample_index" json:"sample_index"`
+	MagnitudeAccel
+}
+
+type indexedSmoothedAccel struct {
+	Index int64 `csv:"sample_index" json:"sample_index"`
+	SmoothedAccel
+}
+
+// indexEncoder numbers the samples of a signal from 0 in the order they
+// are written, across the files of -split-by, and passes them on as rows
+// with a sample_index column.
+type indexEncoder struct {
+	encoder
+	n int64
+}
+
+func (e *indexEncoder) Header(s *signal, v interface{}) error {
+	switch v.(type) {
+	case *[]Ecg:
+		return e.encoder.Header(s, &[]indexedEcg{})
+	case *[]Accel:
+		return e.encoder.Header(s, &[]indexedAccel{})
+	case *[]Spo2:
+		return e.encoder.Header(s, &[]indexedSpo2{})
+	case *[]FilteredEcg:
+		return e.encoder.Header(s, &[]indexedFilteredEcg{})
+	case *[]MagnitudeAccel:
+		return e.encoder.Header(s, &[]indexedMagnitudeAccel{})
+	case *[]SmoothedAccel:
+		return e.encoder.Header(s, &[]indexedSmoothedAccel{})
+	}
+	return fmt.Errorf("No sample index of rows: %T", v)
+}
+
+func (e *indexEncoder) Encode(s *signal, v interface{}) error {
+	switch rs := v.(type) {
+	case *[]Ecg:
+		rows := make([]indexedEcg, len(*rs))
+		for i, r := range *rs {
+			rows[i] = indexedEcg{e.n, r}
+			e.n++
+		}
+		return e.encoder.Encode(s, &rows)
+	case *[]Accel:
+		rows := make([]indexedAccel, len(*rs))
+		for i, r := range *rs {
+			rows[i] = indexedAccel{e.n, r}
+			e.n++
+		}
+		return e.encoder.Encode(s, &rows)
+	case *[]Spo2:
+		rows := make([]indexedSpo2, len(*rs))
+		for i, r := range *rs {
+			rows[i] = indexedSpo2{e.n, r}
+			e.n++
+		}
+		return e.encoder.Encode(s, &rows)
+	case *[]FilteredEcg:
+		rows := make([]indexedFilteredEcg, len(*rs))
+		for i, r := range *rs {
+			rows[i] = indexedFilteredEcg{e.n, r}
+			e.n++
+		}
+		return e.encoder.Encode(s, &rows)
+	case *[]MagnitudeAccel:
+		rows := make([]indexedMagnitudeAccel, len(*rs))
+		for i, r := range *rs {
+			rows[i] = indexedMagnitudeAccel{e.n, r}
+			e.n++
+		}
+		return e.encoder.Encode(s, &rows)
+	case *[]SmoothedAccel:
+		rows := make([]indexedSmoothedAccel, len(*rs))
+		for i, r := range *rs {
+			rows[i] = indexedSmoothedAccel{e.n, r}
+			e.n++
+		}
+		return e.encoder.Encode(s, &rows)
+	}
+	return fmt.Errorf("No sample index of rows: %T", v)
+}
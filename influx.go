@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxEncoder writes InfluxDB line protocol. All signals go to one
+// stream as measurements named after them ("ecg", "accel"), tagged with
+// the recording name and timestamped with the interpolated time in
+// nanoseconds.
+type influxEncoder struct {
+	sync.Mutex
+	w    *bufio.Writer
+	tags string
+}
+
+func newInfluxEncoder(w io.Writer, opts *Options) encoder {
+	return &influxEncoder{w: bufio.NewWriter(w), tags: ",recording=" + influxTagEscaper.Replace(opts.Name)}
+}
+
+func (e *influxEncoder) Header(s *signal, v interface{}) error {
+	return nil
+}
+
+func (e *influxEncoder) Encode(s *signal, v interface{}) error {
+	e.Lock()
+	defer e.Unlock()
+
+	chs := s.channels()
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		e.line(s.name, chs, zfok, detailed.UnixNano(), vs...)
+		return nil
+	})
+}
+
+// line writes a point. Line protocol has no NaN, so NaN fields are left
+// out.
+func (e *influxEncoder) line(measurement string, chs []channel, zfok, ts int64, vs ...float64) {
+	e.w.WriteString(measurement)
+	e.w.WriteString(e.tags)
+	e.w.WriteByte(' ')
+	for i, v := range vs {
+		if math.IsNaN(v) {
+			continue
+		}
+		e.w.WriteString(chs[i].name)
+		e.w.WriteByte('=')
+		e.w.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		e.w.WriteByte(',')
+	}
+	e.w.WriteString("z_fok_timestamp=")
+	e.w.WriteString(strconv.FormatInt(zfok, 10))
+	e.w.WriteString("i ")
+	e.w.WriteString(strconv.FormatInt(ts, 10))
+	e.w.WriteByte('\n')
+}
+
+func (e *influxEncoder) Close() error {
+	return e.w.Flush()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// recordingInfo is the summary of a recording by info, written as a
+// line of JSON with -json.
+type recordingInfo struct {
+	Input    string                              `json:"input"`
+	Schema   string                              `json:"schema,omitempty"`
+	Start    *time.Time                          `json:"start,omitempty"`
+	End      *time.Time                          `json:"end,omitempty"`
+	Duration float64                             `json:"duration_s"`
+	TimeZone string                              `json:"time_zone,omitempty"`
+	Devices  []string                            `json:"devices,omitempty"`
+	Rows     map[int64]int64                     `json:"rows,omitempty"` // by ztype code
+	Signals  []signalInfo                        `json:"signals,omitempty"`
+	Tables   map[string][]map[string]interface{} `json:"tables,omitempty"`
+	Errors   []string                            `json:"errors,omitempty"`
+}
+
+// signalInfo is a signal of a recording with the sample rate detected
+// from its samples.
+type signalInfo struct {
+	Name       string  `json:"name"`
+	Ztype      int     `json:"ztype"`
+	Samples    int64   `json:"samples"`
+	SampleRate float64 `json:"sample_rate,omitempty"` // Hz, 0 if too few samples
+	Drift      float64 `json:"drift_ppm"`
+	Seconds    int64   `json:"seconds"` // over which the rate is detected
+}
+
+// discardEncoder writes nothing, for samples read for what the encoders
+// wrapping it find.
+type discardEncoder struct{}
+
+func (discardEncoder) Header(s *signal, v interface{}) error { return nil }
+func (discardEncoder) Encode(s *signal, v interface{}) error { return nil }
+func (discardEncoder) Close() error                          { return nil }
+
+// infoCommand prints a summary of vital databases without converting
+// them: the span of the recording, the rows of each ztype code, the
+// sample rate of each signal detected from its samples, the devices and
+// time zone recorded and the session tables, such as those of the
+// device. It exits with 1 if an input cannot be read.
+func infoCommand(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+Usage of %s info:
+  %s info [options] vital_data|directory|archive|url|-...
+
+
+`, path.Base(os.Args[0]), os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+	var key, keyFile, mode, schema, signalsFile, ztypes, epoch string
+	var asJSON bool
+	fs.StringVar(&key, "key", "", "Key of SQLCipher encrypted input(passphrase, or x'hex' for a raw key)")
+	fs.StringVar(&keyFile, "key-file", "", "File holding the key of SQLCipher encrypted input")
+	fs.StringVar(&mode, "open-mode", "immutable", "Open mode of input(immutable, ro, rw)")
+	fs.StringVar(&schema, "schema", "", "JSON file naming the tables and columns of a database variant")
+	fs.StringVar(&signalsFile, "signals", "", "YAML or JSON file mapping ztype codes to signals(name, axes, unit, sample_rate)")
+	fs.StringVar(&ztypes, "ztype", "", "More signals of one channel by ztype code, as comma separated code:name pairs(e.g. 12:emg)")
+	fs.StringVar(&epoch, "time-epoch", "auto", "Epoch of the times in the database(coredata, unix, auto)")
+	fs.BoolVar(&asJSON, "json", false, "Write a line of JSON per input")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(ExitCode)
+	}
+	if _, ok := openModes[mode]; !ok {
+		log.Fatalf("Unknown open mode: %s", mode)
+	}
+	if _, ok := timeEpochs[epoch]; !ok && epoch != "auto" {
+		log.Fatalf("Unknown time epoch: %s", epoch)
+	}
+	loadSignalOptions(schema, signalsFile, ztypes)
+	opts := Options{Key: readKey(key, keyFile), OpenMode: mode, TimeEpoch: epoch}
+
+	enc := json.NewEncoder(os.Stdout)
+	eachInput(fs.Args(), func(input, vital string) {
+		o := opts
+		o.Vital = vital
+		in := info(&o)
+		in.Input = input
+		if len(in.Errors) > 0 {
+			ExitCode = 1
+		}
+		if asJSON {
+			if err := enc.Encode(in); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		in.print()
+	})
+}
+
+// info summarizes the recording of opts.Vital.
+func info(opts *Options) recordingInfo {
+	in := recordingInfo{}
+	fail := func(err error) recordingInfo {
+		in.Errors = append(in.Errors, err.Error())
+		return in
+	}
+
+	src, err := openVital(opts)
+	if err != nil {
+		return fail(err)
+	}
+	defer src.Close()
+	schema := src.schemas[0]
+	in.Schema = schema.name
+
+	from, to, ok, err := src.recordingSpan()
+	if err != nil {
+		return fail(err)
+	}
+	if ok {
+		in.Start, in.End = &from, &to
+		in.Duration = to.Sub(from).Seconds()
+	}
+	loc, err := src.timeZone()
+	if err != nil {
+		return fail(err)
+	}
+	if loc != nil {
+		in.TimeZone = loc.String()
+		if in.TimeZone == "" {
+			in.TimeZone = from.In(loc).Format("-07:00")
+		}
+	}
+	if hasDevices() {
+		if in.Devices, err = src.devices(); err != nil {
+			return fail(err)
+		}
+	}
+
+	rows, err := src.dbs[0].Query(schema.counts)
+	if err != nil {
+		return fail(err)
+	}
+	in.Rows = make(map[int64]int64)
+	for rows.Next() {
+		var ztype, n int64
+		if err := rows.Scan(&ztype, &n); err != nil {
+			rows.Close()
+			return fail(err)
+		}
+		in.Rows[ztype] = n
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	// The samples are read as by a conversion, whose errors end the
+	// goroutine reading them.
+	runError.Lock()
+	runError.err = nil
+	runError.Unlock()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, t := range signalOrder {
+			code, ok := schema.ztypes[t]
+			if !ok || in.Rows[int64(code)] == 0 {
+				continue
+			}
+			s := signalTypes[t]
+			counts := new([]secondCount)
+			query(src, t, &rateEncoder{discardEncoder{}, counts})
+			si := signalInfo{Name: s.name, Ztype: code}
+			for _, c := range *counts {
+				si.Samples += int64(c.n)
+			}
+			if r, ok := detectRate(s, *counts); ok {
+				si.SampleRate, si.Drift, si.Seconds = r.hz, r.drift, r.seconds
+			}
+			in.Signals = append(in.Signals, si)
+		}
+	}()
+	wg.Wait()
+	runError.Lock()
+	err = runError.err
+	runError.Unlock()
+	if err != nil {
+		return fail(err)
+	}
+
+	tables, err := readSessionTables(src.dbs[0], schema)
+	if err != nil {
+		return fail(err)
+	}
+	in.Tables = sessionObjects(tables)
+	return in
+}
+
+// print writes the summary as text.
+func (in recordingInfo) print() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", in.Input)
+	if in.Schema != "" {
+		fmt.Fprintf(w, "  schema:\t%s\n", in.Schema)
+	}
+	if in.Start != nil {
+		fmt.Fprintf(w, "  start:\t%s\n", in.Start.In(outputZone).Format(time.RFC3339))
+		fmt.Fprintf(w, "  end:\t%s\n", in.End.In(outputZone).Format(time.RFC3339))
+		fmt.Fprintf(w, "  duration:\t%v\n", in.End.Sub(*in.Start).Round(time.Second))
+	}
+	if in.TimeZone != "" {
+		fmt.Fprintf(w, "  time zone:\t%s\n", in.TimeZone)
+	}
+	if len(in.Devices) > 0 {
+		fmt.Fprintf(w, "  devices:\t%s\n", strings.Join(in.Devices, ", "))
+	}
+	codes := make([]int64, 0, len(in.Rows))
+	for code := range in.Rows {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		fmt.Fprintf(w, "  ztype %d:\t%d rows", code, in.Rows[code])
+		for _, si := range in.Signals {
+			if int64(si.Ztype) != code {
+				continue
+			}
+			fmt.Fprintf(w, ", %s, %d samples", si.Name, si.Samples)
+			if si.SampleRate > 0 {
+				r := sampleRate{hz: si.SampleRate, drift: si.Drift, seconds: si.Seconds}
+				fmt.Fprintf(w, ", %v", r)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	names := make([]string, 0, len(in.Tables))
+	for name := range in.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  table %s:\t%d rows\n", name, len(in.Tables[name]))
+		for _, row := range in.Tables[name] {
+			columns := make([]string, 0, len(row))
+			for c := range row {
+				columns = append(columns, c)
+			}
+			sort.Strings(columns)
+			for i, c := range columns {
+				v := sessionValue(row[c])
+				if b, ok := row[c].([]byte); ok {
+					v = "<" + strconv.Itoa(len(b)) + " bytes>"
+				}
+				columns[i] = c + "=" + v
+			}
+			fmt.Fprintf(w, "    \t%s\n", strings.Join(columns, " "))
+		}
+	}
+	for _, e := range in.Errors {
+		fmt.Fprintf(w, "  error:\t%s\n", e)
+	}
+	w.Flush()
+}
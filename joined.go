@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Outputs of -merge are named <name>.merged.csv.
+	MERGED_FILE_SUFFIX = ".merged"
+	// Sample rate of the grid of -merge, that of the ECG of the device,
+	// if neither -resample nor -signals gives one.
+	MERGE_RATE = 250
+)
+
+// joinedRow is a point of the grid of -merge, with the values of the
+// channels of all signals.
+type joinedRow struct {
+	ztime int64
+	t     time.Time
+	vs    []float64
+}
+
+// joinedEncoder writes the ECG and the accel, resampled onto one grid, to
+// one wide csv file: a row per point of the grid with the ECG and the
+// x, y and z of the accel in columns, left empty if a signal has no
+// sample there. The signals are written from concurrent goroutines, so
+// the points are held until every signal has passed them or finished,
+// or until closed. A signal without samples finishes as soon as it is
+// read, so that it holds back none.
+type joinedEncoder struct {
+	mu      sync.Mutex
+	w       *csv.Writer
+	signals []int
+	offsets map[*signal]int // column of the first channel of each signal
+	width   int
+	header  bool
+	rows    map[int64]*joinedRow // by time in nanoseconds
+	passed  map[*signal]int64    // time of the last point of each signal
+	done    map[*signal]bool     // signals finished
+}
+
+func newJoinedEncoder(w io.Writer, opts *Options) encoder {
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.Delimiter
+	e := &joinedEncoder{
+		w: cw, signals: opts.Signals, offsets: make(map[*signal]int),
+		rows: make(map[int64]*joinedRow), passed: make(map[*signal]int64),
+		done: make(map[*signal]bool),
+	}
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		e.offsets[s] = e.width
+		e.width += len(s.channels())
+	}
+	return e
+}
+
+func (e *joinedEncoder) Header(s *signal, v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.header {
+		return nil
+	}
+	e.header = true
+	header := []string{"time", "timestamp", "detailed_timestamp"}
+	for _, t := range e.signals {
+		s := signalTypes[t]
+		for _, c := range s.channels() {
+			name := c.name
+			if s.axes == 1 {
+				name = s.name
+			}
+			header = append(header, name)
+		}
+	}
+	return e.w.Write(header)
+}
+
+func (e *joinedEncoder) Encode(s *signal, v interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	offset := e.offsets[s]
+	eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		key := detailed.UnixNano()
+		r, ok := e.rows[key]
+		if !ok {
+			r = &joinedRow{ztime: ztime, t: detailed, vs: make([]float64, e.width)}
+			for i := range r.vs {
+				r.vs[i] = math.NaN()
+			}
+			e.rows[key] = r
+		}
+		copy(r.vs[offset:], vs)
+		e.passed[s] = key
+		return nil
+	})
+	return e.writePassed()
+}
+
+// finish ends signal s, whose points no longer hold back those of the
+// others.
+func (e *joinedEncoder) finish(s *signal) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.done[s] = true
+	return e.writePassed()
+}
+
+// writePassed writes the rows of the points passed by every signal not
+// finished.
+func (e *joinedEncoder) writePassed() error {
+	until := int64(math.MaxInt64)
+	for s := range e.offsets {
+		if e.done[s] {
+			continue
+		}
+		t, ok := e.passed[s]
+		if !ok {
+			return nil
+		}
+		until = min(until, t)
+	}
+	return e.write(until)
+}
+
+// write writes the rows of the points up to until, in nanoseconds, in
+// the order of their time.
+func (e *joinedEncoder) write(until int64) error {
+	var keys []int64
+	for k := range e.rows {
+		if k <= until {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	for _, k := range keys {
+		r := e.rows[k]
+		delete(e.rows, k)
+		row := []string{timeLayout.formatTime(time.Unix(r.ztime, 0)), strconv.FormatInt(r.ztime, 10), timeLayout.formatDetailed(r.t)}
+		for _, v := range r.vs {
+			field := ""
+			if !math.IsNaN(v) {
+				field = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+			row = append(row, field)
+		}
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *joinedEncoder) Close() error {
+	if err := e.write(math.MaxInt64); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
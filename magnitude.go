@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	MAGNITUDE_COLUMN = "magnitude"
+	ENMO_COLUMN      = "enmo"
+)
+
+// MagnitudeAccel is an accel row with the vector magnitude of the axes
+// and the ENMO, the Euclidean norm minus one g with negative values set
+// to 0, written by -accel-magnitude. Both are empty if an axis is.
+type MagnitudeAccel struct {
+	Accel
+	Magnitude axisValue `csv:"magnitude" json:"magnitude"`
+	Enmo      axisValue `csv:"enmo" json:"enmo"`
+}
+
+// magnitudeEncoder passes the accel rows on with their vector magnitude
+// and ENMO.
+type magnitudeEncoder struct {
+	encoder
+}
+
+func (e *magnitudeEncoder) Header(s *signal, v interface{}) error {
+	return e.encoder.Header(s, &[]MagnitudeAccel{})
+}
+
+func (e *magnitudeEncoder) Encode(s *signal, v interface{}) error {
+	as, ok := v.(*[]Accel)
+	if !ok {
+		return fmt.Errorf("No magnitude of rows: %T", v)
+	}
+	rows := make([]MagnitudeAccel, len(*as))
+	for i, a := range *as {
+		m := math.Sqrt(float64(a.X*a.X + a.Y*a.Y + a.Z*a.Z))
+		rows[i] = MagnitudeAccel{a, axisValue(round(m)), axisValue(round(math.Max(m-1, 0)))}
+	}
+	return e.encoder.Encode(s, &rows)
+}
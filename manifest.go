@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const MANIFEST_RESULTS_SUFFIX = ".results.csv"
+
+// manifestEntry is a row of the -manifest csv: the input, the subject
+// recorded, and the name of the outputs (by default the input's), which
+// may contain directories below the output directory.
+type manifestEntry struct {
+	input, subject, prefix string
+}
+
+func readManifest(path string) ([]manifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	idx := map[string]int{"input": -1, "subject": -1, "prefix": -1}
+	for i, h := range header {
+		if _, ok := idx[strings.TrimSpace(h)]; ok {
+			idx[strings.TrimSpace(h)] = i
+		}
+	}
+	if idx["input"] < 0 {
+		return nil, fmt.Errorf("%s: no input column", path)
+	}
+	field := func(rec []string, name string) string {
+		if i := idx[name]; i >= 0 && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+
+	var entries []manifestEntry
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := manifestEntry{field(rec, "input"), field(rec, "subject"), field(rec, "prefix")}
+		if e.input == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+}
+
+// runManifest converts the entries of the -manifest and writes their
+// results, with the samples written and the sample rate detected per
+// signal, to the output directory.
+func runManifest(opts Options) error {
+	entries, err := readManifest(opts.Manifest)
+	if err != nil {
+		return err
+	}
+
+	// The signals are listed once converted, as -all adds those found.
+	results := make([]result, len(entries))
+	for i, e := range entries {
+		results[i] = convertEntry(e, opts)
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	header := []string{"input", "subject", "prefix", "status"}
+	for _, t := range signalOrder {
+		header = append(header, signalTypes[t].name+"_rows")
+	}
+	for _, t := range signalOrder {
+		header = append(header, signalTypes[t].name+"_rate_hz")
+	}
+	w.Write(append(header, "error"))
+	for i, e := range entries {
+		r := results[i]
+		status := "ok"
+		switch {
+		case r.skipped:
+			status = "skipped"
+		case r.err != nil:
+			status = "failed"
+		}
+		rows := func(t int) string {
+			n, ok := r.rows[t]
+			if !ok {
+				return ""
+			}
+			return strconv.FormatInt(n, 10)
+		}
+		msg := ""
+		if r.err != nil {
+			msg = r.err.Error()
+		}
+		rec := []string{e.input, e.subject, e.prefix, status}
+		for _, t := range signalOrder {
+			rec = append(rec, rows(t))
+		}
+		for _, t := range signalOrder {
+			rate := ""
+			if r, ok := r.rates[t]; ok {
+				rate = r.format()
+			}
+			rec = append(rec, rate)
+		}
+		w.Write(append(rec, msg))
+	}
+	w.Flush()
+
+	base := filepath.Base(opts.Manifest)
+	name := strings.TrimSuffix(base, filepath.Ext(base)) + MANIFEST_RESULTS_SUFFIX
+	return writeOutput(joinOutput(opts.OutDir, name), b.Bytes())
+}
+
+func convertEntry(e manifestEntry, opts Options) result {
+	fail := func(err error) result {
+		ExitCode = 1
+		return result{err: err}
+	}
+	if e.input == STDIN_INPUT || isArchive(e.input) {
+		return fail(fmt.Errorf("Input not supported in a manifest: %s", e.input))
+	}
+	named := func(vital, name string) Options {
+		if e.prefix == "" {
+			return opts.forInput(name)
+		}
+		return opts.named(vital, e.prefix)
+	}
+	if e.prefix != "" && !isObject(opts.OutDir) {
+		dir := filepath.Dir(joinOutput(opts.OutDir, e.prefix))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fail(err)
+		}
+	}
+
+	fetch := fetcherOf(e.input)
+	if fetch == nil {
+		return run(named(e.input, e.input), e.input)
+	}
+	var r result
+	err := fetch(e.input, func(name, vital string) {
+		o := named(vital, name)
+		o.Vital = vital
+		r = run(o, e.input)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	return r
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scigolib/hdf5"
+)
+
+const (
+	MAT_USERBLOCK_SIZE = 512
+	MAT_HEADER_TEXT    = 116
+	MAT_VERSION        = "\x00\x02"
+	MAT_ENDIAN         = "IM"
+)
+
+// matEncoder writes MATLAB v7.3 MAT-files, which are HDF5 files behind a
+// 512 byte MATLAB header. The signal is stored as a struct variable
+// named after it ("ecg", "accel") with a "time" field (POSIX seconds, for
+// datetime(t, 'ConvertFrom', 'posixtime')) and a column vector field per
+// channel ("value" or "x", "y", "z").
+//
+// The HDF5 file is built in a temporary file and copied behind the
+// header on Close; HDF5 readers relocate the base address to the
+// superblock found at offset 512.
+type matEncoder struct {
+	spool
+	w        io.Writer
+	variable string
+}
+
+func newMATEncoder(w io.Writer, opts *Options) encoder {
+	return &matEncoder{w: w}
+}
+
+func (e *matEncoder) Header(s *signal, v interface{}) error {
+	e.variable = s.name
+	return e.open(s)
+}
+
+func (e *matEncoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *matEncoder) Close() error {
+	defer e.release()
+
+	ts, chs, err := e.load()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "vital2csv-mat-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := e.writeHDF5(tmp.Name(), ts, chs); err != nil {
+		return err
+	}
+
+	h5, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer h5.Close()
+
+	if _, err := io.WriteString(e.w, matHeader()); err != nil {
+		return err
+	}
+	_, err = io.Copy(e.w, h5)
+	return err
+}
+
+func (e *matEncoder) writeHDF5(path string, ts []int64, chs [][]float64) error {
+	fw, err := hdf5.CreateForWrite(path, hdf5.CreateTruncate)
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	if len(ts) == 0 {
+		return nil
+	}
+
+	g, err := fw.CreateGroup("/" + e.variable)
+	if err != nil {
+		return err
+	}
+	if err := g.WriteAttribute("MATLAB_class", "struct"); err != nil {
+		return err
+	}
+
+	secs := make([]float64, len(ts))
+	for i, t := range ts {
+		secs[i] = float64(t) / float64(time.Second)
+	}
+	if err := e.writeVector(fw, "time", secs); err != nil {
+		return err
+	}
+	for i, c := range e.channels {
+		if err := e.writeVector(fw, c.name, chs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeVector writes vs as a column vector field. MATLAB stores arrays in
+// column-major order, so an n-by-1 vector has the HDF5 dimensions [1, n].
+func (e *matEncoder) writeVector(fw *hdf5.FileWriter, field string, vs []float64) error {
+	ds, err := fw.CreateDataset("/"+e.variable+"/"+field, hdf5.Float64, []uint64{1, uint64(len(vs))})
+	if err != nil {
+		return err
+	}
+	if err := ds.Write(vs); err != nil {
+		return err
+	}
+	return ds.WriteAttribute("MATLAB_class", "double")
+}
+
+// matHeader returns the MATLAB header occupying the HDF5 user block.
+func matHeader() string {
+	text := fmt.Sprintf("MATLAB 7.3 MAT-file, Platform: GLNXA64, Created on: %s HDF5 schema 1.00 .",
+		time.Now().Format("Mon Jan _2 15:04:05 2006"))
+	if len(text) < MAT_HEADER_TEXT {
+		text += strings.Repeat(" ", MAT_HEADER_TEXT-len(text))
+	}
+	h := text[:MAT_HEADER_TEXT] + strings.Repeat("\x00", 8) + MAT_VERSION + MAT_ENDIAN
+	return h + strings.Repeat("\x00", MAT_USERBLOCK_SIZE-len(h))
+}
@@ -0,0 +1,95 @@
+package main
+
+// mergedRows merges the rows of several databases of one recording
+// (-concat) in (timestamp, zfok_timestamp) order. The rows of each are
+// ordered so already. Rows whose time, to the nanosecond, and
+// zfok_timestamp were already read from another database are dropped,
+// keeping those of the earlier database.
+type mergedRows struct {
+	rows  []rowScanner
+	heads []*vitalRow // next row of each database, nil when exhausted
+	cur   vitalRow
+	read  bool
+	err   error
+}
+
+func newMergedRows(rows []rowScanner) *mergedRows {
+	m := &mergedRows{rows: rows, heads: make([]*vitalRow, len(rows))}
+	for i := range rows {
+		m.advance(i)
+	}
+	return m
+}
+
+func (m *mergedRows) advance(i int) {
+	m.heads[i] = nil
+	if !m.rows[i].Next() {
+		if err := m.rows[i].Err(); err != nil && m.err == nil {
+			m.err = err
+		}
+		return
+	}
+	var r vitalRow
+	if err := m.rows[i].StructScan(&r); err != nil {
+		if m.err == nil {
+			m.err = err
+		}
+		return
+	}
+	m.heads[i] = &r
+}
+
+// before reports whether r is read before o.
+func (r *vitalRow) before(o *vitalRow) bool {
+	if r.Ztime != o.Ztime {
+		return r.Ztime < o.Ztime
+	}
+	if r.Nanos != o.Nanos {
+		return r.Nanos < o.Nanos
+	}
+	return r.ZFokTimestamp < o.ZFokTimestamp
+}
+
+func (m *mergedRows) Next() bool {
+	for m.err == nil {
+		min := -1
+		for i, h := range m.heads {
+			if h != nil && (min < 0 || h.before(m.heads[min])) {
+				min = i
+			}
+		}
+		if min < 0 {
+			return false
+		}
+		r := *m.heads[min]
+		m.advance(min)
+		if m.read && r.Ztime == m.cur.Ztime && r.Nanos == m.cur.Nanos && r.ZFokTimestamp == m.cur.ZFokTimestamp {
+			continue
+		}
+		m.cur, m.read = r, true
+		return true
+	}
+	return false
+}
+
+func (m *mergedRows) StructScan(dest interface{}) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.cur.scan(dest)
+	return nil
+}
+
+func (m *mergedRows) Err() error {
+	return m.err
+}
+
+func (m *mergedRows) Close() error {
+	var err error
+	for _, r := range m.rows {
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// column describes a csv column for the metadata files written next to
+// the csv output (-datapackage, -csvw).
+type column struct {
+	name        string
+	kind        string // integer, number, string or datetime
+	unit        string
+	description string
+}
+
+// output is a table written by the export: the files of one signal (or of
+// all in single file formats) and the row type of their columns. signal
+// is nil for the samples of single file formats.
+type output struct {
+	name   string
+	paths  []string
+	row    interface{}
+	signal *signal
+}
+
+// outputsOf returns the tables written by the export, given the files
+// written for every signal.
+func outputsOf(opts *Options, paths map[int][]string) []output {
+	if opts.Format.single {
+		t := opts.Signals[0]
+		return []output{{name: "samples", paths: paths[t], row: Sample{}}}
+	}
+	var outs []output
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		outs = append(outs, output{name: s.name, paths: paths[t], row: s.row(), signal: s})
+	}
+	return outs
+}
+
+// columnsOf returns the csv columns of the output, in the order they are
+// written.
+func columnsOf(out output, opts *Options) []column {
+	row := out.row
+	units := make(map[string]string)
+	var signals, values []string
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		for _, c := range s.channels() {
+			signals = append(signals, s.column(c))
+		}
+		unit := s.outputUnit()
+		if unit == "" {
+			values = append(values, "for "+s.name)
+			continue
+		}
+		values = append(values, "in "+unit+" for "+s.name)
+	}
+	if out.signal != nil {
+		for _, c := range out.signal.channels() {
+			units[c.name] = c.unit
+		}
+	}
+	tc := column{kind: "datetime", description: "Local time"}
+	switch timeLayout.unit {
+	case time.Millisecond:
+		tc = column{kind: "integer", description: "Milliseconds since the Unix epoch"}
+	case time.Nanosecond:
+		tc = column{kind: "integer", description: "Nanoseconds since the Unix epoch"}
+	}
+	if timeLayout.layout == time.RFC3339 || timeLayout == timeFormats["local-offset"] {
+		tc.description = "Local time with UTC offset"
+	}
+
+	var all []column
+	if opts.SampleIndex && out.signal != nil {
+		all = append(all, column{name: SAMPLE_INDEX_COLUMN, kind: "integer", description: "Index of the sample in the signal, from 0"})
+	}
+	t := reflect.TypeOf(row)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("csv")
+		c := column{name: name}
+		switch name {
+		case "", "-":
+			continue
+		case "time":
+			c.kind, c.description = tc.kind, tc.description+", truncated to the second"
+		case "detailed_timestamp":
+			c.kind, c.description = tc.kind, tc.description+", interpolated within the second"
+		case "timestamp":
+			c.kind, c.description = "integer", "Unix time in seconds"
+		case "z_fok_timestamp":
+			c.kind, c.description = "integer", "Sequence number of the sample in the recording"
+		case "quality":
+			c.kind, c.description = "number", "Signal quality recorded for the sample, empty if none"
+		case "signal":
+			c.kind, c.description = "string", "Signal of the value: "+joinList(signals)
+		default:
+			c.kind, c.unit = "number", units[name]
+			if _, ok := row.(Sample); ok {
+				c.unit, c.description = "", "Value "+strings.Join(values, ", ")
+			}
+		}
+
+		all = append(all, c)
+	}
+	if opts.Filter != nil && opts.FilterOutput == "column" && out.signal == signalTypes[ECG_TYPE] {
+		all = append(all, column{name: FILTERED_COLUMN, kind: "number", unit: units["value"], description: "Value filtered by -filter and -notch"})
+	}
+	if opts.AccelMagnitude && out.signal == signalTypes[ACCEL_TYPE] {
+		all = append(all,
+			column{name: MAGNITUDE_COLUMN, kind: "number", unit: "g", description: "Vector magnitude of the axes"},
+			column{name: ENMO_COLUMN, kind: "number", unit: "g", description: "Euclidean norm minus one g, negative values set to 0"})
+	}
+	if opts.Smooth > 0 && out.signal == signalTypes[ACCEL_TYPE] {
+		for _, c := range []string{X_SMOOTH_COLUMN, Y_SMOOTH_COLUMN, Z_SMOOTH_COLUMN} {
+			all = append(all, column{name: c, kind: "number", unit: units[c[:1]], description: "Moving average of the axis over the " + opts.Smooth.String() + " up to the sample"})
+		}
+	}
+	if len(opts.Columns) == 0 {
+		return all
+	}
+	var cs []column
+	for _, name := range opts.Columns {
+		for _, c := range all {
+			if c.name == name {
+				cs = append(cs, c)
+			}
+		}
+	}
+	return cs
+}
+
+// joinList joins the words of a list in prose: "a, b or c".
+func joinList(words []string) string {
+	if len(words) < 2 {
+		return strings.Join(words, "")
+	}
+	return strings.Join(words[:len(words)-1], ", ") + " or " + words[len(words)-1]
+}
+
+// relPaths returns paths relative to dir, with forward slashes.
+func relPaths(dir string, paths []string) []string {
+	rs := make([]string, len(paths))
+	dir, _ = splitQuery(dir)
+	for i, p := range paths {
+		p, _ = splitQuery(p)
+		r, err := filepath.Rel(dir, p)
+		if err != nil {
+			r = p
+		}
+		rs[i] = filepath.ToSlash(r)
+	}
+	return rs
+}
+
+// identifier returns s in lower case with characters other than
+// [a-z0-9._-] replaced, as names in the metadata require.
+func identifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, s)
+}
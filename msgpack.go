@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// msgpackEncoder writes a stream of MessagePack arrays: the first holds
+// the column names, and each following one the values of a sample in the
+// same order. To keep the stream compact the formatted time columns are
+// left out and detailed_timestamp is an integer in Unix nanoseconds.
+type msgpackEncoder struct {
+	w   *bufio.Writer
+	enc *msgpack.Encoder
+}
+
+func newMsgpackEncoder(w io.Writer, opts *Options) encoder {
+	bw := bufio.NewWriter(w)
+	return &msgpackEncoder{w: bw, enc: msgpack.NewEncoder(bw)}
+}
+
+func (e *msgpackEncoder) Header(s *signal, v interface{}) error {
+	names := []string{"timestamp", "z_fok_timestamp"}
+	for _, c := range s.channels() {
+		names = append(names, c.name)
+	}
+	return e.enc.Encode(append(names, "detailed_timestamp"))
+}
+
+func (e *msgpackEncoder) Encode(s *signal, v interface{}) error {
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		return e.row(ztime, zfok, detailed.UnixNano(), vs...)
+	})
+}
+
+func (e *msgpackEncoder) row(ztime, zfok, detailed int64, vs ...float64) error {
+	if err := e.enc.EncodeArrayLen(3 + len(vs)); err != nil {
+		return err
+	}
+	if err := e.enc.EncodeInt(ztime); err != nil {
+		return err
+	}
+	if err := e.enc.EncodeInt(zfok); err != nil {
+		return err
+	}
+	for _, v := range vs {
+		if err := e.enc.EncodeFloat64(v); err != nil {
+			return err
+		}
+	}
+	return e.enc.EncodeInt(detailed)
+}
+
+func (e *msgpackEncoder) Close() error {
+	return e.w.Flush()
+}
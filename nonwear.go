@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const NONWEAR_FILE_SUFFIX = ".nonwear.csv"
+
+// Heuristics of -non-wear. A second of the accel is still if the
+// standard deviation of each axis is below NONWEAR_ACCEL_STD g, and a
+// second of the ECG is flat if its range is below NONWEAR_ECG_RANGE mV.
+// The device is likely not worn in runs of seconds, of -non-wear-min or
+// longer, in which the signals with samples are all still or flat.
+const (
+	NONWEAR_ACCEL_STD = 0.013
+	NONWEAR_ECG_RANGE = 0.05
+	NONWEAR_MIN       = 30 * time.Minute
+)
+
+// stillSecond is a second of ztime of a signal, still if it has no
+// motion or no ECG.
+type stillSecond struct {
+	ztime int64
+	still bool
+}
+
+// stillnessEncoder finds the seconds of the accel or the ECG passed to
+// its encoder that are still. flush ends the last second.
+type stillnessEncoder struct {
+	encoder
+	seconds []stillSecond
+	ztime   int64
+	stats   []epochSummary // of the current second, by channel
+}
+
+func (e *stillnessEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		vs = vs[:s.axes]
+		if e.stats != nil && ztime != e.ztime {
+			e.flush(s)
+		}
+		if e.stats == nil {
+			e.ztime, e.stats = ztime, make([]epochSummary, len(vs))
+		}
+		for i, x := range vs {
+			e.stats[i].add(x)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// flush ends the current second.
+func (e *stillnessEncoder) flush(s *signal) {
+	if e.stats == nil {
+		return
+	}
+	still := true
+	for _, st := range e.stats {
+		if st.n == 0 {
+			continue
+		}
+		if s.axes == 1 {
+			still = still && st.max-st.min < NONWEAR_ECG_RANGE
+		} else {
+			still = still && st.stat("std") < NONWEAR_ACCEL_STD
+		}
+	}
+	e.seconds = append(e.seconds, stillSecond{e.ztime, still})
+	e.stats = nil
+}
+
+// nonWearOf returns the runs of seconds, of shortest or longer, in which
+// the signals with samples are all still.
+func nonWearOf(encs map[int]*stillnessEncoder, shortest time.Duration) []gap {
+	still := make(map[int64]bool)
+	for _, e := range encs {
+		for _, sec := range e.seconds {
+			s, seen := still[sec.ztime]
+			still[sec.ztime] = sec.still && (s || !seen)
+		}
+	}
+	seconds := make([]int64, 0, len(still))
+	for t := range still {
+		seconds = append(seconds, t)
+	}
+	sort.Slice(seconds, func(i, j int) bool { return seconds[i] < seconds[j] })
+
+	var runs []gap
+	var run *gap
+	for _, t := range seconds {
+		if run != nil && (!still[t] || t != run.end) {
+			if time.Duration(run.end-run.start)*time.Second >= shortest {
+				runs = append(runs, *run)
+			}
+			run = nil
+		}
+		if !still[t] {
+			continue
+		}
+		if run == nil {
+			run = &gap{t, t}
+		}
+		run.end = t + 1
+	}
+	if run != nil && time.Duration(run.end-run.start)*time.Second >= shortest {
+		runs = append(runs, *run)
+	}
+	return runs
+}
+
+// writeNonWear writes the runs of seconds in which the device is likely
+// not worn to <name>.nonwear.csv.
+func writeNonWear(encs map[int]*stillnessEncoder, shortest time.Duration, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"start", "end", "start_timestamp", "end_timestamp", "duration_s"})
+	for _, r := range nonWearOf(encs, shortest) {
+		w.Write([]string{
+			timeLayout.formatTime(time.Unix(r.start, 0)),
+			timeLayout.formatTime(time.Unix(r.end, 0)),
+			strconv.FormatInt(r.start, 10),
+			strconv.FormatInt(r.end, 10),
+			strconv.FormatInt(r.end-r.start, 10),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+NONWEAR_FILE_SUFFIX), b.Bytes())
+}
@@ -0,0 +1,89 @@
+package main
+
+import "time"
+
+// Modes of -normalize: the values of each channel are standardized to a
+// mean of 0 and a standard deviation of 1, or scaled to 0 to 1 from the
+// lowest to the highest value of the recording.
+const (
+	NORMALIZE_ZSCORE = "zscore"
+	NORMALIZE_MINMAX = "minmax"
+)
+
+var normalizeModes = []string{NORMALIZE_ZSCORE, NORMALIZE_MINMAX}
+
+// normalization normalizes the values of a channel written: value = (v -
+// shift) / scale.
+type normalization struct {
+	shift, scale float64
+}
+
+// normalizedValue returns value v of channel i as written normalized by
+// -normalize, rounded to -precision; v if not normalized.
+func (s *signal) normalizedValue(i int, v float64) float64 {
+	if i >= len(s.normalization) {
+		return v
+	}
+	n := s.normalization[i]
+	return round((v - n.shift) / n.scale)
+}
+
+// summaryEncoder summarizes the values of each channel of the samples
+// passed to it, which it does not write.
+type summaryEncoder struct {
+	channels []epochSummary
+}
+
+func (e *summaryEncoder) Header(s *signal, v interface{}) error {
+	return nil
+}
+
+func (e *summaryEncoder) Encode(s *signal, v interface{}) error {
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		vs = vs[:s.axes]
+		if e.channels == nil {
+			e.channels = make([]epochSummary, len(vs))
+		}
+		for i, x := range vs {
+			e.channels[i].add(x)
+		}
+		return nil
+	})
+}
+
+func (e *summaryEncoder) Close() error {
+	return nil
+}
+
+// setNormalization reads the signals of opts a first time, to find the
+// normalization of their channels by -normalize over the recording. A
+// channel of constant values is only shifted.
+func setNormalization(src source, opts *Options) {
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		s.normalization = nil
+		e := &summaryEncoder{}
+		query(src, t, e)
+		ns := make([]normalization, s.axes)
+		for i := range ns {
+			ns[i] = normalization{0, 1}
+			if i >= len(e.channels) || e.channels[i].n == 0 {
+				continue
+			}
+			c := e.channels[i]
+			switch s.normalize {
+			case NORMALIZE_ZSCORE:
+				ns[i].shift = c.mean
+				if std := c.stat("std"); std > 0 {
+					ns[i].scale = std
+				}
+			case NORMALIZE_MINMAX:
+				ns[i].shift = c.min
+				if c.max > c.min {
+					ns[i].scale = c.max - c.min
+				}
+			}
+		}
+		s.normalization = ns
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NPY_MAGIC     = "\x93NUMPY\x01\x00"
+	NPY_ALIGNMENT = 64
+)
+
+// npzEncoder writes compressed NumPy archives, as np.savez_compressed
+// does: "time" holds the interpolated time of each sample in Unix
+// nanoseconds (int64) and each channel is a float64 array named after
+// its column ("value", or "x", "y", "z").
+//
+// The array shapes go into the .npy headers, so the recording is spooled
+// and each array is streamed from the spool on Close.
+type npzEncoder struct {
+	spool
+	w io.Writer
+}
+
+func newNPZEncoder(w io.Writer, opts *Options) encoder {
+	return &npzEncoder{w: w}
+}
+
+func (e *npzEncoder) Header(s *signal, v interface{}) error {
+	return e.open(s)
+}
+
+func (e *npzEncoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *npzEncoder) Close() error {
+	defer e.release()
+
+	var n int
+	for _, sec := range e.seconds {
+		n += sec.n
+	}
+
+	zw := zip.NewWriter(e.w)
+	err := e.array(zw, "time", "<i8", n, func(sec spoolSecond, chs [][]float64) interface{} {
+		ts := make([]int64, sec.n)
+		for j := range ts {
+			ts[j] = sec.ztime*int64(time.Second) + int64(j)*int64(time.Second)/int64(sec.n)
+		}
+		return ts
+	})
+	if err != nil {
+		return err
+	}
+	for i, c := range e.channels {
+		err := e.array(zw, c.name, "<f8", n, func(sec spoolSecond, chs [][]float64) interface{} {
+			return chs[i]
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// array writes the .npy entry name of n elements, whose values of each
+// second are returned by values.
+func (e *npzEncoder) array(zw *zip.Writer, name, descr string, n int,
+	values func(sec spoolSecond, chs [][]float64) interface{}) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name + ".npy", Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, npyHeader(descr, n)); err != nil {
+		return err
+	}
+	return e.each(0, func(sec spoolSecond, chs [][]float64) error {
+		return binary.Write(w, binary.LittleEndian, values(sec, chs))
+	})
+}
+
+// npyHeader returns the format 1.0 header of an array of the given
+// shape, in C order.
+func npyHeader(descr string, shape ...int) string {
+	dims := make([]string, len(shape))
+	for i, n := range shape {
+		dims[i] = strconv.Itoa(n)
+	}
+	tuple := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		tuple += ","
+	}
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, tuple)
+	l := len(NPY_MAGIC) + 2 + len(dict) + 1
+	pad := (NPY_ALIGNMENT - l%NPY_ALIGNMENT) % NPY_ALIGNMENT
+	dict += strings.Repeat(" ", pad) + "\n"
+	return NPY_MAGIC + string([]byte{byte(len(dict)), byte(len(dict) >> 8)}) + dict
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	OPENSIGNALS_DEVICE     = "vital2csv"
+	OPENSIGNALS_RESOLUTION = 16
+)
+
+// opensignalsSensors are the sensor types of the signals, by signal name.
+// Other signals are written as custom sensors.
+var opensignalsSensors = map[string]string{
+	"ecg":   "ECG",
+	"accel": "ACC",
+}
+
+// opensignalsEncoder writes the OpenSignals (r)evolution text format: a
+// JSON header line describing the device, followed by tab separated rows
+// of a 4 bit sequence number, four digital I/O columns and one column per
+// channel. Channel values are unsigned 16 bit integers scaled to the
+// physical range of the channel.
+//
+// The format has no time column, so the signal is resampled to a constant
+// rate and missing seconds are filled with the mid-scale value.
+type opensignalsEncoder struct {
+	spool
+	w io.Writer
+}
+
+func newOpenSignalsEncoder(w io.Writer, opts *Options) encoder {
+	return &opensignalsEncoder{w: w}
+}
+
+func (e *opensignalsEncoder) Header(s *signal, v interface{}) error {
+	return e.open(s)
+}
+
+func (e *opensignalsEncoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *opensignalsEncoder) Close() error {
+	defer e.release()
+
+	rate := e.sampleRate()
+	w := bufio.NewWriter(e.w)
+	header, err := e.header(rate)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# OpenSignals Text File Format\n# %s\n# EndOfHeader\n", header)
+
+	nc := len(e.channels)
+	seq := 0
+	row := func(ds []int) {
+		w.WriteString(strconv.Itoa(seq) + "\t0\t0\t0\t0")
+		for _, d := range ds {
+			w.WriteString("\t" + strconv.Itoa(d))
+		}
+		w.WriteString("\n")
+		seq = (seq + 1) % 16
+	}
+
+	mid := make([]int, nc)
+	for i := range mid {
+		mid[i] = 1 << (OPENSIGNALS_RESOLUTION - 1)
+	}
+	var last int64
+	ds := make([]int, nc)
+	err = e.each(rate, func(sec spoolSecond, chs [][]float64) error {
+		for gap := last + 1; last > 0 && gap < sec.ztime; gap++ {
+			for j := 0; j < rate; j++ {
+				row(mid)
+			}
+		}
+		last = sec.ztime
+		for j := 0; j < rate; j++ {
+			for i := range chs {
+				ds[i] = e.digital(i, chs[i][j])
+			}
+			row(ds)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (e *opensignalsEncoder) digital(i int, v float64) int {
+	min, max := e.physical(i)
+	if math.IsNaN(v) {
+		return 0
+	}
+	return int(math.Round((v - min) / (max - min) * (1<<OPENSIGNALS_RESOLUTION - 1)))
+}
+
+func (e *opensignalsEncoder) header(rate int) ([]byte, error) {
+	var start time.Time
+	if len(e.seconds) > 0 {
+		start = time.Unix(e.seconds[0].ztime, 0).In(outputZone)
+	}
+
+	column := []string{"nSeq", "I1", "I2", "O1", "O2"}
+	var (
+		channels, resolution []int
+		label, sensor        []string
+		special              []struct{}
+	)
+	sensorType, ok := opensignalsSensors[e.sig.name]
+	if !ok {
+		sensorType = "CUSTOM"
+	}
+	for i := range e.channels {
+		channels = append(channels, i+1)
+		label = append(label, "A"+strconv.Itoa(i+1))
+		resolution = append(resolution, OPENSIGNALS_RESOLUTION)
+		special = append(special, struct{}{})
+		sensor = append(sensor, sensorType)
+	}
+	column = append(column, label...)
+
+	return json.Marshal(map[string]interface{}{
+		OPENSIGNALS_DEVICE: map[string]interface{}{
+			"device":            "biosignalsplux",
+			"device name":       OPENSIGNALS_DEVICE,
+			"device connection": "",
+			"firmware version":  0,
+			"position":          0,
+			"mode":              0,
+			"sync interval":     2,
+			"sampling rate":     rate,
+			"date":              fmt.Sprintf("%d-%d-%d", start.Year(), start.Month(), start.Day()),
+			"time":              start.Format("15:04:05.000"),
+			"comments":          "",
+			"digital IO":        []int{0, 0, 1, 1},
+			"column":            column,
+			"channels":          channels,
+			"label":             label,
+			"sensor":            sensor,
+			"resolution":        resolution,
+			"special":           special,
+		},
+	})
+}
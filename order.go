@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// Policies of -out-of-order for the rows whose z_fok_timestamp regresses
+// within their second, which are spaced out of order by the
+// interpolation. The statements read the rows of a second in the order
+// they were stored, by primary key: "sort" sorts them by z_fok_timestamp,
+// "drop" leaves out those below the largest of the rows before them, and
+// "warn" keeps them as stored. All log how many there were.
+var outOfOrderPolicies = []string{"warn", "sort", "drop"}
+
+var outOfOrderPolicy = "sort"
+
+// orderRows applies the policy of -out-of-order to the rows of a signal,
+// reading them second by second with "sort".
+type orderRows struct {
+	rowScanner
+	policy string
+	label  string
+	held   []vitalRow // of the second, with "sort"
+	next   *vitalRow  // the first row of the next second
+	cur    vitalRow
+	max    vitalRow // of the largest z_fok_timestamp of the second
+	read   bool
+	err    error
+	n      int // rows out of order
+}
+
+func newOrderRows(rows rowScanner, policy, label string) *orderRows {
+	return &orderRows{rowScanner: rows, policy: policy, label: label}
+}
+
+func (o *orderRows) Next() bool {
+	if o.policy == "sort" {
+		return o.nextSorted()
+	}
+	for o.rowScanner.Next() {
+		var r vitalRow
+		if o.err = o.rowScanner.StructScan(&r); o.err != nil {
+			return true
+		}
+		if o.read && r.Ztime == o.max.Ztime && r.ZFokTimestamp < o.max.ZFokTimestamp {
+			o.n++
+			if o.policy == "drop" {
+				continue
+			}
+		} else {
+			o.max, o.read = r, true
+		}
+		o.cur = r
+		return true
+	}
+	return false
+}
+
+// nextSorted reads the rows of a second, sorted by z_fok_timestamp, and
+// returns them in turn.
+func (o *orderRows) nextSorted() bool {
+	if len(o.held) == 0 {
+		if o.next != nil {
+			o.held = append(o.held, *o.next)
+			o.max, o.next = *o.next, nil
+		}
+		for o.rowScanner.Next() {
+			var r vitalRow
+			if o.err = o.rowScanner.StructScan(&r); o.err != nil {
+				return true
+			}
+			if len(o.held) > 0 && r.Ztime != o.held[0].Ztime {
+				o.next = &r
+				break
+			}
+			if len(o.held) > 0 && r.ZFokTimestamp < o.max.ZFokTimestamp {
+				o.n++
+			} else {
+				o.max = r
+			}
+			o.held = append(o.held, r)
+		}
+		sort.SliceStable(o.held, func(i, j int) bool { return o.held[i].ZFokTimestamp < o.held[j].ZFokTimestamp })
+	}
+	if len(o.held) == 0 {
+		return false
+	}
+	o.cur, o.held = o.held[0], o.held[1:]
+	return true
+}
+
+func (o *orderRows) StructScan(dest interface{}) error {
+	if o.err != nil {
+		return o.err
+	}
+	o.cur.scan(dest)
+	return nil
+}
+
+func (o *orderRows) Close() error {
+	if o.n > 0 {
+		verb := map[string]string{"warn": "kept", "sort": "sorted", "drop": "dropped"}[o.policy]
+		log.Printf("%s: %d rows with z_fok_timestamp out of order within their second, %s", o.label, o.n, verb)
+	}
+	return o.rowScanner.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Parquet rows carry typed timestamps instead of the formatted strings
+// of the CSV output: timestamp is stored as TIMESTAMP(MILLIS) and
+// detailed_timestamp as TIMESTAMP(NANOS), both adjusted to UTC.
+type parquetEcg struct {
+	Timestamp         time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ZFokTimestamp     int64     `parquet:"z_fok_timestamp"`
+	Value             float64   `parquet:"value"`
+	DetailedTimestamp time.Time `parquet:"detailed_timestamp,timestamp(nanosecond)"`
+}
+
+type parquetAccel struct {
+	Timestamp         time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ZFokTimestamp     int64     `parquet:"z_fok_timestamp"`
+	X                 float64   `parquet:"x"`
+	Y                 float64   `parquet:"y"`
+	Z                 float64   `parquet:"z"`
+	DetailedTimestamp time.Time `parquet:"detailed_timestamp,timestamp(nanosecond)"`
+}
+
+type parquetSpo2 struct {
+	Timestamp         time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ZFokTimestamp     int64     `parquet:"z_fok_timestamp"`
+	Value             float64   `parquet:"value"`
+	Quality           *float64  `parquet:"quality,optional"`
+	DetailedTimestamp time.Time `parquet:"detailed_timestamp,timestamp(nanosecond)"`
+}
+
+type parquetSample struct {
+	Timestamp         time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	ZFokTimestamp     int64     `parquet:"z_fok_timestamp"`
+	Signal            string    `parquet:"signal,dict"`
+	Value             float64   `parquet:"value"`
+	DetailedTimestamp time.Time `parquet:"detailed_timestamp,timestamp(nanosecond)"`
+}
+
+const PARQUET_ROW_GROUP_SIZE = 1 << 20
+
+type parquetEncoder struct {
+	w  io.Writer
+	pw *parquet.Writer
+}
+
+func newParquetEncoder(w io.Writer, opts *Options) encoder {
+	return &parquetEncoder{w: w}
+}
+
+// Header creates the parquet writer. The schema depends on the sample
+// type, so it cannot be built before the first call.
+func (e *parquetEncoder) Header(s *signal, v interface{}) error {
+	var schema *parquet.Schema
+	switch v.(type) {
+	case *[]Ecg:
+		schema = parquet.SchemaOf(parquetEcg{})
+	case *[]Accel:
+		schema = parquet.SchemaOf(parquetAccel{})
+	case *[]Spo2:
+		schema = parquet.SchemaOf(parquetSpo2{})
+	case *[]Sample:
+		schema = parquet.SchemaOf(parquetSample{})
+	}
+	e.pw = parquet.NewWriter(e.w, schema,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(PARQUET_ROW_GROUP_SIZE))
+	return nil
+}
+
+func (e *parquetEncoder) Encode(s *signal, v interface{}) error {
+	switch rs := v.(type) {
+	case *[]Ecg:
+		for _, r := range *rs {
+			err := e.pw.Write(parquetEcg{
+				Timestamp:         time.Unix(r.Ztime, 0),
+				ZFokTimestamp:     r.ZFokTimestamp,
+				Value:             r.Zvalue,
+				DetailedTimestamp: r.Detailed,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case *[]Accel:
+		for _, r := range *rs {
+			err := e.pw.Write(parquetAccel{
+				Timestamp:         time.Unix(r.Ztime, 0),
+				ZFokTimestamp:     r.ZFokTimestamp,
+				X:                 float64(r.X),
+				Y:                 float64(r.Y),
+				Z:                 float64(r.Z),
+				DetailedTimestamp: r.Detailed,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case *[]Spo2:
+		for _, r := range *rs {
+			err := e.pw.Write(parquetSpo2{
+				Timestamp:         time.Unix(r.Ztime, 0),
+				ZFokTimestamp:     r.ZFokTimestamp,
+				Value:             r.Zvalue,
+				Quality:           r.Quality,
+				DetailedTimestamp: r.Detailed,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case *[]Sample:
+		for _, r := range *rs {
+			err := e.pw.Write(parquetSample{
+				Timestamp:         time.Unix(r.Ztime, 0),
+				ZFokTimestamp:     r.ZFokTimestamp,
+				Signal:            r.Signal,
+				Value:             r.Value,
+				DetailedTimestamp: r.Detailed,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.pw.Close()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	POLAR_PHONE_TIME  = "2006-01-02T15:04:05.999999999"
+	POLAR_VALUE_SCALE = 1e-3 // µV to mV, mG to g
+)
+
+// Polar Sensor Logger writes one file per stream, e.g.
+// "Polar_H10_A1B2C3D4_20230101_120000_ECG.txt".
+var polarFile = regexp.MustCompile(`(?i)^(.*)_(ECG|ACC)\.txt$`)
+
+// isPolar reports whether path is a file of a Polar Sensor Logger
+// export, or a zip of one.
+func isPolar(p string) bool {
+	if polarFile.MatchString(filepath.Base(p)) {
+		return true
+	}
+	if !strings.HasSuffix(strings.ToLower(p), ".zip") {
+		return false
+	}
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return false
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if polarFile.MatchString(path.Base(zf.Name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// polarName returns the recording name of a Polar file, without the
+// stream suffix.
+func polarName(name string) string {
+	if m := polarFile.FindStringSubmatch(name + ".txt"); m != nil {
+		return m[1]
+	}
+	return name
+}
+
+// polarSource reads a recording of Polar Sensor Logger: the ECG (130 Hz,
+// µV) and ACC (200 Hz, mG) streams of a sensor such as the H10. Given one
+// stream file, the other one of the recording is read from the same
+// directory; given a zip, the streams in it. The time of a sample is the
+// phone time of the first sample plus the sensor time elapsed since
+// then, as the epoch of the sensor time differs between app versions.
+// zfok_timestamp is the sample index in the stream.
+type polarSource struct {
+	ecg, accel []vitalRow
+}
+
+func openPolar(p string) (*polarSource, error) {
+	s := &polarSource{}
+	read := func(name string, r io.Reader) error {
+		m := polarFile.FindStringSubmatch(path.Base(name))
+		if m == nil {
+			return nil
+		}
+		rows, err := readPolar(r, strings.ToUpper(m[2]))
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if strings.EqualFold(m[2], "ECG") {
+			s.ecg = rows
+		} else {
+			s.accel = rows
+		}
+		return nil
+	}
+
+	if m := polarFile.FindStringSubmatch(filepath.Base(p)); m != nil {
+		for _, stream := range []string{"ECG", "ACC"} {
+			name := filepath.Join(filepath.Dir(p), m[1]+"_"+stream+".txt")
+			if strings.EqualFold(stream, m[2]) {
+				name = p
+			}
+			f, err := os.Open(name)
+			if os.IsNotExist(err) && name != p {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			err = read(name, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+
+	zr, err := zip.OpenReader(p)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		r, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = read(zf.Name, r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// readPolar reads a stream file: a header line, then one sample per
+// line separated by ";". ECG rows hold one value, ACC rows x, y and z,
+// returned as three consecutive rows like the accel rows of a vital file.
+func readPolar(r io.Reader, stream string) ([]vitalRow, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = ';'
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	phone, sensor := -1, -1
+	var values []int
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		switch {
+		case strings.HasPrefix(h, "phone timestamp"):
+			phone = i
+		case strings.HasPrefix(h, "sensor timestamp"):
+			sensor = i
+		case stream == "ECG" && strings.HasPrefix(h, "ecg"),
+			stream == "ACC" && (strings.HasPrefix(h, "x ") || strings.HasPrefix(h, "y ") || strings.HasPrefix(h, "z ")):
+			values = append(values, i)
+		}
+	}
+	if phone < 0 || sensor < 0 || len(values) == 0 {
+		return nil, fmt.Errorf("Unknown header: %s", strings.Join(header, ";"))
+	}
+
+	var (
+		rows    []vitalRow
+		start   time.Time
+		sensor0 int64
+	)
+	for i := 0; ; i++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) <= phone || len(rec) <= sensor || len(rec) <= values[len(values)-1] {
+			return nil, fmt.Errorf("Short line: %s", strings.Join(rec, ";"))
+		}
+		ns, err := strconv.ParseInt(strings.TrimSpace(rec[sensor]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid sensor timestamp: %q", rec[sensor])
+		}
+		if i == 0 {
+			start, err = time.ParseInLocation(POLAR_PHONE_TIME, strings.TrimSpace(rec[phone]), time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid phone timestamp: %q", rec[phone])
+			}
+			sensor0 = ns
+		}
+		t := start.Add(time.Duration(ns - sensor0))
+		for _, c := range values {
+			v, err := strconv.ParseFloat(strings.TrimSpace(rec[c]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid sample: %q", rec[c])
+			}
+			rows = append(rows, vitalRow{Ztime: t.Unix(), Nanos: int64(t.Nanosecond()), ZFokTimestamp: int64(i), Value: v * POLAR_VALUE_SCALE})
+		}
+	}
+}
+
+func (s *polarSource) query(t int) (rowScanner, error) {
+	switch t {
+	case ECG_TYPE:
+		return &sliceRows{rows: s.ecg}, nil
+	case ACCEL_TYPE:
+		return &sliceRows{rows: s.accel}, nil
+	}
+	return &sliceRows{}, nil
+}
+
+func (s *polarSource) Close() error {
+	return nil
+}
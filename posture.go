@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const POSTURE_FILE_SUFFIX = ".posture.csv"
+
+// postureAxes are the axes of the accel pointing to the left of the
+// subject, to the head and to the front, by index (0 for x) and sign,
+// given by -posture-axes.
+type postureAxes [3]struct {
+	axis int
+	sign float64
+}
+
+// parsePostureAxes parses -posture-axes, the axes of the accel pointing
+// to the left, to the head and to the front of the subject, such as
+// "x,y,z" or "-y,x,z".
+func parsePostureAxes(spec string) (postureAxes, error) {
+	var p postureAxes
+	used := make(map[int]bool)
+	parts := strings.Split(spec, ",")
+	if len(parts) != 3 {
+		return p, fmt.Errorf("Invalid -posture-axes %q, give the axes to the left, the head and the front, such as x,y,z", spec)
+	}
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		p[i].sign = 1
+		if strings.HasPrefix(part, "-") {
+			p[i].sign, part = -1, part[1:]
+		}
+		axis := strings.Index("xyz", part)
+		if len(part) != 1 || axis < 0 || used[axis] {
+			return p, fmt.Errorf("Invalid -posture-axes %q, give the axes to the left, the head and the front, such as x,y,z", spec)
+		}
+		p[i].axis, used[axis] = axis, true
+	}
+	return p, nil
+}
+
+// posture classifies the mean of the accel of an epoch, which at rest is
+// the reaction to gravity pointing up, by the body axis nearest to it:
+// upright with the head up, supine with the front up, prone with it
+// down, and left or right with that side down. unknown is upside down.
+// inclination is the angle of the head axis from the vertical in
+// degrees.
+func (p postureAxes) posture(v [3]float64) (class string, inclination float64) {
+	var left, head, front float64
+	for i, c := range []*float64{&left, &head, &front} {
+		*c = p[i].sign * v[p[i].axis]
+	}
+	norm := math.Sqrt(left*left + head*head + front*front)
+	if norm == 0 {
+		return "", math.NaN()
+	}
+	inclination = math.Acos(head/norm) * 180 / math.Pi
+	switch {
+	case math.Abs(head) >= math.Abs(left) && math.Abs(head) >= math.Abs(front):
+		class = "upright"
+		if head < 0 {
+			class = "unknown"
+		}
+	case math.Abs(front) >= math.Abs(left):
+		class = "supine"
+		if front < 0 {
+			class = "prone"
+		}
+	default:
+		class = "right"
+		if left < 0 {
+			class = "left"
+		}
+	}
+	return class, inclination
+}
+
+// postureEpoch is an epoch of the accel with the mean of its samples.
+type postureEpoch struct {
+	start   time.Time
+	samples int
+	mean    [3]float64
+}
+
+// postureEncoder averages the accel passed to its encoder by epochs of
+// -posture-epoch, aligned to multiples of their length since the Unix
+// epoch. Samples missing an axis are left out. flush ends the last
+// epoch.
+type postureEncoder struct {
+	encoder
+	length time.Duration
+	epochs []postureEpoch
+	cur    postureEpoch
+	sum    [3]float64
+}
+
+func (e *postureEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		if math.IsNaN(vs[0]) || math.IsNaN(vs[1]) || math.IsNaN(vs[2]) {
+			return nil
+		}
+		start := detailed.Truncate(e.length)
+		if e.cur.samples > 0 && !start.Equal(e.cur.start) {
+			e.flush()
+		}
+		e.cur.start = start
+		e.cur.samples++
+		for i := range e.sum {
+			e.sum[i] += vs[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// flush ends the current epoch.
+func (e *postureEncoder) flush() {
+	if e.cur.samples == 0 {
+		return
+	}
+	for i := range e.sum {
+		e.cur.mean[i] = e.sum[i] / float64(e.cur.samples)
+	}
+	e.epochs = append(e.epochs, e.cur)
+	e.cur, e.sum = postureEpoch{}, [3]float64{}
+}
+
+// writePosture writes the epochs of the accel to <name>.posture.csv,
+// with their mean, the inclination of the trunk and the posture.
+func writePosture(e *postureEncoder, axes postureAxes, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"time", "timestamp", "samples", "x", "y", "z", "inclination", "posture"})
+	value := func(v float64) string {
+		if math.IsNaN(v) {
+			return ""
+		}
+		return strconv.FormatFloat(round(v), 'g', -1, 64)
+	}
+	for _, p := range e.epochs {
+		class, inclination := axes.posture(p.mean)
+		w.Write([]string{
+			timeLayout.formatTime(p.start),
+			strconv.FormatInt(p.start.Unix(), 10),
+			strconv.Itoa(p.samples),
+			value(p.mean[0]),
+			value(p.mean[1]),
+			value(p.mean[2]),
+			value(inclination),
+			class,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+POSTURE_FILE_SUFFIX), b.Bytes())
+}
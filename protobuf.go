@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufEncoder writes a stream of length-delimited Ecg, Accel or Spo2
+// messages as defined in vital2csv.proto. The messages are small and
+// flat, so they are encoded with protowire instead of generated code.
+type protobufEncoder struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+func newProtobufEncoder(w io.Writer, opts *Options) encoder {
+	return &protobufEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *protobufEncoder) Header(s *signal, v interface{}) error {
+	return nil
+}
+
+func (e *protobufEncoder) Encode(s *signal, v interface{}) error {
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		return e.message(ztime, zfok, detailed.UnixNano(), vs...)
+	})
+}
+
+// message writes the fields in their number order: timestamp,
+// z_fok_timestamp, the channel values and detailed_timestamp. Fields with
+// zero values are omitted as in proto3.
+func (e *protobufEncoder) message(ztime, zfok, detailed int64, vs ...float64) error {
+	var num protowire.Number
+	b := e.buf[:0]
+	varint := func(x int64) {
+		num++
+		if x != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(x))
+		}
+	}
+	varint(ztime)
+	varint(zfok)
+	for _, v := range vs {
+		num++
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(v))
+		}
+	}
+	varint(detailed)
+	e.buf = b
+
+	if _, err := e.w.Write(protowire.AppendVarint(nil, uint64(len(b)))); err != nil {
+		return err
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+func (e *protobufEncoder) Close() error {
+	return e.w.Flush()
+}
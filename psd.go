@@ -0,0 +1,231 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"time"
+)
+
+const PSD_FILE_SUFFIX = ".psd"
+
+// PSD_SEGMENT is the length of the segments of the Welch estimate of
+// -psd in samples, a power of two for the FFT. Segments overlap by half
+// and are weighted by a Hann window.
+const PSD_SEGMENT = 512
+
+// Formats of the frames of -psd.
+var psdFormats = []string{"csv", "npz"}
+
+// psdFrame is a frame of the ECG with the power spectral density of its
+// samples, the mean of the periodograms of its segments.
+type psdFrame struct {
+	start    time.Time
+	segments int
+	power    []float64
+}
+
+// psdEncoder estimates the power spectral density of the ECG passed to
+// its encoder by frames of -psd, aligned to multiples of their length
+// since the Unix epoch, by Welch's method. A gap or a missing sample ends
+// the run of samples segments are taken from. The sample rate is that of
+// the signal, or else that of the first segment. flush ends the last
+// frame.
+type psdEncoder struct {
+	encoder
+	length time.Duration
+	rate   float64
+	frames []psdFrame
+	cur    psdFrame
+	run    []float64
+	times  []time.Time // of the samples of run
+	window []float64
+}
+
+func newPSDEncoder(enc encoder, length time.Duration, rate float64) *psdEncoder {
+	window := make([]float64, PSD_SEGMENT)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/PSD_SEGMENT)
+	}
+	return &psdEncoder{encoder: enc, length: length, rate: rate, window: window}
+}
+
+func (e *psdEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		start := detailed.Truncate(e.length)
+		if !e.cur.start.IsZero() && !start.Equal(e.cur.start) {
+			e.flush()
+		}
+		e.cur.start = start
+		if n := len(e.times); math.IsNaN(vs[0]) ||
+			n >= 2 && detailed.Sub(e.times[n-1]) > 2*e.times[n-1].Sub(e.times[n-2]) {
+			e.run, e.times = e.run[:0], e.times[:0]
+		}
+		if math.IsNaN(vs[0]) {
+			return nil
+		}
+		e.run, e.times = append(e.run, vs[0]), append(e.times, detailed)
+		if len(e.run) == PSD_SEGMENT {
+			e.segment()
+			half := PSD_SEGMENT / 2
+			e.run, e.times = append(e.run[:0], e.run[half:]...), append(e.times[:0], e.times[half:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// segment adds the periodogram of the run, of PSD_SEGMENT samples, to the
+// current frame, as a one-sided density in the unit squared per Hz.
+func (e *psdEncoder) segment() {
+	if e.rate == 0 {
+		e.rate = float64(PSD_SEGMENT-1) / e.times[PSD_SEGMENT-1].Sub(e.times[0]).Seconds()
+	}
+	var mean float64
+	for _, v := range e.run {
+		mean += v
+	}
+	mean /= PSD_SEGMENT
+	x := make([]complex128, PSD_SEGMENT)
+	var norm float64
+	for i, v := range e.run {
+		x[i] = complex((v-mean)*e.window[i], 0)
+		norm += e.window[i] * e.window[i]
+	}
+	fft(x)
+	if e.cur.power == nil {
+		e.cur.power = make([]float64, PSD_SEGMENT/2+1)
+	}
+	for k := range e.cur.power {
+		p := cmplx.Abs(x[k]) * cmplx.Abs(x[k]) / (e.rate * norm)
+		if k > 0 && k < PSD_SEGMENT/2 {
+			p *= 2
+		}
+		e.cur.power[k] += p
+	}
+	e.cur.segments++
+}
+
+// flush ends the current frame, kept if a segment was taken in it. Runs
+// go on across frames, a segment being of the frame it ends in.
+func (e *psdEncoder) flush() {
+	if e.cur.segments > 0 {
+		for k := range e.cur.power {
+			e.cur.power[k] /= float64(e.cur.segments)
+		}
+		e.frames = append(e.frames, e.cur)
+	}
+	e.cur = psdFrame{}
+}
+
+// frequencies returns the frequencies of the bins of the frames in Hz.
+func (e *psdEncoder) frequencies() []float64 {
+	fs := make([]float64, PSD_SEGMENT/2+1)
+	for k := range fs {
+		fs[k] = float64(k) * e.rate / PSD_SEGMENT
+	}
+	return fs
+}
+
+// fft replaces x, of a length that is a power of two, by its discrete
+// Fourier transform.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*wk
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				wk *= w
+			}
+		}
+	}
+}
+
+// writePSD writes the frames of the ECG to <name>.psd.csv, a row by
+// frame with the density of each frequency in columns named by it in
+// Hz, or to <name>.psd.npz, with the arrays "time" of the starts of the
+// frames in Unix nanoseconds, "segments", "frequency" and "power" of
+// frames by frequencies.
+func writePSD(e *psdEncoder, format string, opts *Options) error {
+	fs := e.frequencies()
+	var b bytes.Buffer
+	if format == "npz" {
+		times := make([]int64, len(e.frames))
+		segments := make([]int64, len(e.frames))
+		power := make([]float64, 0, len(e.frames)*len(fs))
+		for i, f := range e.frames {
+			times[i], segments[i] = f.start.UnixNano(), int64(f.segments)
+			power = append(power, f.power...)
+		}
+		zw := zip.NewWriter(&b)
+		for _, a := range []struct {
+			name, descr string
+			shape       []int
+			data        interface{}
+		}{
+			{"time", "<i8", []int{len(times)}, times},
+			{"segments", "<i8", []int{len(segments)}, segments},
+			{"frequency", "<f8", []int{len(fs)}, fs},
+			{"power", "<f8", []int{len(e.frames), len(fs)}, power},
+		} {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: a.name + ".npy", Method: zip.Deflate})
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(npyHeader(a.descr, a.shape...))); err != nil {
+				return err
+			}
+			if err := binary.Write(w, binary.LittleEndian, a.data); err != nil {
+				return err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		return writeOutput(joinOutput(opts.OutDir, opts.Name+PSD_FILE_SUFFIX+".npz"), b.Bytes())
+	}
+
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	header := []string{"time", "timestamp", "segments"}
+	for _, f := range fs {
+		header = append(header, strconv.FormatFloat(round(f), 'g', -1, 64))
+	}
+	w.Write(header)
+	for _, f := range e.frames {
+		row := []string{
+			timeLayout.formatTime(f.start),
+			strconv.FormatInt(f.start.Unix(), 10),
+			strconv.Itoa(f.segments),
+		}
+		for _, p := range f.power {
+			row = append(row, strconv.FormatFloat(round(p), 'g', -1, 64))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+PSD_FILE_SUFFIX+".csv"), b.Bytes())
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+)
+
+const BEATS_FILE_SUFFIX = ".beats.csv"
+
+// qrsSample is an ECG sample held by the detector.
+type qrsSample struct {
+	t     time.Time
+	ztime int64
+	v     float64
+}
+
+// qrsDetector finds the R peaks of the ECG as it is written, by the
+// algorithm of Pan and Tompkins: the ECG is band-pass filtered to 5-15
+// Hz, differentiated, squared and integrated over a moving window of 150
+// ms, and the peaks of the result are QRS complexes when above an
+// adaptive threshold between the running levels of the signal and noise
+// peaks, and with an R peak 200 ms after the previous one. The R peak is
+// the sample deviating most from the mean of the ECG over the window of
+// the complex.
+//
+// The levels are learned from the first two seconds, whose peaks are
+// classified once they are; there is no search back for beats missed.
+// The detector starts again at a gap, a second of ztime without samples.
+// The sample rate is that of the samples passed, if known, or else the
+// number of samples of the first whole second, for which the first two
+// seconds are held.
+type qrsDetector struct {
+	encoder
+	rate  float64
+	held  []qrsSample
+	beats []beat
+
+	sections   []biquad
+	bp         [4]float64 // the last band-passed values, latest first
+	window     []float64  // of the squared derivative, a ring
+	sum        float64
+	recent     []qrsSample // of the ECG, up to the latest
+	n          int         // samples of the run
+	mwi        [2]float64  // the last integrated values, latest first
+	learning   []qrsPeak
+	spki, npki float64
+	max, total float64
+	last       time.Time // of the R peak of the last complex
+	ztime      int64     // of the last sample
+}
+
+// qrsPeak is a peak of the integrated signal, with the R peak of the
+// complex if it is one.
+type qrsPeak struct {
+	value float64
+	t     time.Time
+	r     time.Time
+}
+
+func newQRSDetector(enc encoder, rate float64) *qrsDetector {
+	return &qrsDetector{encoder: enc, rate: rate}
+}
+
+func (d *qrsDetector) Encode(s *signal, v interface{}) error {
+	if es, ok := v.(*[]Ecg); ok {
+		for _, e := range *es {
+			d.push(qrsSample{e.Detailed, e.Ztime, e.Zvalue})
+		}
+	}
+	return d.encoder.Encode(s, v)
+}
+
+// push passes a sample to the detector, held until the rate is known.
+func (d *qrsDetector) push(x qrsSample) {
+	if d.sections == nil {
+		d.held = append(d.held, x)
+		if d.rate == 0 && x.ztime-d.held[0].ztime < 2 {
+			return
+		}
+		d.flush()
+		return
+	}
+	d.step(x)
+}
+
+// flush passes the samples held to the detector, at the rate of the
+// samples of their seconds if unknown.
+func (d *qrsDetector) flush() {
+	if len(d.held) == 0 {
+		return
+	}
+	if d.sections == nil {
+		if d.rate == 0 {
+			counts := make(map[int64]int)
+			for _, x := range d.held {
+				counts[x.ztime]++
+				d.rate = max(d.rate, float64(counts[x.ztime]))
+			}
+		}
+		d.sections = (&filterSpec{low: 5, high: 15}).butterworth(d.rate, butterworth2Q)
+	}
+	held := d.held
+	d.held = nil
+	for _, x := range held {
+		d.step(x)
+	}
+}
+
+// reset starts the detector again at sample x.
+func (d *qrsDetector) reset(x qrsSample) {
+	v := x.v
+	for i := range d.sections {
+		v = d.sections[i].reset(v)
+	}
+	d.bp = [4]float64{v, v, v, v}
+	d.window = make([]float64, max(int(math.Round(0.15*d.rate)), 1))
+	d.sum, d.n, d.mwi = 0, 0, [2]float64{}
+	d.recent = d.recent[:0]
+	d.learning, d.spki, d.npki, d.max, d.total = nil, 0, 0, 0, 0
+	d.last = time.Time{}
+}
+
+func (d *qrsDetector) step(x qrsSample) {
+	if d.ztime == 0 || x.ztime-d.ztime > 1 {
+		d.reset(x)
+	}
+	d.ztime = x.ztime
+
+	y := x.v
+	for i := range d.sections {
+		y = d.sections[i].step(y)
+	}
+	deriv := (2*y + d.bp[0] - d.bp[2] - 2*d.bp[3]) * d.rate / 8
+	d.bp = [4]float64{y, d.bp[0], d.bp[1], d.bp[2]}
+	sq := deriv * deriv
+	i := d.n % len(d.window)
+	d.sum += sq - d.window[i]
+	d.window[i] = sq
+	mwi := d.sum / float64(len(d.window))
+
+	// The complex is within the window before the integrated peak, and
+	// the filter delays it by about another.
+	d.recent = append(d.recent, x)
+	if keep := 2 * len(d.window); len(d.recent) > keep {
+		d.recent = append(d.recent[:0], d.recent[len(d.recent)-keep:]...)
+	}
+	d.n++
+
+	// The previous value is a peak if above both of its neighbours.
+	if d.n > 2 && d.mwi[0] > d.mwi[1] && d.mwi[0] >= mwi {
+		d.peak(qrsPeak{value: d.mwi[0], t: d.recent[len(d.recent)-2].t, r: d.rPeak()})
+	}
+	d.mwi = [2]float64{mwi, d.mwi[0]}
+
+	if float64(d.n) <= 2*d.rate {
+		d.max = max(d.max, mwi)
+		d.total += mwi
+		if float64(d.n) == math.Floor(2*d.rate) {
+			d.spki, d.npki = 0.25*d.max, 0.5*d.total/float64(d.n)
+			learned := d.learning
+			d.learning = nil
+			for _, p := range learned {
+				d.peak(p)
+			}
+		}
+	}
+}
+
+// rPeak returns the time of the sample of the ECG held deviating most
+// from their mean.
+func (d *qrsDetector) rPeak() time.Time {
+	mean := 0.0
+	for _, x := range d.recent {
+		mean += x.v
+	}
+	mean /= float64(len(d.recent))
+	r := d.recent[0]
+	for _, x := range d.recent {
+		if math.Abs(x.v-mean) > math.Abs(r.v-mean) {
+			r = x
+		}
+	}
+	return r.t
+}
+
+// peak classifies a peak of the integrated signal as a complex or noise,
+// and updates their levels.
+func (d *qrsDetector) peak(p qrsPeak) {
+	if d.spki == 0 && d.npki == 0 {
+		d.learning = append(d.learning, p)
+		return
+	}
+	threshold := d.npki + 0.25*(d.spki-d.npki)
+	if p.value <= threshold || !d.last.IsZero() && p.r.Sub(d.last) < 200*time.Millisecond {
+		d.npki = 0.125*p.value + 0.875*d.npki
+		return
+	}
+	d.spki = 0.125*p.value + 0.875*d.spki
+	// The first beat of a run has no interval.
+	b := beat{time: p.r, interval: math.NaN()}
+	if n := len(d.beats); n > 0 && !d.last.IsZero() {
+		b.interval = intervalMs(d.beats[n-1].time, p.r)
+	}
+	d.last = p.r
+	d.beats = append(d.beats, b)
+}
+
+// writeDetectedBeats writes the R peaks found to <name>.beats.csv, with
+// the time columns of the samples, the RR interval in milliseconds and
+// the instantaneous heart rate in beats per minute.
+func writeDetectedBeats(beats []beat, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"time", "timestamp", "detailed_timestamp", "interval_ms", "heart_rate"})
+	for _, r := range beats {
+		interval, hr := "", ""
+		if !math.IsNaN(r.interval) {
+			interval = strconv.FormatFloat(round(r.interval), 'g', -1, 64)
+			hr = strconv.FormatFloat(round(60000/r.interval), 'g', -1, 64)
+		}
+		w.Write([]string{
+			timeLayout.formatTime(time.Unix(r.time.Unix(), 0)),
+			strconv.FormatInt(r.time.Unix(), 10),
+			timeLayout.formatDetailed(r.time),
+			interval,
+			hr,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+BEATS_FILE_SUFFIX), b.Bytes())
+}
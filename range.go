@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeRange is the window of -from and -to: the samples from from up to,
+// not including, to. Either is zero if the window is unbounded there.
+type timeRange struct {
+	from, to time.Time
+}
+
+// parseTimeBound parses a bound of -from or -to: an RFC 3339 time, or
+// seconds since the Unix epoch.
+func parseTimeBound(option, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+		return time.Time{}, fmt.Errorf("Invalid %s %q, give an RFC 3339 time or seconds since the Unix epoch", option, s)
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))), nil
+}
+
+func (r timeRange) bounded() bool {
+	return !r.from.IsZero() || !r.to.IsZero()
+}
+
+func (r timeRange) contains(t time.Time) bool {
+	return (r.from.IsZero() || !t.Before(r.from)) && (r.to.IsZero() || t.Before(r.to))
+}
+
+// statement returns a statement of a vitalSchema reading only the rows
+// whose time, the column of the time table, is within r: the predicate
+// is added before its ORDER BY clause, or its end.
+func (r timeRange) statement(statement, column string) string {
+	var where string
+	if !r.from.IsZero() {
+		where += fmt.Sprintf(" AND CAST(%s AS REAL) >= :range_from", column)
+	}
+	if !r.to.IsZero() {
+		where += fmt.Sprintf(" AND CAST(%s AS REAL) < :range_to", column)
+	}
+	i := strings.LastIndex(statement, " ORDER BY")
+	if i < 0 {
+		i = strings.LastIndex(statement, ";")
+	}
+	if i < 0 {
+		return statement + where
+	}
+	return statement[:i] + where + statement[i:]
+}
+
+// rows returns the window of the rows read for r: from the start of the
+// second of from, and a second past to, up to which the samples of the
+// last second are interpolated. rangeEncoder keeps the samples within r.
+func (r timeRange) rows() timeRange {
+	if !r.to.IsZero() {
+		r.to = r.to.Add(time.Second)
+	}
+	r.from = r.from.Truncate(time.Second)
+	return r
+}
+
+// params adds the bounds of the rows read for r to the parameters of a
+// statement, in seconds since the epoch of the database.
+func (r timeRange) params(params map[string]interface{}, epoch int64) map[string]interface{} {
+	seconds := func(t time.Time) float64 {
+		return float64(t.Unix()-epoch) + float64(t.Nanosecond())/1e9
+	}
+	rows := r.rows()
+	params["range_from"] = seconds(rows.from)
+	params["range_to"] = seconds(rows.to)
+	return params
+}
+
+// rangeEncoder passes on the samples within a window, by their detailed
+// times.
+type rangeEncoder struct {
+	encoder
+	window timeRange
+}
+
+func (e *rangeEncoder) Encode(s *signal, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	kept := reflect.MakeSlice(rv.Type(), 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		row := rv.Index(i)
+		if e.window.contains(row.FieldByName("Detailed").Interface().(time.Time)) {
+			kept = reflect.Append(kept, row)
+		}
+	}
+	if kept.Len() == 0 {
+		return nil
+	}
+	p := reflect.New(kept.Type())
+	p.Elem().Set(kept)
+	return e.encoder.Encode(s, p.Interface())
+}
+
+// rangeSource reads the samples of a source within a window, for the
+// sources other than vital databases, read into memory.
+type rangeSource struct {
+	source
+	window timeRange
+}
+
+func (s *rangeSource) query(t int) (rowScanner, error) {
+	rows, err := s.source.query(t)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeRows{rowScanner: rows, window: s.window.rows()}, nil
+}
+
+// rangeRows skips the rows outside a window.
+type rangeRows struct {
+	rowScanner
+	window timeRange
+	row    vitalRow
+	err    error
+}
+
+func (r *rangeRows) Next() bool {
+	for r.rowScanner.Next() {
+		if r.err = r.rowScanner.StructScan(&r.row); r.err != nil {
+			return true
+		}
+		if r.window.contains(time.Unix(r.row.Ztime, r.row.Nanos)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rangeRows) StructScan(dest interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.row.scan(dest)
+	return nil
+}
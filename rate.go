@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// secondCount is the number of samples of a signal written in a second.
+type secondCount struct {
+	ztime int64
+	n     int
+}
+
+// rateEncoder counts the samples passed to the encoder of a signal by
+// the second of their ztime, from which the sample rate is detected.
+type rateEncoder struct {
+	encoder
+	counts *[]secondCount
+}
+
+func (e *rateEncoder) Encode(s *signal, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	for i := 0; i < rv.Len(); i++ {
+		ztime := rv.Index(i).FieldByName("Ztime").Int()
+		if n := len(*e.counts); n > 0 && (*e.counts)[n-1].ztime == ztime {
+			(*e.counts)[n-1].n++
+			continue
+		}
+		*e.counts = append(*e.counts, secondCount{ztime, 1})
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// sampleRate is the sample rate of a signal detected from the samples
+// written: the mean count of samples per second of ztime, and its drift,
+// the change of the rate from the first half of the recording to the
+// second in parts per million. The first and last seconds, which may be
+// cut, are left out, and so are seconds without samples (gaps).
+// Sparse signals have the rate of their samples over the seconds they
+// span.
+type sampleRate struct {
+	hz      float64
+	drift   float64
+	seconds int64 // seconds over which the rate is measured
+}
+
+// detectRate returns the sample rate of s from its samples per second,
+// and false if there are too few to tell.
+func detectRate(s *signal, counts []secondCount) (sampleRate, bool) {
+	if s.sparse {
+		if len(counts) < 2 {
+			return sampleRate{}, false
+		}
+		n := 0
+		for _, c := range counts[:len(counts)-1] {
+			n += c.n
+		}
+		span := counts[len(counts)-1].ztime - counts[0].ztime
+		return sampleRate{hz: float64(n) / float64(span), seconds: span}, true
+	}
+
+	if len(counts) > 2 {
+		counts = counts[1 : len(counts)-1]
+	}
+	// Rates below 1 Hz and of signals spread over several seconds are
+	// only measured over whole spans.
+	if s.span > 1 {
+		counts = counts[:len(counts)/s.span*s.span]
+	}
+	if len(counts) == 0 {
+		return sampleRate{}, false
+	}
+	mean := func(cs []secondCount) float64 {
+		n := 0
+		for _, c := range cs {
+			n += c.n
+		}
+		return float64(n) / float64(len(cs))
+	}
+	r := sampleRate{hz: mean(counts), seconds: int64(len(counts))}
+	if half := len(counts) / 2; half > 0 {
+		r.drift = (mean(counts[half:]) - mean(counts[:half])) / r.hz * 1e6
+	}
+	return r, true
+}
+
+func (r sampleRate) String() string {
+	return fmt.Sprintf("%s Hz over %s, drift %+.0f ppm", r.format(), time.Duration(r.seconds)*time.Second, r.drift)
+}
+
+// format returns the rate in Hz to the mHz.
+func (r sampleRate) format() string {
+	return strconv.FormatFloat(math.Round(r.hz*1000)/1000, 'f', -1, 64)
+}
+
+// detectRates returns the sample rate detected of every signal written
+// by signal type, logging them with -verbose.
+func detectRates(opts *Options) map[int]sampleRate {
+	rates := make(map[int]sampleRate)
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		r, ok := detectRate(s, *opts.Rates[t])
+		if !ok {
+			continue
+		}
+		rates[t] = r
+		if opts.Verbose {
+			log.Printf("%s: sample rate %v", s.label, r)
+		}
+	}
+	return rates
+}
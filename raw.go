@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+const (
+	RAW_FILE_SUFFIX = ".raw.json"
+	RAW_UNIT        = "count"
+)
+
+// rawCalibration is the calibration of a signal written by -raw, with
+// which its counts are converted back to its unit.
+type rawCalibration struct {
+	Unit       string  `json:"unit"`
+	Gain       float64 `json:"gain"`
+	Offset     float64 `json:"offset"`
+	Resolution int     `json:"resolution,omitempty"` // bits of the ADC
+}
+
+// hasRaw reports whether any signal of opts is written in counts.
+func hasRaw(opts *Options) bool {
+	for _, t := range opts.Signals {
+		if signalTypes[t].raw {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRawCalibration writes the calibration of the signals exported in
+// counts to <name>.raw.json, by signal name.
+func writeRawCalibration(opts *Options) error {
+	doc := struct {
+		Formula string                    `json:"formula"`
+		Signals map[string]rawCalibration `json:"signals"`
+	}{
+		Formula: "value = offset + gain * count",
+		Signals: make(map[string]rawCalibration),
+	}
+	for _, t := range opts.Signals {
+		s := signalTypes[t]
+		if !s.raw {
+			continue
+		}
+		doc.Signals[s.name] = rawCalibration{Unit: s.unit, Gain: s.gain, Offset: s.offset, Resolution: s.bits}
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+RAW_FILE_SUFFIX), append(b, '\n'))
+}
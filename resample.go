@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods of -resample-method: "linear" interpolates between the two
+// samples around a point of the grid, "cubic" fits a Catmull-Rom spline
+// through the four around it.
+var resampleMethods = []string{"linear", "cubic"}
+
+// parseSignalValues parses the value of option for all signals, or comma
+// separated name:value pairs such as "ecg:250,accel:50", of which valid
+// reports whether a value is. The value of all signals has the name "".
+func parseSignalValues(option, spec string, valid func(float64) bool) (map[string]float64, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	values := make(map[string]float64)
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			name, value = "", name
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || !valid(v) {
+			return nil, fmt.Errorf("Invalid -%s %q, give the value or name:value", option, pair)
+		}
+		if _, known := signalNamed(name); name != "" && !known {
+			return nil, fmt.Errorf("Unknown signal of -%s: %s", option, name)
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+// signalValue returns the value of s in values parsed by
+// parseSignalValues, 0 if none. Sparse signals keep the time of their
+// samples, and have none.
+func signalValue(s *signal, values map[string]float64) float64 {
+	if s.sparse {
+		return 0
+	}
+	if v, ok := values[s.name]; ok {
+		return v
+	}
+	return values[""]
+}
+
+// resamplePoint is a sample of a signal, with its values by channel.
+type resamplePoint struct {
+	t           time.Time
+	ztime, zfok int64
+	vs          []float64
+}
+
+// resampleEncoder interpolates the samples of a signal onto a grid of the
+// sample rate, whose points are at whole fractions of the seconds of ztime
+// so that every second has the same number of samples. Samples more than
+// a second of ztime apart are a gap, which is not interpolated over: the
+// grid starts again after it, and has no points in seconds without
+// samples.
+//
+// The points are passed on as rows of the type of the samples, with the
+// z_fok_timestamp and quality of the sample at or before them. flush
+// writes those held back for the samples that follow.
+type resampleEncoder struct {
+	encoder
+	rate  float64
+	cubic bool
+	rows  interface{} // of the row type of the signal
+	buf   []resamplePoint
+	base  time.Time // second the grid is counted from
+	k     int64     // index of the next point of the grid
+	out   []resamplePoint
+}
+
+func newResampleEncoder(enc encoder, rate float64, method string) *resampleEncoder {
+	return &resampleEncoder{encoder: enc, rate: rate, cubic: method == "cubic"}
+}
+
+func (e *resampleEncoder) Encode(s *signal, v interface{}) error {
+	e.rows = v
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		p := resamplePoint{detailed, ztime, zfok, append([]float64(nil), vs...)}
+		if n := len(e.buf); n > 0 && p.ztime-e.buf[n-1].ztime > 1 {
+			if err := e.interpolate(s, true); err != nil {
+				return err
+			}
+			e.buf = e.buf[:0]
+		}
+		if len(e.buf) == 0 {
+			e.base = p.t.Truncate(time.Second)
+			e.k = int64(math.Ceil(float64(p.t.Sub(e.base)) * e.rate / float64(time.Second)))
+		}
+		e.buf = append(e.buf, p)
+		return e.interpolate(s, false)
+	})
+}
+
+// point returns the time of point k of the grid.
+func (e *resampleEncoder) point(k int64) time.Time {
+	return e.base.Add(time.Duration(float64(k) * float64(time.Second) / e.rate))
+}
+
+// interpolate writes the points of the grid up to the last sample held,
+// or up to the one before it unless final, which the spline needs.
+func (e *resampleEncoder) interpolate(s *signal, final bool) error {
+	n := len(e.buf)
+	last := n - 1
+	if !final && e.cubic {
+		last--
+	}
+	i := 0
+	for {
+		t := e.point(e.k)
+		for i+1 < n && !e.buf[i+1].t.After(t) {
+			i++
+		}
+		if i >= last && !(final && i == n-1 && e.buf[i].t.Equal(t)) {
+			break
+		}
+		// Samples spread over the seconds after them, up to a gap, do not
+		// fill those.
+		if sec := t.Unix(); sec != e.buf[i].ztime && (i+1 == n || sec != e.buf[i+1].ztime) {
+			e.k++
+			continue
+		}
+		e.out = append(e.out, e.at(i, t))
+		e.k++
+		if err := writeSeconds(e.encoder, s, e.rows, &e.out, false); err != nil {
+			return err
+		}
+	}
+	// Keep the samples around the next point.
+	if i > 0 {
+		e.buf = append(e.buf[:0], e.buf[i-1:]...)
+	}
+	return nil
+}
+
+// at returns the point of the grid at t, which is from sample i on.
+func (e *resampleEncoder) at(i int, t time.Time) resamplePoint {
+	p1 := e.buf[i]
+	p := resamplePoint{t: t, ztime: t.Unix(), zfok: p1.zfok, vs: make([]float64, len(p1.vs))}
+	if i+1 == len(e.buf) {
+		copy(p.vs, p1.vs)
+		return p
+	}
+	p2 := e.buf[i+1]
+	u := float64(t.Sub(p1.t)) / float64(p2.t.Sub(p1.t))
+	p0, p3 := p1, p2
+	if i > 0 {
+		p0 = e.buf[i-1]
+	}
+	if i+2 < len(e.buf) {
+		p3 = e.buf[i+2]
+	}
+	for c := range p.vs {
+		v1, v2 := p1.vs[c], p2.vs[c]
+		if !e.cubic {
+			p.vs[c] = v1 + (v2-v1)*u
+			continue
+		}
+		v0, v3 := p0.vs[c], p3.vs[c]
+		p.vs[c] = 0.5 * (2*v1 + (v2-v0)*u + (2*v0-5*v1+4*v2-v3)*u*u + (3*v1-v0-3*v2+v3)*u*u*u)
+	}
+	// The quality of SpO2 is that of the sample before.
+	if len(p.vs) == 2 {
+		p.vs[1] = p1.vs[1]
+	}
+	return p
+}
+
+// writeSeconds passes the points of out on to enc one second per call,
+// as rows of the type of rows, and removes them. The points of the last
+// second are only passed on if final, as more may follow.
+func writeSeconds(enc encoder, s *signal, rows interface{}, out *[]resamplePoint, final bool) error {
+	ps := *out
+	n := len(ps)
+	if n == 0 || !final && ps[0].ztime == ps[n-1].ztime {
+		return nil
+	}
+	end := n
+	if !final {
+		end = 1
+		for ps[end].ztime == ps[0].ztime {
+			end++
+		}
+	}
+	err := enc.Encode(s, resampledRows(s, rows, ps[:end]))
+	*out = append(ps[:0], ps[end:]...)
+	return err
+}
+
+// flush writes the points of the grid up to the last sample.
+func (e *resampleEncoder) flush(s *signal) error {
+	if len(e.buf) > 0 {
+		if err := e.interpolate(s, true); err != nil {
+			return err
+		}
+	}
+	return writeSeconds(e.encoder, s, e.rows, &e.out, true)
+}
+
+// resampledRows returns the points as rows of the type of rows.
+func resampledRows(s *signal, rows interface{}, ps []resamplePoint) interface{} {
+	value := s.roundValue
+	row := func(p resamplePoint) (string, int64, string) {
+		return timeLayout.formatTime(time.Unix(p.ztime, 0)), p.ztime, timeLayout.formatDetailed(p.t)
+	}
+	switch rows.(type) {
+	case *[]Accel:
+		rs := make([]Accel, len(ps))
+		for i, p := range ps {
+			tm, ztime, detailed := row(p)
+			rs[i] = Accel{
+				OriginalTimestamp: tm, Ztime: ztime, ZFokTimestamp: p.zfok,
+				X: axisValue(value(p.vs[0])), Y: axisValue(value(p.vs[1])), Z: axisValue(value(p.vs[2])),
+				DetailedTimestamp: detailed, Detailed: p.t,
+			}
+		}
+		return &rs
+	case *[]Spo2:
+		rs := make([]Spo2, len(ps))
+		for i, p := range ps {
+			tm, ztime, detailed := row(p)
+			rs[i] = Spo2{
+				OriginalTimestamp: tm, Ztime: ztime, ZFokTimestamp: p.zfok, Zvalue: value(p.vs[0]),
+				DetailedTimestamp: detailed, Detailed: p.t,
+			}
+			if q := p.vs[1]; !math.IsNaN(q) {
+				rs[i].Quality = &q
+			}
+		}
+		return &rs
+	}
+	rs := make([]Ecg, len(ps))
+	for i, p := range ps {
+		tm, ztime, detailed := row(p)
+		rs[i] = Ecg{
+			OriginalTimestamp: tm, Ztime: ztime, ZFokTimestamp: p.zfok, Zvalue: value(p.vs[0]),
+			DetailedTimestamp: detailed, Detailed: p.t,
+		}
+	}
+	return &rs
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const SALVAGE_CHUNK = 4096 // rows read at once
+
+// salvage reads the rows of ztype from a damaged database. The rows are
+// read in ranges of primary keys; a range that cannot be read is split
+// in halves until the unreadable rows are found, which are skipped and
+// logged. As the rows are not read in order, they are sorted in memory.
+func salvage(db *sqlx.DB, schema *vitalSchema, ztype int, epoch int64, window timeRange) (*sliceRows, error) {
+	var last int64
+	if err := db.Get(&last, schema.lastKey); err != nil {
+		return nil, err
+	}
+	statement := schema.salvage
+	if window.bounded() {
+		statement = window.statement(statement, schema.timeColumn)
+	}
+	stmt, err := db.PrepareNamed(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var (
+		rows    []vitalRow
+		skipped [][2]int64
+		read    func(from, to int64)
+	)
+	read = func(from, to int64) {
+		var rs []vitalRecord
+		params := map[string]interface{}{"ztype": ztype, "from": from, "to": to}
+		err := stmt.Select(&rs, window.params(params, epoch))
+		if err == nil {
+			for _, r := range rs {
+				rows = append(rows, r.row(epoch))
+			}
+			return
+		}
+		if from == to {
+			if l := len(skipped); l > 0 && skipped[l-1][1] == from-1 {
+				skipped[l-1][1] = to
+			} else {
+				skipped = append(skipped, [2]int64{from, to})
+			}
+			return
+		}
+		mid := from + (to-from)/2
+		read(from, mid)
+		read(mid+1, to)
+	}
+	for from := int64(1); from <= last; from += SALVAGE_CHUNK {
+		read(from, min(from+SALVAGE_CHUNK-1, last))
+	}
+	for _, s := range skipped {
+		log.Printf("Salvage: skipped unreadable rows %d-%d (ztype %d)", s[0], s[1], ztype)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].before(&rows[j]) })
+	return &sliceRows{rows: rows}, nil
+}
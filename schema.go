@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// vitalSchema is a database layout written by a version of the app.
+// columns lists the tables and columns the statement reads, ztypes maps
+// the signal types to the ztype codes of that version, and distinct
+// returns the ztype codes present (-all). counts and times are read by
+// validate: the rows per ztype code, and the logged times in the order
+// they were written. span returns the first and the last logged time.
+// events reads the event markers and beats the beats detected by the
+// device, "" if the version has no such table. devices lists the devices
+// of a database synced from several, and byDevice reads the rows of a
+// ztype recorded by :device (-by-device); both are "" if the version
+// does not record the device. salvage reads the rows of a ztype with
+// primary keys :from to :to, lastKey returns the largest primary key.
+// timeZone reads the time zone of the device, and syncs the clock syncs
+// of the device with the phone, "" if the version does not record them.
+// timeColumn is the time column of the statements, by which -from and
+// -to select the rows.
+type vitalSchema struct {
+	name       string
+	columns    map[string][]string
+	statement  string
+	timeColumn string
+	ztypes     map[int]int
+	distinct   string
+	events     string
+	beats      string
+	timeZone   string
+	syncs      string
+	devices    string
+	byDevice   string
+	counts     string
+	times      string
+	span       string
+	salvage    string
+	lastKey    string
+}
+
+// vitalSchemas are tried in order; the first one whose tables and
+// columns are all present is used. Only the layout of ZLOGGEDDATA and
+// ZLOGGEDTIME is known: the columns and ztype codes of the newer
+// versions of the app are not, and their databases are only read with a
+// -schema file naming them.
+var vitalSchemas = []vitalSchema{
+	{
+		name: "ZLOGGEDDATA/ZLOGGEDTIME",
+		columns: map[string][]string{
+			"ZLOGGEDDATA": {"ZTYPE", "ZTIMESTAMP", "Z_FOK_TIMESTAMP", "ZVALUE"},
+			"ZLOGGEDTIME": {"Z_PK", "ZTIME"},
+		},
+		statement:  SQL_STATEMENT,
+		timeColumn: "t.ztime",
+		ztypes:     map[int]int{ECG_TYPE: ECG_TYPE, ACCEL_TYPE: ACCEL_TYPE},
+		distinct:   `SELECT DISTINCT ztype FROM ZLOGGEDDATA ORDER BY ztype`,
+		counts:     `SELECT ztype, count(*) FROM ZLOGGEDDATA GROUP BY ztype`,
+		times:      `SELECT CAST(ztime AS REAL) FROM ZLOGGEDTIME ORDER BY Z_PK`,
+		span:       `SELECT min(CAST(ztime AS REAL)), max(CAST(ztime AS REAL)) FROM ZLOGGEDTIME`,
+		salvage:    SQL_SALVAGE_STATEMENT,
+		lastKey:    `SELECT max(Z_PK) FROM ZLOGGEDDATA`,
+	},
+}
+
+// tableColumns returns the columns of every table of db, upper-cased as
+// SQLite compares names case-insensitively.
+func tableColumns(db *sqlx.DB) (map[string]map[string]bool, error) {
+	var tables []string
+	if err := db.Select(&tables, `SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`); err != nil {
+		return nil, err
+	}
+	found := make(map[string]map[string]bool, len(tables))
+	for _, table := range tables {
+		var columns []string
+		if err := db.Select(&columns, `SELECT name FROM pragma_table_info(?)`, table); err != nil {
+			return nil, err
+		}
+		cs := make(map[string]bool, len(columns))
+		for _, c := range columns {
+			cs[strings.ToUpper(c)] = true
+		}
+		found[strings.ToUpper(table)] = cs
+	}
+	return found, nil
+}
+
+// probeSchema returns the schema of db, or an error listing the tables
+// and columns found if none of vitalSchemas matches.
+func probeSchema(db *sqlx.DB) (*vitalSchema, error) {
+	found, err := tableColumns(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vitalSchemas {
+		if vitalSchemas[i].matches(found) {
+			return &vitalSchemas[i], nil
+		}
+	}
+
+	tables := make([]string, 0, len(found))
+	for table, cs := range found {
+		columns := make([]string, 0, len(cs))
+		for c := range cs {
+			columns = append(columns, c)
+		}
+		sort.Strings(columns)
+		tables = append(tables, table+"("+strings.Join(columns, ", ")+")")
+	}
+	sort.Strings(tables)
+	if len(tables) == 0 {
+		tables = []string{"no tables"}
+	}
+	return nil, fmt.Errorf("No known schema matches, found %s (name the tables and columns of the layout with -schema)", strings.Join(tables, ", "))
+}
+
+func (s *vitalSchema) matches(found map[string]map[string]bool) bool {
+	for table, columns := range s.columns {
+		cs, ok := found[table]
+		if !ok {
+			return false
+		}
+		for _, c := range columns {
+			if !cs[c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Epochs of the time column, in Unix seconds. Core Data stores dates as
+// seconds since 2001-01-01 UTC.
+var timeEpochs = map[string]int64{
+	"coredata": 978307200,
+	"unix":     0,
+}
+
+// timeEpoch returns the Unix time of the epoch of the times in db, named
+// by -time-epoch. "auto" takes times before the Unix time of the Core
+// Data epoch as Core Data times, which holds for recordings made before
+// 2032.
+func timeEpoch(db *sqlx.DB, schema *vitalSchema, name string) (int64, error) {
+	if name != "auto" {
+		return timeEpochs[name], nil
+	}
+	var first sql.NullFloat64
+	if err := db.Get(&first, schema.times); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if first.Float64 >= float64(timeEpochs["coredata"]) {
+		return timeEpochs["unix"], nil
+	}
+	return timeEpochs["coredata"], nil
+}
+
+// schemaMapping names the tables and columns of a database variant, read
+// from the JSON file given by -schema. The data table holds the samples,
+// each referring to a row of the time table by the join key.
+type schemaMapping struct {
+	Name      string `json:"name"`
+	DataTable string `json:"data_table"`
+	TimeTable string `json:"time_table"`
+	DataKey   string `json:"data_key"` // primary key of the data table
+	JoinKey   string `json:"join_key"` // column of the data table referring to the time table
+	TimeKey   string `json:"time_key"` // primary key of the time table
+	Time      string `json:"time"`
+	Type      string `json:"type"`
+	Order     string `json:"order"` // order of the samples within a time
+	Value     string `json:"value"`
+	Device    string `json:"device"` // column of the data table referring to the device, "" if none
+	ECG       int    `json:"ecg"`    // ztype codes
+	Accel     int    `json:"accel"`
+	HR        int    `json:"hr"` // 0 if not recorded
+	SpO2      int    `json:"spo2"`
+	SpO2Q     int    `json:"spo2_quality"`
+	Resp      int    `json:"resp"`
+	Temp      int    `json:"temp"`
+	Gyro      int    `json:"gyro"`
+	Mag       int    `json:"mag"`
+	// The table of event markers, "" if none, and its time and label
+	// columns. The times are in the epoch of the time table.
+	EventTable string `json:"event_table"`
+	EventTime  string `json:"event_time"`
+	EventLabel string `json:"event_label"`
+	// The table of beats detected by the device, "" if none, its time
+	// column and, if recorded, its RR interval (ms) and beat type columns.
+	BeatTable    string `json:"beat_table"`
+	BeatTime     string `json:"beat_time"`
+	BeatInterval string `json:"beat_interval"`
+	BeatType     string `json:"beat_type"`
+	// The table recording the time zone of the device, "" if none, and
+	// its column: a time zone name, such as Europe/Berlin, or the offset
+	// from UTC in seconds.
+	TimeZoneTable string `json:"time_zone_table"`
+	TimeZone      string `json:"time_zone"`
+	// The table of the clock syncs of the device with the phone, "" if
+	// none, its time column, in the epoch of the time table, and the
+	// offset of the clock of the phone from the times recorded then, in
+	// milliseconds.
+	SyncTable  string `json:"sync_table"`
+	SyncTime   string `json:"sync_time"`
+	SyncOffset string `json:"sync_offset"`
+}
+
+// defaultMapping is the layout of the built-in schema; a -schema file
+// only needs to give what differs.
+var defaultMapping = schemaMapping{
+	Name:      "custom",
+	DataTable: "ZLOGGEDDATA",
+	TimeTable: "ZLOGGEDTIME",
+	DataKey:   "Z_PK",
+	JoinKey:   "ZTIMESTAMP",
+	TimeKey:   "Z_PK",
+	Time:      "ZTIME",
+	Type:      "ZTYPE",
+	Order:     "Z_FOK_TIMESTAMP",
+	Value:     "ZVALUE",
+	ECG:       ECG_TYPE,
+	Accel:     ACCEL_TYPE,
+}
+
+// ztypes maps the signal types to the ztype codes of the mapping.
+func (m schemaMapping) ztypes() map[int]int {
+	ztypes := map[int]int{ECG_TYPE: m.ECG, ACCEL_TYPE: m.Accel}
+	for t, code := range map[int]int{HR_TYPE: m.HR, SPO2_TYPE: m.SpO2, SPO2_QUALITY_TYPE: m.SpO2Q, RESP_TYPE: m.Resp, TEMP_TYPE: m.Temp, GYRO_TYPE: m.Gyro, MAG_TYPE: m.Mag} {
+		if code != 0 {
+			ztypes[t] = code
+		}
+	}
+	return ztypes
+}
+
+// hasZtype reports whether a schema knows the ztype code of signal type t.
+func hasZtype(t int) bool {
+	for _, s := range vitalSchemas {
+		if _, ok := s.ztypes[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDevices reports whether a schema knows the device of the rows.
+func hasDevices() bool {
+	for _, s := range vitalSchemas {
+		if s.devices != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadSchema reads a -schema file.
+func loadSchema(path string) (vitalSchema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return vitalSchema{}, err
+	}
+	m := defaultMapping
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return vitalSchema{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if m.EventTable != "" && (m.EventTime == "" || m.EventLabel == "") {
+		return vitalSchema{}, fmt.Errorf("%s: event_table requires event_time and event_label", path)
+	}
+	if m.BeatTable != "" && m.BeatTime == "" {
+		return vitalSchema{}, fmt.Errorf("%s: beat_table requires beat_time", path)
+	}
+	if m.TimeZoneTable != "" && m.TimeZone == "" {
+		return vitalSchema{}, fmt.Errorf("%s: time_zone_table requires time_zone", path)
+	}
+	if m.SyncTable != "" && (m.SyncTime == "" || m.SyncOffset == "") {
+		return vitalSchema{}, fmt.Errorf("%s: sync_table requires sync_time and sync_offset", path)
+	}
+	return m.schema(), nil
+}
+
+// schema returns the statements reading the mapped tables, which are the
+// built-in statements with the names replaced.
+func (m schemaMapping) schema() vitalSchema {
+	q := func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	from := fmt.Sprintf(`
+SELECT
+  CAST(t.%s AS REAL) AS ztime,
+  d.%s AS zfok_timestamp,
+  d.%s AS value
+FROM
+  %s d INNER JOIN %s t ON d.%s = t.%s
+WHERE
+  d.%s = :ztype`,
+		q(m.Time), q(m.Order), q(m.Value),
+		q(m.DataTable), q(m.TimeTable), q(m.JoinKey), q(m.TimeKey), q(m.Type))
+
+	var events string
+	if m.EventTable != "" {
+		events = fmt.Sprintf(`SELECT CAST(%s AS REAL) AS ztime, %s AS label FROM %s ORDER BY ztime`,
+			q(m.EventTime), q(m.EventLabel), q(m.EventTable))
+	}
+	columns := []string{
+		strings.ToUpper(m.DataKey), strings.ToUpper(m.JoinKey), strings.ToUpper(m.Type),
+		strings.ToUpper(m.Order), strings.ToUpper(m.Value),
+	}
+	var devices, byDevice string
+	if m.Device != "" {
+		columns = append(columns, strings.ToUpper(m.Device))
+		devices = fmt.Sprintf(`SELECT DISTINCT CAST(%[1]s AS TEXT) FROM %[2]s WHERE %[1]s IS NOT NULL ORDER BY 1`,
+			q(m.Device), q(m.DataTable))
+		byDevice = from + fmt.Sprintf(" AND CAST(d.%s AS TEXT) = :device ORDER BY ztime ASC, d.%s ASC;", q(m.Device), q(m.DataKey))
+	}
+	var beats string
+	if m.BeatTable != "" {
+		column := func(name string) string {
+			if name == "" {
+				return "NULL"
+			}
+			return q(name)
+		}
+		beats = fmt.Sprintf(`SELECT CAST(%s AS REAL) AS ztime, %s AS interval, %s AS type FROM %s ORDER BY ztime`,
+			q(m.BeatTime), column(m.BeatInterval), column(m.BeatType), q(m.BeatTable))
+	}
+	var timeZone string
+	if m.TimeZoneTable != "" {
+		timeZone = fmt.Sprintf(`SELECT CAST(%[1]s AS TEXT) FROM %[2]s WHERE %[1]s IS NOT NULL LIMIT 1`,
+			q(m.TimeZone), q(m.TimeZoneTable))
+	}
+	var syncs string
+	if m.SyncTable != "" {
+		syncs = fmt.Sprintf(`SELECT CAST(%[1]s AS REAL) AS ztime, CAST(%[2]s AS REAL) AS offset FROM %[3]s WHERE %[2]s IS NOT NULL ORDER BY ztime`,
+			q(m.SyncTime), q(m.SyncOffset), q(m.SyncTable))
+	}
+
+	return vitalSchema{
+		name: m.Name,
+		columns: map[string][]string{
+			strings.ToUpper(m.DataTable): columns,
+			strings.ToUpper(m.TimeTable): {strings.ToUpper(m.TimeKey), strings.ToUpper(m.Time)},
+		},
+		statement:  from + fmt.Sprintf(" ORDER BY ztime ASC, d.%s ASC;", q(m.DataKey)),
+		timeColumn: "t." + q(m.Time),
+		ztypes:     m.ztypes(),
+		events:     events,
+		beats:      beats,
+		timeZone:   timeZone,
+		syncs:      syncs,
+		devices:    devices,
+		byDevice:   byDevice,
+		distinct:   fmt.Sprintf(`SELECT DISTINCT %s FROM %s ORDER BY %s`, q(m.Type), q(m.DataTable), q(m.Type)),
+		counts:     fmt.Sprintf(`SELECT %s, count(*) FROM %s GROUP BY %s`, q(m.Type), q(m.DataTable), q(m.Type)),
+		times:      fmt.Sprintf(`SELECT CAST(%s AS REAL) FROM %s ORDER BY %s`, q(m.Time), q(m.TimeTable), q(m.TimeKey)),
+		span:       fmt.Sprintf(`SELECT min(CAST(%[1]s AS REAL)), max(CAST(%[1]s AS REAL)) FROM %[2]s`, q(m.Time), q(m.TimeTable)),
+		salvage:    from + fmt.Sprintf(" AND d.%s BETWEEN :from AND :to;", q(m.DataKey)),
+		lastKey:    fmt.Sprintf(`SELECT max(%s) FROM %s`, q(m.DataKey), q(m.DataTable)),
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	SESSION_FILE_SUFFIX = ".metadata"
+	SESSION_JSON_EXT    = ".json"
+	SESSION_CSV_EXT     = ".csv"
+)
+
+// sessionFormats are the formats of -metadata.
+var sessionFormats = map[string]bool{"json": true, "csv": true}
+
+// sessionTable is a table of a vital database other than those holding
+// the samples, such as those of the device, subject and recording
+// session, or the Core Data bookkeeping of the app.
+type sessionTable struct {
+	name    string
+	columns []string
+	rows    [][]interface{}
+}
+
+// readSessionTables reads all rows of the tables the schema does not
+// read samples from. Values are read as stored: the columns are selected
+// as expressions, which the driver does not convert by their declared
+// type (Core Data TIMESTAMPs are not Unix times). Text is returned as
+// strings and other blobs as bytes.
+func readSessionTables(db *sqlx.DB, schema *vitalSchema) ([]sessionTable, error) {
+	var names []string
+	err := db.Select(&names, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+
+	q := func(name string) string {
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	var tables []sessionTable
+	for _, name := range names {
+		if _, ok := schema.columns[strings.ToUpper(name)]; ok {
+			continue
+		}
+		t := sessionTable{name: name}
+		if err := db.Select(&t.columns, `SELECT name FROM pragma_table_info(?) ORDER BY cid`, name); err != nil {
+			return nil, err
+		}
+		exprs := make([]string, len(t.columns))
+		for i, c := range t.columns {
+			exprs[i] = fmt.Sprintf("coalesce(%s, NULL) AS %s", q(c), q(c))
+		}
+		rows, err := db.Queryx(`SELECT ` + strings.Join(exprs, ", ") + ` FROM ` + q(name))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			row, err := rows.SliceScan()
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			for i, v := range row {
+				if b, ok := v.([]byte); ok && utf8.Valid(b) {
+					row[i] = string(b)
+				}
+			}
+			t.rows = append(t.rows, row)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	return tables, nil
+}
+
+// writeSessionTables writes the session tables of the vital database
+// next to the outputs: one JSON file holding every table by name, with a
+// column:value object per row, or a csv file per table. Of the databases
+// of one recording (-concat), those of the first are written.
+func writeSessionTables(src *vitalSource, opts *Options) error {
+	tables, err := readSessionTables(src.dbs[0], src.schemas[0])
+	if err != nil {
+		return err
+	}
+	base := joinOutput(opts.OutDir, opts.Name+SESSION_FILE_SUFFIX)
+
+	if opts.Metadata == "json" {
+		b, err := json.MarshalIndent(sessionObjects(tables), "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeOutput(base+SESSION_JSON_EXT, append(b, '\n'))
+	}
+
+	for _, t := range tables {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		w.Write(t.columns)
+		for _, row := range t.rows {
+			rec := make([]string, len(row))
+			for i, v := range row {
+				rec[i] = sessionValue(v)
+			}
+			w.Write(rec)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if err := writeOutput(base+"."+t.name+SESSION_CSV_EXT, b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sessionObjects returns the session tables by name, with a
+// column:value object per row, as they are written in JSON.
+func sessionObjects(tables []sessionTable) map[string][]map[string]interface{} {
+	doc := make(map[string][]map[string]interface{}, len(tables))
+	for _, t := range tables {
+		rows := make([]map[string]interface{}, 0, len(t.rows))
+		for _, row := range t.rows {
+			obj := make(map[string]interface{}, len(row))
+			for i, v := range row {
+				obj[t.columns[i]] = v
+			}
+			rows = append(rows, obj)
+		}
+		doc[t.name] = rows
+	}
+	return doc
+}
+
+// sessionValue formats a value of a session table for csv, with blobs in
+// base64 as in JSON.
+func sessionValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// Columns of the session tables holding the subfields of the patient,
+// upper-cased and without the Z of Core Data, in order of preference.
+var (
+	patientCodeColumns      = []string{"PATIENTID", "SUBJECTID", "CODE", "IDENTIFIER", "ID"}
+	patientSexColumns       = []string{"SEX", "GENDER"}
+	patientBirthdateColumns = []string{"BIRTHDATE", "BIRTHDAY", "DATEOFBIRTH", "DOB"}
+	patientNameColumns      = []string{"NAME", "FULLNAME"}
+)
+
+// patient returns the patient of the first row of the first session
+// table of the first database named as one of patients or subjects.
+// Birthdates are dates of the form 2006-01-02 or times since the epoch
+// of the times of the database; sexes other than female and male are
+// unknown, as is what no column gives.
+func (s *vitalSource) patient() (edfPatient, error) {
+	var p edfPatient
+	tables, err := readSessionTables(s.dbs[0], s.schemas[0])
+	if err != nil {
+		return p, err
+	}
+	for _, t := range tables {
+		name := strings.ToUpper(t.name)
+		if !strings.Contains(name, "PATIENT") && !strings.Contains(name, "SUBJECT") || len(t.rows) == 0 {
+			continue
+		}
+		values := make(map[string]interface{}, len(t.columns))
+		for i, c := range t.columns {
+			values[strings.TrimPrefix(strings.ToUpper(c), "Z")] = t.rows[0][i]
+		}
+		value := func(columns []string) interface{} {
+			for _, c := range columns {
+				if v := values[c]; v != nil && sessionValue(v) != "" {
+					return v
+				}
+			}
+			return nil
+		}
+
+		if v := value(patientCodeColumns); v != nil {
+			p.code = sessionValue(v)
+		}
+		if v := value(patientSexColumns); v != nil {
+			switch strings.ToUpper(sessionValue(v)) {
+			case "F", "FEMALE":
+				p.sex = "F"
+			case "M", "MALE":
+				p.sex = "M"
+			}
+		}
+		switch v := value(patientBirthdateColumns).(type) {
+		case string:
+			if d, err := time.Parse("2006-01-02", v[:min(len(v), 10)]); err == nil {
+				p.birthdate = strings.ToUpper(d.Format("02-Jan-2006"))
+			}
+		case float64, int64:
+			sec, _ := strconv.ParseFloat(sessionValue(v), 64)
+			d := time.Unix(int64(sec)+s.epochs[0], 0).UTC()
+			p.birthdate = strings.ToUpper(d.Format("02-Jan-2006"))
+		}
+		if v := value(patientNameColumns); v != nil {
+			p.name = sessionValue(v)
+		} else if v := value([]string{"LASTNAME", "FAMILYNAME"}); v != nil {
+			p.name = sessionValue(v)
+			if v := value([]string{"FIRSTNAME", "GIVENNAME"}); v != nil {
+				p.name += "_" + sessionValue(v)
+			}
+		}
+		return p, nil
+	}
+	return p, nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// signal describes an exported signal. Signals of one channel are read
+// into Ecg rows and those of three (x, y, z) into Accel rows, so encoders
+// handle any signal by its row type and take names and units from here.
+type signal struct {
+	name   string // of the signal in -only, tables and the combined format
+	suffix string // of its output files, before the extension
+	label  string
+	unit   string
+	axes   int // channels per sample, 1 or 3
+	// quality is the type of the signal quality of the samples, 0 if
+	// none. Samples with a quality are read into Spo2 rows.
+	quality int
+	// span is the number of seconds whose samples are spread evenly
+	// together, 1 if 0. At low sample rates the count of samples per
+	// second varies (12, 13, 12, ... at 12.5 Hz), which interpolating
+	// second by second turns into uneven spacing.
+	span int
+	// sparse signals are sampled every few seconds. Their samples keep
+	// their own time instead of being interpolated.
+	sparse bool
+	// rate is the sample rate in Hz, 0 if unknown. If known, samples are
+	// spaced by it from the first of their span instead of spread evenly.
+	rate float64
+	// gain and offset convert the raw ADC counts of the samples to the
+	// unit, value = offset + gain * count, and bits is the resolution of
+	// the ADC. gain is 0 if unknown. Given by -signals, used by -raw.
+	gain, offset float64
+	bits         int
+	// raw is set if the values are written in ADC counts, by -raw or
+	// -ecg-unit raw.
+	raw bool
+	// written is the unit the values are written in if converted from
+	// the unit, by -accel-unit or -ecg-unit, and scale the factor they
+	// are multiplied by.
+	written string
+	scale   float64
+	// calibration calibrates the values of the device converted, set by
+	// -calibration; nil if not calibrated.
+	calibration *calibration
+	// normalize is the mode of -normalize, "" if the values are written
+	// as they are, and normalization that of each channel, found over
+	// the recording converted.
+	normalize     string
+	normalization []normalization
+}
+
+// signalTypes are the exportable signals by type. The types of ECG and
+// Accel are their ztype codes in the built-in schema; signals without a
+// code there have negative types, and their codes are given by -schema.
+var signalTypes = map[int]*signal{
+	ECG_TYPE:   {name: "ecg", suffix: ECG_FILE_SUFFIX, label: "ECG", unit: "mV", axes: 1},
+	ACCEL_TYPE: {name: "accel", suffix: ACCEL_FILE_SUFFIX, label: "Accel", unit: "g", axes: 3},
+	HR_TYPE:    {name: "hr", suffix: HR_FILE_SUFFIX, label: "HR", unit: "bpm", axes: 1},
+	SPO2_TYPE: {
+		name: "spo2", suffix: SPO2_FILE_SUFFIX, label: "SpO2", unit: "%", axes: 1,
+		quality: SPO2_QUALITY_TYPE,
+	},
+	RESP_TYPE: {name: "resp", suffix: RESP_FILE_SUFFIX, label: "Resp", axes: 1, span: RESP_INTERPOLATION_SPAN},
+	TEMP_TYPE: {name: "temp", suffix: TEMP_FILE_SUFFIX, label: "Temp", unit: "°C", axes: 1, sparse: true},
+	GYRO_TYPE: {name: "gyro", suffix: GYRO_FILE_SUFFIX, label: "Gyro", unit: "deg/s", axes: 3},
+	MAG_TYPE:  {name: "mag", suffix: MAG_FILE_SUFFIX, label: "Mag", unit: "µT", axes: 3},
+}
+
+// Seconds over which the respiration samples are interpolated.
+const RESP_INTERPOLATION_SPAN = 8
+
+// signalOrder is the order in which signals are listed and exported.
+var signalOrder = []int{ECG_TYPE, ACCEL_TYPE, HR_TYPE, SPO2_TYPE, RESP_TYPE, TEMP_TYPE, GYRO_TYPE, MAG_TYPE}
+
+// The signals given by -ztype and -signals have types counting down from
+// this one.
+const ZTYPE_SIGNAL_TYPE = -100
+
+// signalName is the form of the names given by -ztype, which are used in
+// file, table and record names.
+var signalName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// addZtypeSignals adds the signals given by -ztype, comma separated
+// code:name pairs such as "12:emg".
+func addZtypeSignals(spec string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		code, name, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		z, err := strconv.Atoi(code)
+		if !ok || err != nil || !signalName.MatchString(name) {
+			return fmt.Errorf("Invalid -ztype %q, give code:name", pair)
+		}
+		if _, err := addZtypeSignal(z, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addZtypeSignal adds a signal of one channel without a unit, and returns
+// its type.
+func addZtypeSignal(code int, name string) (int, error) {
+	return addSignal(code, &signal{name: name, label: name, axes: 1})
+}
+
+// addSignal adds s, written to files suffixed with its name, and returns
+// its type. Its ztype code is added to the schemas.
+func addSignal(code int, s *signal) (int, error) {
+	s.suffix = "." + s.name
+	for _, o := range signalTypes {
+		if o.name == s.name || o.suffix == s.suffix {
+			return 0, fmt.Errorf("Signal already defined: %s", s.name)
+		}
+	}
+	t := ZTYPE_SIGNAL_TYPE
+	for signalTypes[t] != nil {
+		t--
+	}
+	signalTypes[t] = s
+	signalOrder = append(signalOrder, t)
+	mapZtype(t, code)
+	return t, nil
+}
+
+// mapZtype sets the ztype code of signal type t in the schemas.
+func mapZtype(t, code int) {
+	for _, s := range vitalSchemas {
+		s.ztypes[t] = code
+	}
+}
+
+// signalMapping is an entry of a -signals file, the signal recorded with
+// a ztype code. Axes is 1 or 3 (x, y, z); signals added have 1 if not
+// given.
+type signalMapping struct {
+	Name       string  `yaml:"name"`
+	Axes       int     `yaml:"axes"`
+	Unit       string  `yaml:"unit"`
+	SampleRate float64 `yaml:"sample_rate"`
+	Gain       float64 `yaml:"gain"` // unit per ADC count
+	Offset     float64 `yaml:"offset"`
+	Resolution int     `yaml:"resolution"` // bits of the ADC
+}
+
+// loadSignals reads a -signals file, YAML or JSON, mapping ztype codes to
+// signals:
+//
+//	12:
+//	  name: emg
+//	  unit: mV
+//	  sample_rate: 1000
+//	  gain: 0.000381
+//	  resolution: 16
+//
+// Entries naming a signal of the tool give its ztype code, and may set
+// its unit, sample rate and calibration; the others add signals.
+func loadSignals(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var ms map[string]signalMapping
+	if err := yaml.UnmarshalStrict(b, &ms); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	codes := make([]int, 0, len(ms))
+	mappings := make(map[int]signalMapping, len(ms))
+	for key, m := range ms {
+		code, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("%s: invalid ztype code %q", path, key)
+		}
+		codes = append(codes, code)
+		mappings[code] = m
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		m := mappings[code]
+		switch {
+		case !signalName.MatchString(m.Name):
+			return fmt.Errorf("%s: invalid name of ztype %d: %q", path, code, m.Name)
+		case m.Axes != 0 && m.Axes != 1 && m.Axes != 3:
+			return fmt.Errorf("%s: axes of %s must be 1 or 3", path, m.Name)
+		case m.SampleRate < 0:
+			return fmt.Errorf("%s: negative sample_rate of %s", path, m.Name)
+		case m.Resolution < 0:
+			return fmt.Errorf("%s: negative resolution of %s", path, m.Name)
+		}
+
+		t, ok := signalNamed(m.Name)
+		if !ok {
+			s := &signal{
+				name: m.Name, label: m.Name, unit: m.Unit, axes: max(m.Axes, 1), rate: m.SampleRate,
+				gain: m.Gain, offset: m.Offset, bits: m.Resolution,
+			}
+			if _, err := addSignal(code, s); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			continue
+		}
+		s := signalTypes[t]
+		if m.Axes != 0 && s.axes != m.Axes {
+			return fmt.Errorf("%s: %s has %d axes", path, m.Name, s.axes)
+		}
+		if m.Unit != "" {
+			s.unit = m.Unit
+		}
+		s.rate = m.SampleRate
+		s.gain, s.offset, s.bits = m.Gain, m.Offset, m.Resolution
+		mapZtype(t, code)
+	}
+	return nil
+}
+
+// discoverSignals sets the signals of opts to those recorded in its
+// input, for -all. Codes no signal is mapped to, by the built-in schema,
+// -schema or -ztype, are exported as signals of one channel named after
+// the code, such as ztype12. Inputs other than vital databases keep their
+// signals.
+func discoverSignals(opts *Options) error {
+	src, err := openSource(opts)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	vs, ok := src.(*vitalSource)
+	if !ok {
+		return nil
+	}
+
+	present := make(map[int]bool)
+	for i, schema := range vs.schemas {
+		codes, err := vs.codes(i)
+		if err != nil {
+			return err
+		}
+		types := make(map[int]int, len(schema.ztypes))
+		for t, code := range schema.ztypes {
+			types[code] = t
+		}
+		for _, code := range codes {
+			t, ok := types[code]
+			if !ok {
+				if t, err = addZtypeSignal(code, "ztype"+strconv.Itoa(code)); err != nil {
+					return err
+				}
+			}
+			present[t] = true
+		}
+	}
+
+	var signals []int
+	for _, t := range signalOrder {
+		if present[t] {
+			signals = append(signals, t)
+		}
+	}
+	if len(signals) == 0 {
+		return fmt.Errorf("No signals recorded")
+	}
+	opts.Signals = signals
+	*opts = opts.named(opts.Vital, opts.Name)
+	return nil
+}
+
+// signalNamed returns the type of the signal named name.
+func signalNamed(name string) (int, bool) {
+	for t, s := range signalTypes {
+		if s.name == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// signalNames returns the names of all signals, in signalOrder.
+func signalNames() []string {
+	names := make([]string, len(signalOrder))
+	for i, t := range signalOrder {
+		names[i] = signalTypes[t].name
+	}
+	return names
+}
+
+// hasQuality reports whether the samples of s have a signal quality,
+// which is so if a schema knows its ztype code.
+func (s *signal) hasQuality() bool {
+	return s.quality != 0 && hasZtype(s.quality)
+}
+
+// channels returns the channels of the samples of s.
+func (s *signal) channels() []channel {
+	if s.axes == 1 {
+		chs := []channel{{name: "value", label: s.label, unit: s.outputUnit()}}
+		if s.hasQuality() {
+			chs = append(chs, channel{name: "quality", label: s.label + " quality", optional: true})
+		}
+		return chs
+	}
+	var chs []channel
+	for _, axis := range []string{"x", "y", "z"} {
+		chs = append(chs, channel{
+			name: axis, label: s.label + " " + strings.ToUpper(axis), unit: s.outputUnit(),
+			optional: incompletePolicy != "drop",
+		})
+	}
+	return chs
+}
+
+// outputUnit returns the unit of the values written: counts with -raw,
+// none with -normalize.
+func (s *signal) outputUnit() string {
+	switch {
+	case s.raw:
+		return RAW_UNIT
+	case s.normalize != "":
+		return ""
+	case s.written != "":
+		return s.written
+	}
+	return s.unit
+}
+
+// value returns sample value v as written: in ADC counts with -raw, and
+// otherwise in the unit written, rounded to -precision.
+func (s *signal) value(v float64) float64 {
+	if s.raw {
+		return math.Round((v - s.offset) / s.gain)
+	}
+	if s.written != "" {
+		v *= s.scale
+	}
+	return round(v)
+}
+
+// roundValue rounds a value computed from the samples as they are: to
+// whole counts with -raw, and otherwise to -precision.
+func (s *signal) roundValue(v float64) float64 {
+	if s.raw {
+		return math.Round(v)
+	}
+	return round(v)
+}
+
+// column returns the name of channel c of s in the combined long format:
+// the signal name for the value, suffixed with the channel otherwise.
+func (s *signal) column(c channel) string {
+	if c.name == "value" {
+		return s.name
+	}
+	return s.name + "_" + c.name
+}
+
+// row returns an empty row of the row type of s.
+func (s *signal) row() interface{} {
+	switch {
+	case s.axes != 1:
+		return Accel{}
+	case s.hasQuality():
+		return Spo2{}
+	}
+	return Ecg{}
+}
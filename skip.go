@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DONE_FILE_SUFFIX names the file a batch run writes next to the outputs
+// of an input once its conversion has succeeded. Outputs left by a
+// conversion that failed partway have none, so the input is converted
+// again.
+const DONE_FILE_SUFFIX = ".done"
+
+// checkable reports whether the outputs of opts are local files that can
+// be checked by upToDate. Outputs in object stores are not, and neither
+// are databases extracted to temporary files, which are always newer
+// than their outputs.
+func checkable(opts *Options) bool {
+	return !opts.Stdout && !isObject(opts.OutDir) && len(opts.Merged) == 0
+}
+
+// donePath returns the path of the file marking the conversion of opts
+// as done.
+func donePath(opts *Options) string {
+	return joinOutput(opts.OutDir, opts.Name+DONE_FILE_SUFFIX)
+}
+
+// upToDate reports whether the last conversion of the input succeeded
+// and all its output files, those of the signals and those written
+// beside them by the options, exist and are not older than it, so a
+// batch run can skip it.
+func upToDate(opts *Options) bool {
+	if !checkable(opts) {
+		return false
+	}
+	in, err := os.Stat(opts.Vital)
+	if err != nil {
+		return false
+	}
+	paths := append([]string{donePath(opts)}, sidecarPaths(opts)...)
+	for _, t := range opts.Signals {
+		path := opts.Outputs[t]
+		if !opts.split() {
+			paths = append(paths, path)
+			continue
+		}
+		name := opts.Name
+		if opts.Format.record {
+			name = wfdbRecordName(name)
+		}
+		segments, err := filepath.Glob(segmentPath(path, name, "*"))
+		if err != nil || len(segments) == 0 {
+			return false
+		}
+		paths = append(paths, segments...)
+	}
+	for _, p := range paths {
+		out, err := os.Stat(p)
+		if err != nil || out.ModTime().Before(in.ModTime()) {
+			return false
+		}
+	}
+	return true
+}
+
+// sidecarPaths returns the files written beside the outputs of the
+// signals by the options of opts. The csv files of the session tables
+// are named by tables that are only known once read, and are not
+// returned.
+func sidecarPaths(opts *Options) []string {
+	vital := !isAppleHealth(opts.Vital) && !isFIT(opts.Vital) && !isPolar(opts.Vital)
+	exported := func(t int) bool {
+		for _, s := range opts.Signals {
+			if s == t {
+				return true
+			}
+		}
+		return false
+	}
+
+	var names []string
+	add := func(ok bool, suffix string) {
+		if ok {
+			names = append(names, opts.Name+suffix)
+		}
+	}
+	add(vital && opts.Metadata == "json", SESSION_FILE_SUFFIX+SESSION_JSON_EXT)
+	add(vital && opts.Events, EVENTS_FILE_SUFFIX)
+	add(hasRaw(opts), RAW_FILE_SUFFIX)
+	add(vital && opts.RR, RR_FILE_SUFFIX)
+	add(opts.Beats && exported(ECG_TYPE), BEATS_FILE_SUFFIX)
+	add(opts.HRV && (vital || opts.Beats && exported(ECG_TYPE)), HRV_FILE_SUFFIX)
+	add(opts.Artifacts, ARTIFACTS_FILE_SUFFIX)
+	add(opts.NonWear > 0, NONWEAR_FILE_SUFFIX)
+	add(opts.PSD > 0 && exported(ECG_TYPE), PSD_FILE_SUFFIX+"."+opts.PSDFormat)
+	add(opts.Steps && exported(ACCEL_TYPE), STEPS_FILE_SUFFIX)
+	add(opts.Posture && exported(ACCEL_TYPE), POSTURE_FILE_SUFFIX)
+	add(opts.SQI, SQI_FILE_SUFFIX)
+	add(opts.Histogram, HISTOGRAM_FILE_SUFFIX)
+	add(opts.Gaps, GAPS_FILE_SUFFIX)
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = joinOutput(opts.OutDir, name)
+	}
+	if opts.DataPackage && len(opts.Signals) > 0 {
+		paths = append(paths, joinOutput(outputDir(opts.Outputs[opts.Signals[0]]), opts.Name+"."+DATAPACKAGE_FILE))
+	}
+	if opts.CSVW && !opts.split() {
+		for _, t := range opts.Signals {
+			paths = append(paths, opts.Outputs[t]+CSVW_METADATA_SUFFIX)
+		}
+	}
+	return paths
+}
+
+// markDone writes the file marking the conversion of opts as done.
+func markDone(opts *Options) error {
+	return writeOutput(donePath(opts), nil)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Columns of the accel smoothed by -smooth.
+const (
+	X_SMOOTH_COLUMN = "x_smooth"
+	Y_SMOOTH_COLUMN = "y_smooth"
+	Z_SMOOTH_COLUMN = "z_smooth"
+)
+
+// SmoothedAccel is an accel row with the moving average of each axis,
+// written by -smooth. An average is empty if the axis has no value in
+// the window.
+type SmoothedAccel struct {
+	Accel
+	XSmooth axisValue `csv:"x_smooth" json:"x_smooth"`
+	YSmooth axisValue `csv:"y_smooth" json:"y_smooth"`
+	ZSmooth axisValue `csv:"z_smooth" json:"z_smooth"`
+}
+
+// smoothEncoder passes the accel rows on with the moving average of each
+// axis over the samples of the window before them, up to and including
+// them. The average is streamed, by running sums of the samples in the
+// window.
+type smoothEncoder struct {
+	encoder
+	window time.Duration
+	held   []Accel // samples in the window, oldest first
+	sum    [3]float64
+	n      [3]int
+}
+
+func (e *smoothEncoder) Header(s *signal, v interface{}) error {
+	return e.encoder.Header(s, &[]SmoothedAccel{})
+}
+
+func (e *smoothEncoder) Encode(s *signal, v interface{}) error {
+	as, ok := v.(*[]Accel)
+	if !ok {
+		return fmt.Errorf("No moving average of rows: %T", v)
+	}
+	rows := make([]SmoothedAccel, len(*as))
+	for i, a := range *as {
+		for len(e.held) > 0 && a.Detailed.Sub(e.held[0].Detailed) >= e.window {
+			e.add(e.held[0], -1)
+			e.held = e.held[1:]
+		}
+		e.held = append(e.held, a)
+		e.add(a, 1)
+		var avg [3]axisValue
+		for j := range avg {
+			avg[j] = axisValue(math.NaN())
+			if e.n[j] > 0 {
+				avg[j] = axisValue(s.roundValue(e.sum[j] / float64(e.n[j])))
+			}
+		}
+		rows[i] = SmoothedAccel{a, avg[0], avg[1], avg[2]}
+	}
+	return e.encoder.Encode(s, &rows)
+}
+
+// add adds the axes of a to the running sums, or removes them if sign is
+// -1.
+func (e *smoothEncoder) add(a Accel, sign int) {
+	for j, v := range []axisValue{a.X, a.Y, a.Z} {
+		if !math.IsNaN(float64(v)) {
+			e.sum[j] += float64(sign) * float64(v)
+			e.n[j] += sign
+		}
+	}
+	if e.n == [3]int{} {
+		// Rounding errors do not outlast the samples.
+		e.sum = [3]float64{}
+	}
+}
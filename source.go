@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// source reads the samples of a recording. query returns the rows of
+// signal type t in (timestamp, zfok_timestamp) order; it is called
+// concurrently for the exported signals.
+type source interface {
+	query(t int) (rowScanner, error)
+	Close() error
+}
+
+// rowScanner is the part of *sqlx.Rows read by queryECG and
+// queryTriplets.
+type rowScanner interface {
+	Next() bool
+	StructScan(dest interface{}) error
+	Err() error
+	Close() error
+}
+
+// vitalRow is a sample read from a source. Ztime is in Unix seconds,
+// Nanos the part of the time below the second.
+type vitalRow struct {
+	Ztime         int64
+	Nanos         int64
+	ZFokTimestamp int64
+	Value         float64
+}
+
+// scan stores r in an Ecg or Accel. Detailed is set to the time of the
+// sample, from which interpolation spreads the samples of its second.
+func (r vitalRow) scan(dest interface{}) {
+	switch d := dest.(type) {
+	case *Ecg:
+		d.Ztime, d.ZFokTimestamp, d.Zvalue = r.Ztime, r.ZFokTimestamp, r.Value
+		d.Detailed = time.Unix(r.Ztime, r.Nanos)
+	case *Accel:
+		d.Ztime, d.ZFokTimestamp, d.Z = r.Ztime, r.ZFokTimestamp, axisValue(r.Value)
+		d.Detailed = time.Unix(r.Ztime, r.Nanos)
+	case *vitalRow:
+		*d = r
+	}
+}
+
+// vitalRecord is a row of the statement of a vitalSchema, with the time
+// as stored in the database: seconds since the epoch of the database,
+// integer or fractional.
+type vitalRecord struct {
+	Time          float64 `db:"ztime"`
+	ZFokTimestamp int64   `db:"zfok_timestamp"`
+	Value         float64 `db:"value"`
+}
+
+// row converts the record, given the Unix time of the epoch.
+func (r vitalRecord) row(epoch int64) vitalRow {
+	sec, frac := math.Modf(r.Time)
+	nanos := int64(math.Round(frac * 1e9))
+	if nanos < 0 {
+		sec, nanos = sec-1, nanos+1e9
+	}
+	return vitalRow{Ztime: epoch + int64(sec), Nanos: nanos, ZFokTimestamp: r.ZFokTimestamp, Value: r.Value}
+}
+
+// vitalRows reads the records of a vital database as vitalRows.
+type vitalRows struct {
+	*sqlx.Rows
+	epoch int64
+}
+
+func (r *vitalRows) StructScan(dest interface{}) error {
+	var rec vitalRecord
+	if err := r.Rows.StructScan(&rec); err != nil {
+		return err
+	}
+	rec.row(r.epoch).scan(dest)
+	return nil
+}
+
+func openSource(opts *Options) (src source, err error) {
+	switch {
+	case isAppleHealth(opts.Vital):
+		src, err = openAppleHealth(opts.Vital)
+	case isFIT(opts.Vital):
+		src, err = openFIT(opts.Vital)
+	case isPolar(opts.Vital):
+		src, err = openPolar(opts.Vital)
+	default:
+		return openVital(opts)
+	}
+	if err != nil || !opts.Range.bounded() {
+		return src, err
+	}
+	return &rangeSource{src, opts.Range}, nil
+}
+
+// vitalSource reads vital databases: one, or several of one recording
+// (-concat) whose rows are merged.
+type vitalSource struct {
+	dbs     []*sqlx.DB
+	schemas []*vitalSchema
+	epochs  []int64
+	stmts   []*sqlx.NamedStmt
+	salvage bool
+	device  string          // whose rows are read (-by-device), "" for all
+	window  timeRange       // of the rows read, by -from and -to
+	dedup   string          // mode of -dedup, "" if the rows are read as they are
+	drift   driftCorrection // of the times read, by -drift-correction
+}
+
+func openVital(opts *Options) (*vitalSource, error) {
+	s := &vitalSource{salvage: opts.Salvage, device: opts.Device, window: opts.Range, dedup: opts.Dedup}
+	for _, vital := range append([]string{opts.Vital}, opts.Merged...) {
+		db, err := sqlx.Connect("sqlite3", inputDSN(vital, opts))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.dbs = append(s.dbs, db)
+
+		schema, err := probeSchema(db)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.schemas = append(s.schemas, schema)
+
+		epoch, err := timeEpoch(db, schema, opts.TimeEpoch)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.epochs = append(s.epochs, epoch)
+
+		statement := schema.statement
+		if s.device != "" {
+			if schema.byDevice == "" {
+				s.Close()
+				return nil, fmt.Errorf("No device column is known in %s", vital)
+			}
+			statement = schema.byDevice
+		}
+		if s.window.bounded() {
+			statement = s.window.statement(statement, schema.timeColumn)
+		}
+		// A Stmt is safe for concurrent use by multiple goroutines.
+		stmt, err := db.PrepareNamed(statement)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.stmts = append(s.stmts, stmt)
+	}
+	return s, nil
+}
+
+// devices returns the devices recorded in the databases, by their id as
+// text.
+func (s *vitalSource) devices() ([]string, error) {
+	var devices []string
+	seen := make(map[string]bool)
+	for i, db := range s.dbs {
+		if s.schemas[i].devices == "" {
+			continue
+		}
+		var ds []string
+		if err := db.Select(&ds, s.schemas[i].devices); err != nil {
+			return nil, err
+		}
+		for _, d := range ds {
+			if !seen[d] {
+				seen[d] = true
+				devices = append(devices, d)
+			}
+		}
+	}
+	return devices, nil
+}
+
+// codes returns the ztype codes present in the i-th database.
+func (s *vitalSource) codes(i int) ([]int, error) {
+	var codes []int
+	err := s.dbs[i].Select(&codes, s.schemas[i].distinct)
+	return codes, err
+}
+
+func (s *vitalSource) query(t int) (rowScanner, error) {
+	var all []rowScanner
+	for i, stmt := range s.stmts {
+		ztype, ok := s.schemas[i].ztypes[t]
+		if !ok {
+			continue
+		}
+		var (
+			rows rowScanner
+			err  error
+		)
+		if s.salvage {
+			rows, err = salvage(s.dbs[i], s.schemas[i], ztype, s.epochs[i], s.window)
+		} else {
+			var rs *sqlx.Rows
+			params := map[string]interface{}{"ztype": ztype, "device": s.device}
+			rs, err = stmt.Queryx(s.window.params(params, s.epochs[i]))
+			rows = &vitalRows{rs, s.epochs[i]}
+		}
+		if err != nil {
+			for _, r := range all {
+				r.Close()
+			}
+			return nil, err
+		}
+		all = append(all, rows)
+	}
+	var rows rowScanner
+	switch len(all) {
+	case 0:
+		return &sliceRows{}, nil
+	case 1:
+		rows = all[0]
+	default:
+		rows = newMergedRows(all)
+	}
+	if s.dedup != "" {
+		label := "SpO2 quality"
+		if sig := signalTypes[t]; sig != nil {
+			label = sig.label
+		}
+		rows = newDedupRows(rows, s.dedup, label)
+	}
+	if len(s.drift) > 0 {
+		rows = &driftRows{rowScanner: rows, drift: s.drift}
+	}
+	return rows, nil
+}
+
+func (s *vitalSource) Close() error {
+	var err error
+	for _, stmt := range s.stmts {
+		if cerr := stmt.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, db := range s.dbs {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// sliceRows reads rows held in memory.
+type sliceRows struct {
+	rows []vitalRow
+	i    int
+}
+
+func (s *sliceRows) Next() bool {
+	s.i++
+	return s.i <= len(s.rows)
+}
+
+func (s *sliceRows) StructScan(dest interface{}) error {
+	s.rows[s.i-1].scan(dest)
+	return nil
+}
+
+func (s *sliceRows) Err() error {
+	return nil
+}
+
+func (s *sliceRows) Close() error {
+	return nil
+}
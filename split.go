@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Layouts of the segment keys inserted into the file names by -split-by.
+var splits = map[string]string{
+	"hour": "20060102-15",
+	"day":  "20060102",
+}
+
+// Layout of the segment keys of -segment-gap, the start of the session.
+const SESSION_LAYOUT = "20060102-150405"
+
+// splitEncoder writes each hour or day of a signal to its own file, or
+// each session, the runs of samples between gaps longer than
+// -segment-gap. A new file and encoder are opened when a batch starts a
+// new segment; segments are cut at hour and day boundaries of the time
+// zone of -tz.
+type splitEncoder struct {
+	opts   *Options
+	path   string
+	layout string
+	signal *signal
+	header interface{}
+	key    string
+	last   int64 // ztime of the last sample, with -segment-gap
+	f      io.WriteCloser
+	enc    encoder
+	paths  []string // files written so far
+}
+
+func newSplitEncoder(path string, opts *Options) encoder {
+	return &splitEncoder{opts: opts, path: path, layout: splits[opts.SplitBy]}
+}
+
+// split reports whether the outputs are split into segments, by
+// -split-by or -segment-gap.
+func (opts *Options) split() bool {
+	return opts.SplitBy != "" || opts.SegmentGap > 0
+}
+
+func (e *splitEncoder) Header(s *signal, v interface{}) error {
+	// Keep an empty slice of the same type to write the header of every
+	// segment.
+	e.signal, e.header = s, reflect.New(reflect.TypeOf(v).Elem()).Interface()
+	return nil
+}
+
+func (e *splitEncoder) Encode(s *signal, v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Len() == 0 {
+		return nil
+	}
+	ztime := rv.Index(0).FieldByName("Ztime").Int()
+	key := e.key
+	switch {
+	case e.layout != "":
+		key = time.Unix(ztime, 0).In(outputZone).Format(e.layout)
+	case e.enc == nil || time.Duration(ztime-e.last)*time.Second > e.opts.SegmentGap:
+		key = time.Unix(ztime, 0).In(outputZone).Format(SESSION_LAYOUT)
+	}
+	e.last = rv.Index(rv.Len() - 1).FieldByName("Ztime").Int()
+	if e.enc == nil || key != e.key {
+		if err := e.Close(); err != nil {
+			return err
+		}
+		if err := e.next(key); err != nil {
+			return err
+		}
+	}
+	return e.enc.Encode(s, v)
+}
+
+func (e *splitEncoder) next(key string) error {
+	name, k := e.opts.Name, key
+	if e.opts.Format.record {
+		name, k = wfdbRecordName(name), wfdbRecordName(key)
+	}
+	path := segmentPath(e.path, name, k)
+	f, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	e.paths = append(e.paths, path)
+
+	enc, err := newEncoder(f, e.opts)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	e.key, e.f, e.enc = key, f, enc
+	return enc.Header(e.signal, e.header)
+}
+
+func (e *splitEncoder) Close() error {
+	if e.enc == nil {
+		return nil
+	}
+	err := e.enc.Close()
+	if cerr := e.f.Close(); err == nil {
+		err = cerr
+	}
+	e.f, e.enc = nil, nil
+	return err
+}
+
+// segmentPath inserts the segment key after the recording name,
+// e.g. "rec.ecg_i.csv" becomes "rec_20161105-00.ecg_i.csv".
+func segmentPath(path, name, key string) string {
+	dir, base := filepath.Split(path)
+	return dir + name + "_" + key + strings.TrimPrefix(base, name)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// spoolSecond is one second of samples of a spooled recording.
+type spoolSecond struct {
+	ztime int64
+	n     int
+}
+
+// spool buffers the samples of a recording in a temporary file, for
+// formats whose header depends on the whole recording (sample rate,
+// physical range, sample count) and therefore can only be written once
+// the last sample is known.
+type spool struct {
+	sig      *signal
+	channels []channel
+	tmp      *os.File
+	buf      *bufio.Writer
+	seconds  []spoolSecond
+	min      []float64
+	max      []float64
+}
+
+func (s *spool) open(sig *signal) error {
+	s.sig, s.channels = sig, sig.channels()
+	s.min = make([]float64, len(s.channels))
+	s.max = make([]float64, len(s.channels))
+	for i := range s.channels {
+		s.min[i], s.max[i] = math.Inf(1), math.Inf(-1)
+	}
+
+	tmp, err := os.CreateTemp("", "vital2csv-spool-")
+	if err != nil {
+		return err
+	}
+	s.tmp, s.buf = tmp, bufio.NewWriter(tmp)
+	return nil
+}
+
+func (s *spool) encode(v interface{}) error {
+	return eachSample(v, s.add)
+}
+
+func (s *spool) add(ztime int64, vs ...float64) error {
+	if l := len(s.seconds); l == 0 || s.seconds[l-1].ztime != ztime {
+		s.seconds = append(s.seconds, spoolSecond{ztime: ztime})
+	}
+	s.seconds[len(s.seconds)-1].n++
+
+	for i, v := range vs {
+		if !math.IsNaN(v) {
+			s.min[i] = math.Min(s.min[i], v)
+			s.max[i] = math.Max(s.max[i], v)
+		}
+		if err := binary.Write(s.buf, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sampleRate returns the most frequent number of samples per second.
+func (s *spool) sampleRate() int {
+	counts := make(map[int]int)
+	rate := 1
+	for _, sec := range s.seconds {
+		counts[sec.n]++
+		if counts[sec.n] > counts[rate] {
+			rate = sec.n
+		}
+	}
+	return rate
+}
+
+// physical returns the value range of channel i, widened if the channel
+// is empty or constant so that it can be used as a scale.
+func (s *spool) physical(i int) (float64, float64) {
+	min, max := s.min[i], s.max[i]
+	if math.IsInf(min, 0) || math.IsInf(max, 0) {
+		return -1, 1
+	}
+	if min == max {
+		return min - 1, max + 1
+	}
+	return min, max
+}
+
+// each calls f for every spooled second with the samples of each channel
+// resampled to rate samples. If rate is 0 the samples are passed as is.
+func (s *spool) each(rate int, f func(sec spoolSecond, chs [][]float64) error) error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(s.tmp)
+	nc := len(s.channels)
+	for _, sec := range s.seconds {
+		vs := make([]float64, sec.n*nc)
+		if err := binary.Read(r, binary.LittleEndian, vs); err != nil {
+			return err
+		}
+		chs := make([][]float64, nc)
+		for i := range chs {
+			ch := make([]float64, sec.n)
+			for j := range ch {
+				ch[j] = vs[j*nc+i]
+			}
+			chs[i] = resample(ch, rate)
+		}
+		if err := f(sec, chs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the whole recording into memory, returning the interpolated
+// time of every sample in Unix nanoseconds and the values of each channel.
+func (s *spool) load() ([]int64, [][]float64, error) {
+	var ts []int64
+	chs := make([][]float64, len(s.channels))
+	err := s.each(0, func(sec spoolSecond, vs [][]float64) error {
+		for j := 0; j < sec.n; j++ {
+			ts = append(ts, sec.ztime*int64(time.Second)+int64(j)*int64(time.Second)/int64(sec.n))
+		}
+		for i := range chs {
+			chs[i] = append(chs[i], vs[i]...)
+		}
+		return nil
+	})
+	return ts, chs, err
+}
+
+func (s *spool) release() {
+	s.tmp.Close()
+	os.Remove(s.tmp.Name())
+}
+
+// resample linearly interpolates vs onto n evenly spaced points.
+func resample(vs []float64, n int) []float64 {
+	if n == 0 || len(vs) == n {
+		return vs
+	}
+	r := make([]float64, n)
+	l := len(vs)
+	for j := range r {
+		pos := float64(j) * float64(l) / float64(n)
+		i := int(pos)
+		if i >= l-1 {
+			r[j] = vs[l-1]
+			continue
+		}
+		frac := pos - float64(i)
+		r[j] = vs[i]*(1-frac) + vs[i+1]*frac
+	}
+	return r
+}
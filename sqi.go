@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+)
+
+const SQI_FILE_SUFFIX = ".sqi.csv"
+
+// Thresholds of the signal quality index of -sqi. A second of ECG is
+// clean by its kurtosis if it is over SQI_KURTOSIS, as the peaked QRS
+// complexes make it, and by its power ratio, the power of 5-15 Hz over
+// that of 5-40 Hz, if it is within SQI_POWER_LOW to SQI_POWER_HIGH; its
+// index is the share of the two that hold. A second of a signal of three
+// axes has the share of its samples not saturated, a sample being
+// saturated if an axis is within a run of CLIP_RUN or more samples at the
+// highest or lowest value of the second (see -artifacts).
+const (
+	SQI_KURTOSIS   = 5
+	SQI_POWER_LOW  = 0.5
+	SQI_POWER_HIGH = 0.8
+)
+
+// sqiSecond is a second of ztime of a signal with its quality. The
+// measures that do not apply to the signal are NaN.
+type sqiSecond struct {
+	ztime      int64
+	samples    int
+	kurtosis   float64
+	powerRatio float64
+	saturated  float64
+	index      float64
+}
+
+// sqiEncoder finds the quality of the samples of a signal passed to its
+// encoder, second by second of their ztime: the ECG by its kurtosis and
+// power ratio, signals of three axes by their saturation. flush finds
+// that of the last second.
+type sqiEncoder struct {
+	encoder
+	seconds []sqiSecond
+	ztime   int64
+	values  [][]float64 // of the current second, by sample
+}
+
+func (e *sqiEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		if len(e.values) > 0 && ztime != e.ztime {
+			e.flush(s)
+		}
+		e.ztime = ztime
+		e.values = append(e.values, append([]float64(nil), vs[:s.axes]...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// flush finds the quality of the current second.
+func (e *sqiEncoder) flush(s *signal) {
+	if len(e.values) == 0 {
+		return
+	}
+	q := sqiSecond{
+		ztime: e.ztime, samples: len(e.values),
+		kurtosis: math.NaN(), powerRatio: math.NaN(), saturated: math.NaN(), index: math.NaN(),
+	}
+	if s.axes == 1 {
+		x := make([]float64, 0, len(e.values))
+		for _, vs := range e.values {
+			if !math.IsNaN(vs[0]) {
+				x = append(x, vs[0])
+			}
+		}
+		q.kurtosis, q.powerRatio = kurtosis(x), powerRatio(x)
+		if !math.IsNaN(q.kurtosis) && !math.IsNaN(q.powerRatio) {
+			q.index = 0
+			if q.kurtosis > SQI_KURTOSIS {
+				q.index += 0.5
+			}
+			if q.powerRatio >= SQI_POWER_LOW && q.powerRatio <= SQI_POWER_HIGH {
+				q.index += 0.5
+			}
+		}
+	} else {
+		n := saturated(e.values)
+		q.saturated = float64(n)
+		q.index = 1 - float64(n)/float64(len(e.values))
+	}
+	e.seconds = append(e.seconds, q)
+	e.values = e.values[:0]
+}
+
+// kurtosis returns the kurtosis of x, NaN if it is constant.
+func kurtosis(x []float64) float64 {
+	if len(x) < 2 {
+		return math.NaN()
+	}
+	var mean float64
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(len(x))
+	var m2, m4 float64
+	for _, v := range x {
+		d := (v - mean) * (v - mean)
+		m2, m4 = m2+d, m4+d*d
+	}
+	if m2 == 0 {
+		return math.NaN()
+	}
+	n := float64(len(x))
+	return (m4 / n) / ((m2 / n) * (m2 / n))
+}
+
+// powerRatio returns the power of the samples x of a second at 5-15 Hz
+// over that at 5-40 Hz, by the bins of their discrete Fourier transform,
+// which are 1 Hz apart. It is NaN if the sample rate is below 30 Hz,
+// whose Nyquist frequency is below 15 Hz, or there is no power.
+func powerRatio(x []float64) float64 {
+	n := len(x)
+	if n < 30 {
+		return math.NaN()
+	}
+	var mean float64
+	for _, v := range x {
+		mean += v
+	}
+	mean /= float64(n)
+	var low, all float64
+	for k := 5; k <= 40 && k <= n/2; k++ {
+		var re, im float64
+		for j, v := range x {
+			a := 2 * math.Pi * float64(k*j) / float64(n)
+			re += (v - mean) * math.Cos(a)
+			im -= (v - mean) * math.Sin(a)
+		}
+		p := re*re + im*im
+		if k <= 15 {
+			low += p
+		}
+		all += p
+	}
+	if all == 0 {
+		return math.NaN()
+	}
+	return low / all
+}
+
+// saturated returns the number of samples of a second of which an axis
+// is within a run of CLIP_RUN or more samples at the highest or lowest
+// value of the axis in the second.
+func saturated(samples [][]float64) int {
+	flags := make([]bool, len(samples))
+	for i := range samples[0] {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, vs := range samples {
+			if !math.IsNaN(vs[i]) {
+				low, high = math.Min(low, vs[i]), math.Max(high, vs[i])
+			}
+		}
+		if low == high {
+			continue // flat, not saturated
+		}
+		for j := 0; j < len(samples); {
+			k := j + 1
+			for k < len(samples) && samples[k][i] == samples[j][i] {
+				k++
+			}
+			if v := samples[j][i]; k-j >= CLIP_RUN && (v == low || v == high) {
+				for m := j; m < k; m++ {
+					flags[m] = true
+				}
+			}
+			j = k
+		}
+	}
+	n := 0
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+	return n
+}
+
+// writeSQI writes the seconds of the signals written, by their encoders
+// finding their quality, to <name>.sqi.csv: the kurtosis and power ratio
+// of the ECG, the samples saturated of signals of three axes, and the
+// index of each, from 0 to 1 for clean.
+func writeSQI(encs map[int]*sqiEncoder, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"signal", "time", "timestamp", "samples", "kurtosis", "power_ratio", "saturated", "sqi"})
+	value := func(v float64) string {
+		if math.IsNaN(v) {
+			return ""
+		}
+		return strconv.FormatFloat(round(v), 'g', -1, 64)
+	}
+	for _, t := range opts.Signals {
+		e := encs[t]
+		if e == nil {
+			continue
+		}
+		for _, q := range e.seconds {
+			w.Write([]string{
+				signalTypes[t].name,
+				timeLayout.formatTime(time.Unix(q.ztime, 0)),
+				strconv.FormatInt(q.ztime, 10),
+				strconv.Itoa(q.samples),
+				value(q.kurtosis),
+				value(q.powerRatio),
+				value(q.saturated),
+				value(q.index),
+			})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+SQI_FILE_SUFFIX), b.Bytes())
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Rows are committed in transactions of this size.
+const SQLITE_COMMIT_SIZE = 100000
+
+const SQLITE_PRAGMAS = `
+PRAGMA journal_mode = OFF;
+PRAGMA synchronous = OFF;
+`
+
+// The table of a signal, with a REAL column per channel.
+const SQLITE_TABLE = `
+CREATE TABLE %s (
+  timestamp INTEGER NOT NULL,
+  z_fok_timestamp INTEGER NOT NULL,
+%s  detailed_timestamp INTEGER NOT NULL
+);
+`
+
+const SQLITE_INDEX = `CREATE INDEX %[1]s_detailed_timestamp ON %[1]s (detailed_timestamp);`
+
+// sqliteEncoder writes each signal into a table named after it ("ecg",
+// "accel") of a new SQLite database. timestamp holds Unix seconds and
+// detailed_timestamp the interpolated time in Unix nanoseconds.
+type sqliteEncoder struct {
+	sync.Mutex
+	w      io.Writer
+	db     *sqlx.DB
+	tx     *sqlx.Tx
+	n      int
+	tables []string
+}
+
+func newSQLiteEncoder(w io.Writer, opts *Options) encoder {
+	return &sqliteEncoder{w: w}
+}
+
+func (e *sqliteEncoder) Header(s *signal, v interface{}) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.db == nil {
+		path, err := outputPath(e.w, "sqlite")
+		if err != nil {
+			return err
+		}
+		db, err := sqlx.Connect("sqlite3", path)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(SQLITE_PRAGMAS); err != nil {
+			db.Close()
+			return err
+		}
+		e.db = db
+		if e.tx, err = db.Beginx(); err != nil {
+			return err
+		}
+	}
+
+	var columns string
+	for _, c := range s.channels() {
+		if c.optional {
+			columns += "  " + c.name + " REAL,\n"
+		} else {
+			columns += "  " + c.name + " REAL NOT NULL,\n"
+		}
+	}
+	e.tables = append(e.tables, s.name)
+	_, err := e.tx.Exec(fmt.Sprintf(SQLITE_TABLE, s.name, columns))
+	return err
+}
+
+func (e *sqliteEncoder) Encode(s *signal, v interface{}) error {
+	e.Lock()
+	defer e.Unlock()
+
+	insert := `INSERT INTO ` + s.name + ` VALUES (?, ?` + strings.Repeat(", ?", len(s.channels())) + `, ?)`
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		args := []interface{}{ztime, zfok}
+		for _, x := range vs {
+			if math.IsNaN(x) {
+				args = append(args, nil)
+			} else {
+				args = append(args, x)
+			}
+		}
+		_, err := e.tx.Exec(insert, append(args, detailed.UnixNano())...)
+		e.n++
+		return err
+	})
+	if err != nil || e.n < SQLITE_COMMIT_SIZE {
+		return err
+	}
+	e.n = 0
+	if err := e.tx.Commit(); err != nil {
+		return err
+	}
+	e.tx, err = e.db.Beginx()
+	return err
+}
+
+func (e *sqliteEncoder) Close() error {
+	if e.db == nil {
+		return nil
+	}
+	defer e.db.Close()
+	if err := e.tx.Commit(); err != nil {
+		return err
+	}
+	for _, table := range e.tables {
+		if _, err := e.db.Exec(fmt.Sprintf(SQLITE_INDEX, table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"time"
+)
+
+const STEPS_FILE_SUFFIX = ".steps.csv"
+
+// Heuristics of the steps of -steps, found in the magnitude of the accel
+// in g. Gravity is removed by a moving average of STEP_BASELINE, and the
+// rest smoothed by one of STEP_SMOOTHING. A step is a peak of it over
+// STEP_THRESHOLD at least STEP_MIN_INTERVAL after the previous one, and
+// steps are counted in bouts of STEP_MIN_BOUT or more, each at most
+// STEP_MAX_INTERVAL after the previous one, as walking is.
+const (
+	STEP_BASELINE     = time.Second
+	STEP_SMOOTHING    = 50 * time.Millisecond
+	STEP_THRESHOLD    = 0.1
+	STEP_MIN_INTERVAL = 250 * time.Millisecond
+	STEP_MAX_INTERVAL = 2 * time.Second
+	STEP_MIN_BOUT     = 4
+)
+
+// stepMinute is a minute of the accel with the steps counted in it.
+type stepMinute struct {
+	start   time.Time
+	samples int
+	steps   int
+}
+
+// stepEncoder counts the steps in the accel passed to its encoder, by
+// minutes. flush counts those of the last bout.
+type stepEncoder struct {
+	encoder
+	minutes  []stepMinute
+	last     time.Time // of the last sample
+	baseline float64
+	smooth   float64
+	rising   bool
+	peak     time.Time // of the highest value since the last fall
+	peakV    float64
+	bout     []time.Time // steps of the current bout
+}
+
+func (e *stepEncoder) Encode(s *signal, v interface{}) error {
+	err := eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		m := math.Sqrt(vs[0]*vs[0] + vs[1]*vs[1] + vs[2]*vs[2])
+		if math.IsNaN(m) {
+			return nil
+		}
+		minute := detailed.Truncate(time.Minute)
+		if n := len(e.minutes); n == 0 || !e.minutes[n-1].start.Equal(minute) {
+			e.minutes = append(e.minutes, stepMinute{start: minute})
+		}
+		e.minutes[len(e.minutes)-1].samples++
+
+		dt := detailed.Sub(e.last)
+		if e.last.IsZero() || dt <= 0 || dt > time.Second {
+			// A gap starts the filters again.
+			e.baseline, e.smooth, e.rising = m, 0, false
+			e.last = detailed
+			return nil
+		}
+		e.last = detailed
+		e.baseline += (m - e.baseline) * float64(dt) / float64(STEP_BASELINE+dt)
+		y := e.smooth + (m-e.baseline-e.smooth)*float64(dt)/float64(STEP_SMOOTHING+dt)
+		switch {
+		case y > e.smooth:
+			if !e.rising || y > e.peakV {
+				e.peak, e.peakV = detailed, y
+			}
+			e.rising = true
+		case e.rising && y < e.smooth:
+			e.rising = false
+			if e.peakV > STEP_THRESHOLD {
+				e.step(e.peak)
+			}
+		}
+		e.smooth = y
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return e.encoder.Encode(s, v)
+}
+
+// step adds a step at t to the current bout, or starts a bout with it.
+func (e *stepEncoder) step(t time.Time) {
+	if n := len(e.bout); n > 0 {
+		d := t.Sub(e.bout[n-1])
+		if d < STEP_MIN_INTERVAL {
+			return
+		}
+		if d > STEP_MAX_INTERVAL {
+			e.flush()
+		}
+	}
+	e.bout = append(e.bout, t)
+}
+
+// flush ends the current bout, counting its steps if it is long enough.
+func (e *stepEncoder) flush() {
+	if len(e.bout) >= STEP_MIN_BOUT {
+		i := len(e.minutes) - 1
+		for j := len(e.bout) - 1; j >= 0; j-- {
+			minute := e.bout[j].Truncate(time.Minute)
+			for i > 0 && e.minutes[i].start.After(minute) {
+				i--
+			}
+			e.minutes[i].steps++
+		}
+	}
+	e.bout = e.bout[:0]
+}
+
+// writeSteps writes the steps counted in each minute of the accel to
+// <name>.steps.csv.
+func writeSteps(e *stepEncoder, opts *Options) error {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = opts.Delimiter
+	w.Write([]string{"time", "timestamp", "samples", "steps"})
+	for _, m := range e.minutes {
+		w.Write([]string{
+			timeLayout.formatTime(m.start),
+			strconv.FormatInt(m.start.Unix(), 10),
+			strconv.Itoa(m.samples),
+			strconv.Itoa(m.steps),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeOutput(joinOutput(opts.OutDir, opts.Name+STEPS_FILE_SUFFIX), b.Bytes())
+}
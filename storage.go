@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+// isObject reports whether p is an s3:// or gs:// URI of an object
+// store. Credentials and region are taken from the environment, as the
+// aws and gcloud tools do.
+func isObject(p string) bool {
+	lp := strings.ToLower(p)
+	return strings.HasPrefix(lp, "s3://") || strings.HasPrefix(lp, "gs://")
+}
+
+// splitQuery splits the query of object URI p, with its "?", off the
+// rest. The query holds the options of the bucket, such as ?region= or
+// ?endpoint= of S3, and is kept on the URIs of the objects in it.
+func splitQuery(p string) (string, string) {
+	if i := strings.Index(p, "?"); i >= 0 && isObject(p) {
+		return p[:i], p[i:]
+	}
+	return p, ""
+}
+
+// openObject opens the bucket of the object URI p, with the options of
+// its query, and returns the key of the object in it.
+func openObject(ctx context.Context, p string) (*blob.Bucket, string, error) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, "", err
+	}
+	bucket := u.Scheme + "://" + u.Host
+	if u.RawQuery != "" {
+		bucket += "?" + u.RawQuery
+	}
+	b, err := blob.OpenBucket(ctx, bucket)
+	return b, strings.TrimPrefix(u.Path, "/"), err
+}
+
+// findObjects returns p if it names a vital database, or else the URIs of
+// all vital databases under the prefix p.
+func findObjects(p string) ([]string, error) {
+	ctx := context.Background()
+	b, key, err := openObject(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+	if isVital(key) {
+		return []string{p}, nil
+	}
+
+	prefix := key
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	base, query := splitQuery(p)
+	bucket := base[:len(base)-len(key)]
+	bucket = strings.TrimSuffix(bucket, "/") + "/"
+	var vitals []string
+	it := b.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := it.Next(ctx)
+		if err == io.EOF {
+			return vitals, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !obj.IsDir && isVital(obj.Key) {
+			vitals = append(vitals, bucket+obj.Key+query)
+		}
+	}
+}
+
+// fetchObject copies the object at p to a temporary file and calls f
+// with it, like extract.
+func fetchObject(p string, f func(name, vital string)) error {
+	ctx := context.Background()
+	b, key, err := openObject(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	r, err := b.NewReader(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return extract(path.Base(key), r, f)
+}
+
+// fetcherOf returns the function copying a remote input to a temporary
+// file, or nil if the input is local.
+func fetcherOf(input string) func(string, func(name, vital string)) error {
+	switch {
+	case isURL(input):
+		return download
+	case isObject(input):
+		return fetchObject
+	}
+	return nil
+}
+
+// objectWriter uploads an output file while it is written. The object is
+// only created by Close.
+type objectWriter struct {
+	*blob.Writer
+	bucket *blob.Bucket
+}
+
+func (w *objectWriter) Close() error {
+	err := w.Writer.Close()
+	if cerr := w.bucket.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// createOutput creates the output file at p, a local path or an object
+// URI.
+func createOutput(p string) (io.WriteCloser, error) {
+	if !isObject(p) {
+		return os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+	ctx := context.Background()
+	b, key, err := openObject(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	w, err := b.NewWriter(ctx, key, nil)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return &objectWriter{w, b}, nil
+}
+
+// writeOutput writes the output file at p in one piece.
+func writeOutput(p string, data []byte) error {
+	w, err := createOutput(p)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// joinOutput joins the output directory and a file name. filepath.Join
+// would clean the "//" of an object URI, whose query stays at the end.
+func joinOutput(dir, name string) string {
+	if isObject(dir) {
+		base, query := splitQuery(dir)
+		return strings.TrimSuffix(base, "/") + "/" + name + query
+	}
+	return filepath.Join(dir, name)
+}
+
+// outputDir returns the directory of the output file at p.
+func outputDir(p string) string {
+	if isObject(p) {
+		base, query := splitQuery(p)
+		return base[:strings.LastIndex(base, "/")] + query
+	}
+	return filepath.Dir(p)
+}
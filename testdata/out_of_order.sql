@@ -0,0 +1,19 @@
+-- A vital database of two seconds of ECG whose fourth row, stored after
+-- the fifth, has a z_fok_timestamp below the row before it, for the
+-- policies of -out-of-order:
+--
+--	make test-out-of-order
+CREATE TABLE ZLOGGEDTIME (Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZTIME TIMESTAMP);
+CREATE TABLE ZLOGGEDDATA (Z_PK INTEGER PRIMARY KEY, Z_ENT INTEGER, Z_OPT INTEGER, ZTYPE INTEGER, ZTIMESTAMP INTEGER, Z_FOK_TIMESTAMP INTEGER, ZVALUE FLOAT);
+INSERT INTO ZLOGGEDTIME VALUES (1, 1, 1, 500000000), (2, 1, 1, 500000001);
+INSERT INTO ZLOGGEDDATA VALUES
+  (1, 1, 1, 8, 1, 0, 0.1),
+  (2, 1, 1, 8, 1, 1, 0.2),
+  (3, 1, 1, 8, 1, 3, 0.4),
+  (4, 1, 1, 8, 1, 2, 0.3),
+  (5, 1, 1, 8, 1, 4, 0.5),
+  (6, 1, 1, 8, 2, 5, 0.6),
+  (7, 1, 1, 8, 2, 6, 0.7),
+  (8, 1, 1, 8, 2, 7, 0.8),
+  (9, 1, 1, 8, 2, 8, 0.9),
+  (10, 1, 1, 8, 2, 9, 1.0);
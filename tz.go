@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones of -tz on hosts without a zoneinfo database
+)
+
+// outputZone is the time zone of the times written, set by -tz. With -tz
+// device it is set for each input to the time zone recorded in it.
+var outputZone = time.Local
+
+// deviceZone is set by -tz device.
+var deviceZone bool
+
+// parseZone parses -tz: local, UTC, device or an IANA time zone name such
+// as Europe/Berlin.
+func parseZone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "local":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "" {
+		return nil, fmt.Errorf("Invalid -tz %q, give local, UTC, device or a time zone name such as Europe/Berlin", name)
+	}
+	return loc, nil
+}
+
+// hasTimeZone reports whether a schema knows the time zone of the device.
+func hasTimeZone() bool {
+	for _, s := range vitalSchemas {
+		if s.timeZone != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// timeZone returns the time zone recorded in the databases: the first
+// value of the time zone column of a schema, a time zone name or the
+// offset from UTC in seconds. It returns nil if none is recorded.
+func (s *vitalSource) timeZone() (*time.Location, error) {
+	for i, db := range s.dbs {
+		if s.schemas[i].timeZone == "" {
+			continue
+		}
+		var v sql.NullString
+		if err := db.Get(&v, s.schemas[i].timeZone); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if !v.Valid {
+			continue
+		}
+		name := strings.TrimSpace(v.String)
+		if offset, err := strconv.Atoi(name); err == nil {
+			return time.FixedZone("", offset), nil
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time zone recorded: %q", name)
+		}
+		return loc, nil
+	}
+	return nil, nil
+}
+
+// setDeviceZone sets the time zone of the times written to that recorded
+// in the input, for -tz device, or the local time zone if it has none.
+func setDeviceZone(src source) error {
+	outputZone = time.Local
+	vs, ok := src.(*vitalSource)
+	if !ok {
+		log.Print("No time zone is recorded, times are in local time")
+		return nil
+	}
+	loc, err := vs.timeZone()
+	if err != nil {
+		return err
+	}
+	if loc == nil {
+		log.Print("No time zone is recorded, times are in local time")
+		return nil
+	}
+	outputZone = loc
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Standard gravity in m/s² per g.
+const STANDARD_GRAVITY = 9.80665
+
+// unitScale is a unit values are written in, and its amount in the base
+// unit of its quantity.
+type unitScale struct {
+	unit  string
+	scale float64
+}
+
+// quantity is the physical quantity of a signal: the units it may be
+// written in, by the names of the option setting them, and the units it
+// may be recorded in, given by -signals, with their amounts in the base
+// unit.
+type quantity struct {
+	units    map[string]unitScale
+	recorded map[string]float64
+}
+
+// acceleration is the quantity of -accel-unit, in g.
+var acceleration = quantity{
+	units:    map[string]unitScale{"g": {"g", 1}, "ms2": {"m/s²", STANDARD_GRAVITY}},
+	recorded: map[string]float64{"g": 1, "m/s²": STANDARD_GRAVITY, "m/s2": STANDARD_GRAVITY},
+}
+
+// voltage is the quantity of -ecg-unit, in mV. "raw" writes ADC counts,
+// by the gain and offset given by -signals.
+var voltage = quantity{
+	units:    map[string]unitScale{"mv": {"mV", 1}, "uv": {"µV", 1000}, "raw": {RAW_UNIT, 0}},
+	recorded: map[string]float64{"V": 0.001, "mV": 1, "µV": 1000, "uV": 1000},
+}
+
+// names returns the names of the units of q.
+func (q quantity) names() []string {
+	names := make([]string, 0, len(q.units))
+	for name := range q.units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setUnit sets the unit signal s is written in to that named name by
+// option, converted from the unit s is recorded in.
+func setUnit(s *signal, option, name string, q quantity) error {
+	u, ok := q.units[name]
+	if !ok {
+		return fmt.Errorf("Unknown unit of %s: %s, give %s", option, name, strings.Join(q.names(), ", "))
+	}
+	if u.unit == RAW_UNIT {
+		if s.gain == 0 {
+			return fmt.Errorf("No gain of %s is known, give it with -signals", s.name)
+		}
+		s.raw = true
+		return nil
+	}
+	from, ok := q.recorded[s.unit]
+	if !ok {
+		return fmt.Errorf("Unit %s of %s cannot be converted by %s", s.unit, s.name, option)
+	}
+	if u.unit != s.unit {
+		s.written, s.scale = u.unit, u.scale/from
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// validation is the report of validate on one input, written as a line
+// of JSON.
+type validation struct {
+	Input         string          `json:"input"`
+	Valid         bool            `json:"valid"`
+	Schema        string          `json:"schema,omitempty"`
+	Rows          map[int64]int64 `json:"rows,omitempty"` // by ztype code
+	TimeDecreases int64           `json:"time_decreases"`
+	Integrity     []string        `json:"integrity,omitempty"`
+	Errors        []string        `json:"errors,omitempty"`
+}
+
+// validateCommand checks vital databases before they are converted: the
+// schema is known, the logged times never decrease and SQLite finds the
+// file intact. It prints one report per input and exits with 1 if any
+// input is invalid.
+func validateCommand(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `
+Usage of %s validate:
+  %s validate [options] vital_data|directory|archive|url|-...
+
+
+`, path.Base(os.Args[0]), os.Args[0])
+		fs.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+	var key, keyFile, mode, schema string
+	fs.StringVar(&key, "key", "", "Key of SQLCipher encrypted input(passphrase, or x'hex' for a raw key)")
+	fs.StringVar(&keyFile, "key-file", "", "File holding the key of SQLCipher encrypted input")
+	fs.StringVar(&mode, "open-mode", "immutable", "Open mode of input(immutable, ro, rw)")
+	fs.StringVar(&schema, "schema", "", "JSON file naming the tables and columns of a database variant")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(ExitCode)
+	}
+	if _, ok := openModes[mode]; !ok {
+		log.Fatalf("Unknown open mode: %s", mode)
+	}
+	if schema != "" {
+		s, err := loadSchema(schema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vitalSchemas = []vitalSchema{s}
+	}
+	opts := Options{Key: readKey(key, keyFile), OpenMode: mode}
+
+	enc := json.NewEncoder(os.Stdout)
+	report := func(input, vital string) {
+		v := validate(vital, &opts)
+		v.Input = input
+		if !v.Valid {
+			ExitCode = 1
+		}
+		if err := enc.Encode(v); err != nil {
+			log.Fatal(err)
+		}
+	}
+	eachInput(fs.Args(), report)
+}
+
+// eachInput calls f with each vital database of args, as the inputs of a
+// subcommand that reads them in place: files, those found in
+// directories, archives and URLs, and stdin, each copied to a temporary
+// file. input names the database in reports.
+func eachInput(args []string, f func(input, vital string)) {
+	for _, arg := range args {
+		inputs, err := findVitals(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, input := range inputs {
+			switch {
+			case input == STDIN_INPUT:
+				err = extract(STDIN_NAME+VITAL_FILE_EXT, os.Stdin, func(_, vital string) {
+					f(input, vital)
+				})
+			case fetcherOf(input) != nil:
+				err = fetcherOf(input)(input, func(_, vital string) {
+					f(input, vital)
+				})
+			case isArchive(input):
+				err = eachArchived(input, func(name, vital string) {
+					f(input+":"+name, vital)
+				})
+			default:
+				f(input, input)
+			}
+			if err != nil {
+				log.Printf("%s: Read input: %v", input, err)
+				ExitCode = 1
+			}
+		}
+	}
+}
+
+func validate(vital string, opts *Options) validation {
+	v := validation{}
+	fail := func(err error) validation {
+		v.Errors = append(v.Errors, err.Error())
+		return v
+	}
+
+	db, err := sqlx.Connect("sqlite3", inputDSN(vital, opts))
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+
+	if err := db.Select(&v.Integrity, `PRAGMA integrity_check`); err != nil {
+		return fail(err)
+	}
+	if len(v.Integrity) == 1 && v.Integrity[0] == "ok" {
+		v.Integrity = nil
+	}
+
+	schema, err := probeSchema(db)
+	if err != nil {
+		return fail(err)
+	}
+	v.Schema = schema.name
+
+	rows, err := db.Query(schema.counts)
+	if err != nil {
+		return fail(err)
+	}
+	defer rows.Close()
+	v.Rows = make(map[int64]int64)
+	for rows.Next() {
+		var ztype, n int64
+		if err := rows.Scan(&ztype, &n); err != nil {
+			return fail(err)
+		}
+		v.Rows[ztype] = n
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	times, err := db.Query(schema.times)
+	if err != nil {
+		return fail(err)
+	}
+	defer times.Close()
+	var prev float64
+	for first := true; times.Next(); first = false {
+		var t float64
+		if err := times.Scan(&t); err != nil {
+			return fail(err)
+		}
+		if !first && t < prev {
+			v.TimeDecreases++
+		}
+		prev = t
+	}
+	if err := times.Err(); err != nil {
+		return fail(err)
+	}
+
+	v.Valid = len(v.Errors) == 0 && len(v.Integrity) == 0 && v.TimeDecreases == 0
+	return v
+}
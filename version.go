@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+)
+
+// The version of the build, its git commit and date, set by the linker
+// (see the Makefile):
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Builds without them take the commit and its time from the build info
+// of the go command, if it built from a git checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// versionString returns the version, commit and build date of the tool.
+func versionString() string {
+	c, date, modified := commit, buildDate, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = s.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = s.Value
+				}
+			case "vcs.modified":
+				modified = commit == "" && s.Value == "true"
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if modified {
+		c += "-dirty"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("%s %s (commit %s, built %s, %s)", path.Base(os.Args[0]), version, c, date, runtime.Version())
+}
+
+// versionCommand prints the version of the tool.
+func versionCommand(args []string) {
+	fmt.Println(versionString())
+}
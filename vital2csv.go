@@ -1,220 +1,1899 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"math"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/gocarina/gocsv"
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/bmatcuk/doublestar/v4"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
 )
 
 const (
-	ECG_TYPE       = 8
-	ACCEL_TYPE     = 1
-	ECG_FILE_EXT   = ".ecg_i.csv"
-	ACCEL_FILE_EXT = ".acc_i.csv"
-	SQL_STATEMENT  = `
+	ECG_TYPE          = 8
+	ACCEL_TYPE        = 1
+	HR_TYPE           = -1 // no ztype code in the built-in schema, see -schema
+	SPO2_TYPE         = -2
+	SPO2_QUALITY_TYPE = -3
+	RESP_TYPE         = -4
+	TEMP_TYPE         = -5
+	GYRO_TYPE         = -6
+	MAG_TYPE          = -7
+	ECG_FILE_SUFFIX   = ".ecg_i"
+	ACCEL_FILE_SUFFIX = ".acc_i"
+	HR_FILE_SUFFIX    = ".hr"
+	SPO2_FILE_SUFFIX  = ".spo2"
+	RESP_FILE_SUFFIX  = ".resp"
+	TEMP_FILE_SUFFIX  = ".temp"
+	GYRO_FILE_SUFFIX  = ".gyr_i"
+	MAG_FILE_SUFFIX   = ".mag_i"
+	// Outputs of -by-device are named <name>.device-<id>.
+	DEVICE_NAME_PREFIX = ".device-"
+	VITAL_FILE_EXT     = ".vital"
+	STDIN_INPUT        = "-"
+	STDIN_NAME         = "stdin" // name of the output files of STDIN_INPUT
+	SQL_STATEMENT      = `
+SELECT
+  CAST(t.ztime AS REAL) AS ztime,
+  d.z_fok_timestamp AS zfok_timestamp,
+  d.zvalue AS value 
+FROM
+  ZLOGGEDDATA d INNER JOIN zloggedtime t ON d.ztimestamp = t.z_pk 
+WHERE
+  d.ztype = :ztype ORDER BY ztime ASC, d.z_pk ASC;
+`
+	SQL_SALVAGE_STATEMENT = `
 SELECT
-  (t.ztime + strftime('%s', '2001-01-01 00::00::00')) AS timestamp,
+  CAST(t.ztime AS REAL) AS ztime,
   d.z_fok_timestamp AS zfok_timestamp,
   d.zvalue AS value 
 FROM
   ZLOGGEDDATA d INNER JOIN zloggedtime t ON d.ztimestamp = t.z_pk 
 WHERE
-  d.ztype = :ztype ORDER BY timestamp ASC, zfok_timestamp ASC;
+  d.ztype = :ztype AND d.z_pk BETWEEN :from AND :to;
 `
 )
 
 var ExitCode int = 0
 
+// timeFormat formats OriginalTimestamp and DetailedTimestamp, either
+// with layouts in the time zone of -tz or as integers of unit since the
+// Unix epoch.
+type timeFormat struct {
+	layout, detailedLayout string
+	unit                   time.Duration
+}
+
+// Time formats selectable by -time-format.
+var timeFormats = map[string]timeFormat{
+	"local": {layout: "2006-01-02 15:04:05", detailedLayout: "2006-01-02 15:04:05.000000000"},
+	// local with the UTC offset, which "local" switches to for recordings
+	// spanning a daylight saving time transition.
+	"local-offset": {layout: "2006-01-02 15:04:05-07:00", detailedLayout: "2006-01-02 15:04:05.000000000-07:00"},
+	"rfc3339":      {layout: time.RFC3339, detailedLayout: "2006-01-02T15:04:05.000000000Z07:00"},
+	"epoch-ms":     {unit: time.Millisecond},
+	"epoch-ns":     {unit: time.Nanosecond},
+}
+
+var timeLayout = timeFormats["local"]
+
+// Number of decimal places of sample values, or -1 for full precision.
+var precision = -1
+
+// Policies of -incomplete for samples of three axes missing some: "nan"
+// writes them with NaN for the missing axes, "hold" with the values of
+// the previous sample, "drop" leaves them out.
+var incompletePolicies = []string{"nan", "hold", "drop"}
+
+var incompletePolicy = "nan"
+
+// Strategies of -interpolation for the detailed times of the samples of a
+// second: "spread" spreads them evenly over it, "rate" spaces them by the
+// sample rate given by -signals, "zfok" by their z_fok_timestamp, and
+// "none" leaves them at the time of the second. "timestamp" takes the
+// z_fok_timestamp for the time the sample was taken, in units of
+// -zfok-scale. "auto" spaces them by the sample rate if it is known, and
+// spreads them otherwise.
+var interpolationStrategies = []string{"auto", "spread", "rate", "zfok", "timestamp", "none"}
+
+var interpolationStrategy = "auto"
+
+// zfokScale is the unit of the z_fok_timestamp with -interpolation
+// timestamp.
+var zfokScale = time.Millisecond
+
+func (f timeFormat) formatTime(t time.Time) string {
+	return f.format(t, f.layout)
+}
+
+func (f timeFormat) formatDetailed(t time.Time) string {
+	return f.format(t, f.detailedLayout)
+}
+
+func (f timeFormat) format(t time.Time, layout string) string {
+	if f.unit > 0 {
+		return strconv.FormatInt(t.UnixNano()/int64(f.unit), 10)
+	}
+	return t.In(outputZone).Format(layout)
+}
+
+type Options struct {
+	Inputs   []string
+	Concat   bool // Inputs are databases of one recording
+	Batch    bool // more than one database is converted
+	Watch    string
+	Manifest string // csv of inputs, subjects and output names
+	Force    bool   // convert inputs of a batch whose outputs are up to date
+	Verbose  bool   // log the sample rates detected
+
+	OutDir string
+	Vital  string
+	Merged []string // further databases of the recording (-concat)
+
+	Key             string
+	OpenMode        string
+	Salvage         bool // read what is readable of damaged databases
+	TimeEpoch       string
+	Range           timeRange // of the samples read, by -from and -to
+	Dedup           string
+	DriftCorrection bool // times are corrected by the clock syncs recorded
+	Name            string
+	Outputs         map[int]string // output file of each signal
+	Format          format
+	Stdout          bool
+	Delimiter       rune
+	Compression     *compression
+	Level           int
+	SplitBy         string
+	SegmentGap      time.Duration // longest gap within a session written to a file, 0 if not split
+	Epoch           time.Duration // length of the epochs summarized instead of the samples, if set
+	EpochStats      []string
+	Columns         []string
+	SampleIndex     bool               // rows are numbered by a sample_index column
+	Resample        map[string]float64 // sample rates by signal name, "" for all
+	ResampleMethod  string
+	Downsample      map[string]float64 // factors by signal name, "" for all
+	Filter          *filterSpec        // of the ECG by -filter and -notch, if set
+	FilterOutput    string
+	AccelMagnitude  bool
+	Smooth          time.Duration // window of the moving average of the accel, 0 if not written
+	Signals         []int
+	All             bool // signals are those recorded in the input
+	DataPackage     bool
+	CSVW            bool
+	Metadata        string // format of the session tables written, if set
+	Events          bool
+	RR              bool
+	Gaps            bool
+	Histogram       bool
+	Artifacts       bool
+	SQI             bool
+	Posture         bool
+	PostureEpoch    time.Duration
+	PostureAxes     postureAxes
+	Steps           bool
+	NonWear         time.Duration // shortest run of seconds not worn, 0 if not found
+	Beats           bool          // R peaks are detected in the ECG
+	PSD             time.Duration // frame of the spectral density of the ECG, 0 if not written
+	PSDFormat       string
+	HRV             bool
+	HRVWindow       time.Duration // 0 for the whole recording
+	Calibration     calibrations  // of the signals by device, by -calibration
+	Normalize       string        // mode of -normalize, "" if not normalized
+	ByDevice        bool
+	Device          string                 // id of the device converted, with -by-device
+	Markers         []event                // events of the input, read for -events
+	Patient         edfPatient             // of the session tables, read for EDF+ and BDF+
+	Counts          map[int]*int64         // samples written per signal, if set
+	Rates           map[int]*[]secondCount // samples written per second and signal, if set
+}
+
 type Ecg struct {
-	OriginalTimestamp string  `csv:"time"`
-	Ztime             int64   `db:"timestamp" csv:"timestamp"`
-	ZFokTimestamp     int64   `db:"zfok_timestamp" csv:"z_fok_timestamp"`
-	Zvalue            float64 `db:"value" csv:"value"`
-	DetailedTimestamp string  `csv:"detailed_timestamp"`
+	OriginalTimestamp string    `csv:"time" json:"time"`
+	Ztime             int64     `db:"timestamp" csv:"timestamp" json:"timestamp"`
+	ZFokTimestamp     int64     `db:"zfok_timestamp" csv:"z_fok_timestamp" json:"z_fok_timestamp"`
+	Zvalue            float64   `db:"value" csv:"value" json:"value"`
+	DetailedTimestamp string    `csv:"detailed_timestamp" json:"detailed_timestamp"`
+	Detailed          time.Time `db:"-" csv:"-" json:"-"`
 }
 
 type Accel struct {
-	OriginalTimestamp string  `csv:"time"`
-	Ztime             int64   `db:"timestamp" csv:"timestamp"`
-	ZFokTimestamp     int64   `db:"zfok_timestamp" csv:"z_fok_timestamp"`
-	X                 float64 `csv:"x"`
-	Y                 float64 `csv:"y"`
-	Z                 float64 `db:"value" csv:"z"`
-	DetailedTimestamp string  `csv:"detailed_timestamp"`
+	OriginalTimestamp string    `csv:"time" json:"time"`
+	Ztime             int64     `db:"timestamp" csv:"timestamp" json:"timestamp"`
+	ZFokTimestamp     int64     `db:"zfok_timestamp" csv:"z_fok_timestamp" json:"z_fok_timestamp"`
+	X                 axisValue `csv:"x" json:"x"`
+	Y                 axisValue `csv:"y" json:"y"`
+	Z                 axisValue `db:"value" csv:"z" json:"z"`
+	DetailedTimestamp string    `csv:"detailed_timestamp" json:"detailed_timestamp"`
+	Detailed          time.Time `db:"-" csv:"-" json:"-"`
+}
+
+// axisValue is the value of an axis of a sample, NaN if the sample has
+// none (-incomplete nan). NaN is written as an empty csv field and as
+// null in JSON.
+type axisValue float64
+
+func (v axisValue) MarshalCSV() (string, error) {
+	if math.IsNaN(float64(v)) {
+		return "", nil
+	}
+	return strconv.FormatFloat(float64(v), 'g', -1, 64), nil
+}
+
+func (v axisValue) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(v)) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(v))
+}
+
+// Spo2 is a pulse oximetry sample with the signal quality recorded for
+// it, nil if there is none.
+type Spo2 struct {
+	OriginalTimestamp string    `csv:"time" json:"time"`
+	Ztime             int64     `csv:"timestamp" json:"timestamp"`
+	ZFokTimestamp     int64     `csv:"z_fok_timestamp" json:"z_fok_timestamp"`
+	Zvalue            float64   `csv:"value" json:"value"`
+	Quality           *float64  `csv:"quality" json:"quality"`
+	DetailedTimestamp string    `csv:"detailed_timestamp" json:"detailed_timestamp"`
+	Detailed          time.Time `csv:"-" json:"-"`
+}
+
+// Sample is a single channel value of the combined long format.
+type Sample struct {
+	OriginalTimestamp string    `csv:"time" json:"time"`
+	Ztime             int64     `csv:"timestamp" json:"timestamp"`
+	ZFokTimestamp     int64     `csv:"z_fok_timestamp" json:"z_fok_timestamp"`
+	Signal            string    `csv:"signal" json:"signal"`
+	Value             float64   `csv:"value" json:"value"`
+	DetailedTimestamp string    `csv:"detailed_timestamp" json:"detailed_timestamp"`
+	Detailed          time.Time `csv:"-" json:"-"`
 }
 
 func main() {
 	defer func() { os.Exit(ExitCode) }()
 
-	vital, ecgf, accelf := parseCommandLine()
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			cmd(args[1:])
+			return
+		}
+	}
+	exportCommand(args)
+}
 
-	db, err := sqlx.Connect("sqlite3", vital)
-	checkError("Open input file", err)
-	defer db.Close()
+// exportCommand converts the inputs given by args, the options and
+// inputs of export.
+func exportCommand(args []string) {
+	opts := parseCommandLine(args)
+	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+	if opts.Watch != "" {
+		opts.Batch = true
+		watch(opts.Watch, opts)
+		return
+	}
+	if opts.Manifest != "" {
+		opts.Batch = true
+		if err := runManifest(opts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if opts.Concat {
+		o := opts.forInput(opts.Inputs[0])
+		o.Merged = opts.Inputs[1:]
+		run(o, "")
+		return
+	}
 
-	stmt, err := db.PrepareNamed(SQL_STATEMENT)
-	checkError("Prepare statement", err)
-	defer stmt.Close()
+	// Archives may hold several databases.
+	opts.Batch = len(opts.Inputs) > 1 || isArchive(opts.Inputs[0])
+	for _, input := range opts.Inputs {
+		if input == STDIN_INPUT {
+			// SQLite can only open files, so stdin is copied to a
+			// temporary file first.
+			err := extract(STDIN_NAME+VITAL_FILE_EXT, os.Stdin, func(name, vital string) {
+				o := opts.forInput(name)
+				o.Vital = vital
+				run(o, "")
+			})
+			if err != nil {
+				log.Print("Read stdin: ", err)
+				ExitCode = 1
+			}
+			continue
+		}
+		label := ""
+		if opts.Batch {
+			label = input
+		}
+		if fetch := fetcherOf(input); fetch != nil {
+			err := fetch(input, func(name, vital string) {
+				o := opts.forInput(name)
+				o.Vital = vital
+				run(o, label)
+			})
+			if err != nil {
+				log.SetPrefix(input + ": ")
+				log.Print("Download: ", err)
+				ExitCode = 1
+			}
+			continue
+		}
+		if !isArchive(input) || isPolar(input) {
+			run(opts.forInput(input), label)
+			continue
+		}
+		err := eachArchived(input, func(name, vital string) {
+			o := opts.forInput(name)
+			o.Vital = vital
+			run(o, input+":"+name)
+		})
+		if err != nil {
+			log.SetPrefix(input + ": ")
+			log.Print("Read archive: ", err)
+			ExitCode = 1
+		}
+	}
+}
 
-	ecg, err := os.OpenFile(ecgf, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	checkError("Open output file(ECG)", err)
-	defer ecg.Close()
+// result is the outcome of the conversion of one input.
+type result struct {
+	skipped bool
+	err     error              // first error, nil if converted
+	rows    map[int]int64      // samples written per signal
+	rates   map[int]sampleRate // sample rate detected per signal
+}
 
-	accel, err := os.OpenFile(accelf, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	checkError("Open output file(Accel)", err)
-	defer accel.Close()
+// runError holds the first error of the running conversion, recorded by
+// checkError.
+var runError struct {
+	sync.Mutex
+	err error
+}
+
+// run converts a vital database, prefixing log messages with label.
+// checkError ends the goroutine of a failed conversion, so that the
+// remaining inputs are still converted.
+func run(opts Options, label string) result {
+	if label != "" {
+		log.SetPrefix(label + ": ")
+	}
+	if opts.ByDevice && opts.Device == "" {
+		return runDevices(opts, label)
+	}
+	if opts.All {
+		if err := discoverSignals(&opts); err != nil {
+			log.Print("Discover signals: ", err)
+			ExitCode = 1
+			return result{err: err}
+		}
+	}
+	if opts.Batch && !opts.Force && upToDate(&opts) {
+		log.Print("Outputs are up to date, skipped")
+		return result{skipped: true}
+	}
+	// Until it succeeds, the outputs are not those of a conversion done.
+	if checkable(&opts) {
+		os.Remove(donePath(&opts))
+	}
+
+	runError.Lock()
+	runError.err = nil
+	runError.Unlock()
+	opts.Counts = make(map[int]*int64)
+	opts.Rates = make(map[int]*[]secondCount)
+	for _, t := range opts.Signals {
+		opts.Counts[t] = new(int64)
+		opts.Rates[t] = new([]secondCount)
+	}
 
-	// Stmt is a prepared statement. A Stmt is safe for concurrent use
-	// by multiple goroutines.
 	var wg sync.WaitGroup
-	for t, f := range map[int]*os.File{ECG_TYPE: ecg, ACCEL_TYPE: accel} {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		convert(opts)
+	}()
+	wg.Wait()
+
+	r := result{rows: make(map[int]int64)}
+	for t, n := range opts.Counts {
+		r.rows[t] = *n
+	}
+	runError.Lock()
+	r.err = runError.err
+	runError.Unlock()
+	if r.err == nil {
+		r.rates = detectRates(&opts)
+		if opts.Batch && checkable(&opts) {
+			if err := markDone(&opts); err != nil {
+				log.Print("Write state: ", err)
+			}
+		}
+	}
+	return r
+}
+
+// runDevices converts the rows of each device of a vital database synced
+// from several (-by-device), to outputs named <name>.device-<id>.
+func runDevices(opts Options, label string) result {
+	fail := func(err error) result {
+		log.Print("Read devices: ", err)
+		ExitCode = 1
+		return result{err: err}
+	}
+	src, err := openSource(&opts)
+	if err != nil {
+		return fail(err)
+	}
+	vs, ok := src.(*vitalSource)
+	if !ok {
+		src.Close()
+		return fail(fmt.Errorf("Input has no devices: %s", opts.Vital))
+	}
+	devices, err := vs.devices()
+	src.Close()
+	if err != nil {
+		return fail(err)
+	}
+	if len(devices) == 0 {
+		return fail(fmt.Errorf("No devices recorded"))
+	}
+
+	all := result{skipped: true, rows: make(map[int]int64), rates: make(map[int]sampleRate)}
+	most := make(map[int]int64)
+	for _, d := range devices {
+		o := opts.named(opts.Vital, opts.Name+DEVICE_NAME_PREFIX+deviceName(d))
+		o.Device = d
+		r := run(o, label)
+		all.skipped = all.skipped && r.skipped
+		if all.err == nil {
+			all.err = r.err
+		}
+		for t, n := range r.rows {
+			// The rate is that of the device with the most samples.
+			if rate, ok := r.rates[t]; ok && n > most[t] {
+				all.rates[t], most[t] = rate, n
+			}
+			all.rows[t] += n
+		}
+	}
+	return all
+}
+
+// deviceName replaces the characters of a device id that are not safe
+// in file names.
+func deviceName(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+func convert(opts Options) {
+	src, err := openSource(&opts)
+	checkError("Open input file", err)
+	defer src.Close()
+	if deviceZone {
+		checkError("Read time zone", setDeviceZone(src))
+	}
+	layout := timeLayout
+	defer func() { timeLayout = layout }()
+	timeLayout, err = dstSafeLayout(src)
+	checkError("Read times", err)
+	if opts.Calibration != nil {
+		checkError("Read calibration", setCalibration(src, &opts))
+	}
+	vs, vital := src.(*vitalSource)
+	if vital && opts.DriftCorrection {
+		checkError("Read clock syncs", vs.setDriftCorrection())
+	}
+	if opts.Normalize != "" {
+		setNormalization(src, &opts)
+	}
+	if vital && opts.Events {
+		opts.Markers, err = vs.events()
+		checkError("Read events", err)
+	}
+	if vital && opts.Format.patient {
+		opts.Patient, err = vs.patient()
+		checkError("Read patient", err)
+	}
+
+	// Single file formats share one encoder between all signals.
+	encs := make(map[int]encoder)
+	var (
+		shared encoder
+		files  []io.Closer
+	)
+	for _, t := range opts.Signals {
+		path, label := opts.Outputs[t], signalTypes[t].label
+		switch {
+		case shared != nil:
+			encs[t] = shared
+		case opts.split():
+			encs[t] = newSplitEncoder(path, &opts)
+		default:
+			var w io.Writer = os.Stdout
+			if !opts.Stdout {
+				f, err := createOutput(path)
+				checkError("Open output file("+label+")", err)
+				defer f.Close()
+				files = append(files, f)
+				w = f
+			}
+			encs[t], err = newEncoder(w, &opts)
+			checkError("Open output("+label+")", err)
+		}
+		if opts.Format.single {
+			shared = encs[t]
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		qrs     *qrsDetector
+		posture *postureEncoder
+		steps   *stepEncoder
+		psd     *psdEncoder
+	)
+	// Sparse signals, sampled every few seconds, have no artifacts found.
+	artifacts := make(map[int]*artifactEncoder)
+	// The quality is of the ECG and of signals of three axes.
+	sqis := make(map[int]*sqiEncoder)
+	stillness := make(map[int]*stillnessEncoder)
+	intervals := make(map[int]*intervalEncoder)
+	for t := range encs {
+		s := signalTypes[t]
+		if opts.Artifacts && !s.sparse {
+			artifacts[t] = &artifactEncoder{}
+		}
+		if opts.SQI && !s.sparse && (t == ECG_TYPE || s.axes == 3) {
+			sqis[t] = &sqiEncoder{}
+		}
+		if opts.NonWear > 0 && (t == ECG_TYPE || t == ACCEL_TYPE) {
+			stillness[t] = &stillnessEncoder{}
+		}
+		if opts.Histogram {
+			intervals[t] = &intervalEncoder{}
+		}
+	}
+	for t, enc := range encs {
 		wg.Add(1)
-		go func(t int, f *os.File) {
+		go func(t int, enc encoder) {
 			defer wg.Done()
-			query(stmt, t, f)
-		}(t, f)
+			if opts.SampleIndex {
+				enc = &indexEncoder{encoder: enc}
+			}
+			// Encoders holding samples back are flushed from the
+			// outermost, as it passes them on to the others.
+			var flushers []interface{ flush(*signal) error }
+			s := signalTypes[t]
+			if t == ECG_TYPE && opts.Filter != nil {
+				// The filter is passed the samples resampled.
+				rate := s.rate
+				if hz := signalValue(s, opts.Resample); hz > 0 {
+					rate = hz
+				} else if n := signalValue(s, opts.Downsample); n > 1 {
+					rate /= n
+				}
+				e := newFilterEncoder(enc, opts.Filter, rate, opts.FilterOutput)
+				flushers, enc = append(flushers, e), e
+			}
+			if t == ACCEL_TYPE && opts.AccelMagnitude {
+				enc = &magnitudeEncoder{enc}
+			}
+			if t == ACCEL_TYPE && opts.Smooth > 0 {
+				enc = &smoothEncoder{encoder: enc, window: opts.Smooth}
+			}
+			if n := opts.Counts[t]; n != nil {
+				enc = &countingEncoder{enc, n}
+			}
+			if hz := signalValue(s, opts.Resample); hz > 0 {
+				e := newResampleEncoder(enc, hz, opts.ResampleMethod)
+				flushers, enc = append(flushers, e), e
+			}
+			if n := signalValue(s, opts.Downsample); n > 1 {
+				e := newDownsampleEncoder(enc, int(n))
+				flushers, enc = append(flushers, e), e
+			}
+			if counts := opts.Rates[t]; counts != nil {
+				enc = &rateEncoder{enc, counts}
+			}
+			if i := intervals[t]; i != nil {
+				i.encoder, enc = enc, i
+			}
+			a := artifacts[t]
+			if a != nil {
+				a.encoder, enc = enc, a
+			}
+			q := sqis[t]
+			if q != nil {
+				q.encoder, enc = enc, q
+			}
+			w := stillness[t]
+			if w != nil {
+				w.encoder, enc = enc, w
+			}
+			var p *postureEncoder
+			if t == ACCEL_TYPE && opts.Posture {
+				p = &postureEncoder{encoder: enc, length: opts.PostureEpoch}
+				enc, posture = p, p
+			}
+			var st *stepEncoder
+			if t == ACCEL_TYPE && opts.Steps {
+				st = &stepEncoder{encoder: enc}
+				enc, steps = st, st
+			}
+			var ps *psdEncoder
+			if t == ECG_TYPE && opts.PSD > 0 {
+				ps = newPSDEncoder(enc, opts.PSD, s.rate)
+				enc, psd = ps, ps
+			}
+			var d *qrsDetector
+			if t == ECG_TYPE && opts.Beats {
+				d = newQRSDetector(enc, s.rate)
+				enc, qrs = d, d
+			}
+			if opts.Range.bounded() {
+				enc = &rangeEncoder{enc, opts.Range}
+			}
+			query(src, t, enc)
+			if d != nil {
+				d.flush()
+			}
+			if ps != nil {
+				ps.flush()
+			}
+			if st != nil {
+				st.flush()
+			}
+			if p != nil {
+				p.flush()
+			}
+			if w != nil {
+				w.flush(s)
+			}
+			if q != nil {
+				q.flush(s)
+			}
+			if a != nil {
+				a.flush(s)
+			}
+			for i := len(flushers) - 1; i >= 0; i-- {
+				checkError("Write", flushers[i].flush(s))
+			}
+			// Encoders shared by the signals may hold back the samples
+			// of the others until this one is written.
+			if f, ok := encs[t].(interface{ finish(*signal) error }); ok {
+				checkError("Write", f.finish(s))
+			}
+		}(t, enc)
 	}
 	wg.Wait()
+
+	closed := make(map[encoder]bool)
+	for _, enc := range encs {
+		if !closed[enc] {
+			closed[enc] = true
+			checkError("Flush output", enc.Close())
+		}
+	}
+	// Objects are only stored once closed.
+	for _, f := range files {
+		checkError("Close output", f.Close())
+	}
+
+	paths := outputPaths(&opts, encs)
+	if opts.DataPackage {
+		checkError("Write datapackage", writeDataPackage(&opts, paths))
+	}
+	if opts.CSVW {
+		checkError("Write CSVW metadata", writeCSVW(&opts, paths))
+	}
+	if vital && opts.Metadata != "" {
+		checkError("Write metadata", writeSessionTables(vs, &opts))
+	}
+	if vital && opts.Events {
+		checkError("Write events", writeEvents(&opts))
+	}
+	if hasRaw(&opts) && !opts.Stdout {
+		checkError("Write calibration", writeRawCalibration(&opts))
+	}
+	if vital && opts.RR {
+		checkError("Write RR intervals", writeBeats(vs, &opts))
+	}
+	if qrs != nil {
+		checkError("Write beats", writeDetectedBeats(qrs.beats, &opts))
+	}
+	// The heart rate variability is of the beats detected, or else of
+	// those recorded.
+	if opts.HRV && (qrs != nil || vital) {
+		var beats []beat
+		if qrs != nil {
+			beats = qrs.beats
+		} else {
+			beats, err = vs.beats()
+			checkError("Read beats", err)
+		}
+		checkError("Write HRV", writeHRV(beats, opts.HRVWindow, &opts))
+	}
+	if opts.Artifacts {
+		checkError("Write artifacts", writeArtifacts(artifacts, &opts))
+	}
+	if opts.NonWear > 0 {
+		checkError("Write non-wear", writeNonWear(stillness, opts.NonWear, &opts))
+	}
+	if psd != nil {
+		checkError("Write PSD", writePSD(psd, opts.PSDFormat, &opts))
+	}
+	if steps != nil {
+		checkError("Write steps", writeSteps(steps, &opts))
+	}
+	if posture != nil {
+		checkError("Write posture", writePosture(posture, opts.PostureAxes, &opts))
+	}
+	if opts.SQI {
+		checkError("Write SQI", writeSQI(sqis, &opts))
+	}
+	if opts.Histogram && opts.Rates != nil {
+		checkError("Write histogram", writeHistogram(intervals, &opts))
+	}
+	if opts.Gaps && opts.Rates != nil {
+		checkError("Write gaps", writeGaps(&opts))
+	}
 }
 
-func query(stmt *sqlx.NamedStmt, t int, f *os.File) {
-	rows := queryVital(stmt, t)
+// outputPaths returns the files written for every signal.
+func outputPaths(opts *Options, encs map[int]encoder) map[int][]string {
+	paths := make(map[int][]string)
+	for t, enc := range encs {
+		switch {
+		case opts.split():
+			paths[t] = enc.(*splitEncoder).paths
+		default:
+			paths[t] = []string{opts.Outputs[t]}
+		}
+	}
+	return paths
+}
+
+func query(src source, t int, enc encoder) {
+	s := signalTypes[t]
+	rs, err := src.query(t)
+	checkError("Query", err)
+	rows := newOrderRows(rs, outOfOrderPolicy, s.label)
 	defer rows.Close()
 
-	switch t {
-	case ECG_TYPE:
-		queryECG(rows, f)
-	case ACCEL_TYPE:
-		queryAcceleration(rows, f)
+	switch {
+	case s.axes != 1:
+		queryTriplets(s, rows, enc)
+	case s.hasQuality():
+		qs, err := src.query(s.quality)
+		checkError("Query", err)
+		quality := newOrderRows(qs, outOfOrderPolicy, s.label+" quality")
+		defer quality.Close()
+		querySpO2(s, rows, quality, enc)
+	case s.sparse:
+		querySparse(s, rows, enc)
+	default:
+		queryECG(s, rows, enc)
 	}
 }
 
-func queryECG(rows *sqlx.Rows, f *os.File) {
+// queryECG reads a signal of one channel, such as ECG or HR. The samples
+// are interpolated over the span of the signal, or up to a gap.
+func queryECG(s *signal, rows rowScanner, enc encoder) {
 	var begin int64
+	seconds := 0
 	es := make([]Ecg, 0, 200)
 
-	gocsv.MarshalFile(&es, f) // Write header
+	checkError("Write header", enc.Header(s, &es))
 	for rows.Next() {
 		e := Ecg{}
 		err := rows.StructScan(&e)
 		checkError("Scan", err)
 		if begin < e.Ztime {
 			if begin > 0 {
-				interpolation(es, e.Ztime)
-				gocsv.MarshalWithoutHeaders(&es, f)
-				es = es[:0]
+				seconds++
+				if seconds >= max(s.span, 1) || e.Ztime != begin+1 {
+					interpolation(s, es, e.Detailed)
+					encodeSeconds(s, enc, es)
+					es, seconds = es[:0], 0
+				}
 			}
 			begin = e.Ztime
 		}
-		e.OriginalTimestamp = time.Unix(e.Ztime, 0).Local().Format("2006-01-02 15:04:05")
+		e.Zvalue = s.normalizedValue(0, s.value(s.calibrate(0, e.Ztime, e.Zvalue)))
+		e.OriginalTimestamp = timeLayout.formatTime(time.Unix(e.Ztime, 0))
 		es = append(es, e)
 	}
+	checkError("Read", rows.Err())
+
+	// The last second has no end to interpolate to and is left out, but
+	// the seconds of the span before it are not.
+	last := len(es)
+	for last > 0 && es[last-1].Ztime == begin {
+		last--
+	}
+	if last > 0 {
+		interpolation(s, es[:last], es[last].Detailed)
+		encodeSeconds(s, enc, es[:last])
+	}
 }
 
-func queryAcceleration(rows *sqlx.Rows, f *os.File) {
+// querySparse reads a signal of one channel sampled every few seconds.
+// Its samples are written at their own time instead of being spread
+// over the second, and the last one is written too.
+func querySparse(s *signal, rows rowScanner, enc encoder) {
+	es := make([]Ecg, 0, 1)
+
+	checkError("Write header", enc.Header(s, &es))
+	for rows.Next() {
+		e := Ecg{}
+		err := rows.StructScan(&e)
+		checkError("Scan", err)
+		if len(es) > 0 && es[0].Ztime != e.Ztime {
+			checkError("Write", enc.Encode(s, &es))
+			es = es[:0]
+		}
+		e.Zvalue = s.normalizedValue(0, s.value(s.calibrate(0, e.Ztime, e.Zvalue)))
+		e.OriginalTimestamp = timeLayout.formatTime(time.Unix(e.Ztime, 0))
+		e.DetailedTimestamp = timeLayout.formatDetailed(e.Detailed)
+		es = append(es, e)
+	}
+	checkError("Read", rows.Err())
+	if len(es) > 0 {
+		checkError("Write", enc.Encode(s, &es))
+	}
+}
+
+// encodeSeconds encodes the samples of es one second per call.
+func encodeSeconds(s *signal, enc encoder, es []Ecg) {
+	for i := 0; i < len(es); {
+		j := i + 1
+		for j < len(es) && es[j].Ztime == es[i].Ztime {
+			j++
+		}
+		sec := es[i:j]
+		checkError("Write", enc.Encode(s, &sec))
+		i = j
+	}
+}
+
+// querySpO2 reads a signal of one channel with the signal quality of its
+// samples, the quality rows of the same time and zfok_timestamp.
+func querySpO2(s *signal, rows, quality rowScanner, enc encoder) {
 	var (
 		begin int64
-		a     [3]Accel
+		q     vitalRow
+	)
+	more := quality.Next()
+	if more {
+		checkError("Scan", quality.StructScan(&q))
+	}
+	ss := make([]Spo2, 0, 200)
+
+	checkError("Write header", enc.Header(s, &ss))
+	for rows.Next() {
+		var r vitalRow
+		checkError("Scan", rows.StructScan(&r))
+		for more && q.before(&r) {
+			if more = quality.Next(); more {
+				checkError("Scan", quality.StructScan(&q))
+			}
+		}
+		e := Spo2{Ztime: r.Ztime, ZFokTimestamp: r.ZFokTimestamp, Zvalue: s.normalizedValue(0, s.value(s.calibrate(0, r.Ztime, r.Value))), Detailed: time.Unix(r.Ztime, r.Nanos)}
+		if more && q.Ztime == r.Ztime && q.Nanos == r.Nanos && q.ZFokTimestamp == r.ZFokTimestamp {
+			v := round(q.Value)
+			e.Quality = &v
+		}
+		if begin < e.Ztime {
+			if begin > 0 {
+				interpolation(s, ss, e.Detailed)
+				checkError("Write", enc.Encode(s, &ss))
+				ss = ss[:0]
+			}
+			begin = e.Ztime
+		}
+		e.OriginalTimestamp = timeLayout.formatTime(time.Unix(e.Ztime, 0))
+		ss = append(ss, e)
+	}
+	checkError("Read", rows.Err())
+	checkError("Read", quality.Err())
+}
+
+// queryTriplets reads a signal of three axes, such as acceleration,
+// angular velocity or magnetic field, whose samples are stored as x, y, z
+// rows. Each sample is assembled into one Accel row.
+//
+// Databases number the rows of a second consecutively (x, y, z, x, ...),
+// so the axis of a row follows from its z_fok_timestamp relative to the
+// first row of the second, and a dropped row leaves its sample
+// incomplete instead of shifting the axes of the rows after it. Other
+// sources number the samples, whose rows share a z_fok_timestamp in axis
+// order. Incomplete samples are written as set by -incomplete, and rows
+// repeating an axis are left out; both are logged.
+func queryTriplets(s *signal, rows rowScanner, enc encoder) {
+	var (
+		begin, base int64
+		shared      bool // rows of a sample share their z_fok_timestamp
+		n           int  // rows read
+		cur         triplet
+		last        = [3]float64{math.NaN(), math.NaN(), math.NaN()}
+		incomplete  int
+		first       time.Time // of the first incomplete sample
+		repeated    int
 	)
-	l, idx := len(a), 0
 	as := make([]Accel, 0, 200)
 
-	gocsv.MarshalFile(&as, f) // Write header
+	flush := func() {
+		if cur.rows == 0 {
+			return
+		}
+		defer func() { cur = triplet{} }()
+		if !cur.complete() {
+			if incomplete == 0 {
+				first = cur.first.Detailed
+			}
+			incomplete++
+			if incompletePolicy == "drop" {
+				return
+			}
+			for i, set := range cur.set {
+				if !set {
+					cur.v[i] = math.NaN()
+					if incompletePolicy == "hold" {
+						cur.v[i] = last[i]
+					}
+				}
+			}
+		}
+		last = cur.v
+		as = append(as, cur.sample(s))
+	}
+
+	checkError("Write header", enc.Header(s, &as))
 	for rows.Next() {
-		err := rows.StructScan(&a[idx])
+		var a Accel
+		err := rows.StructScan(&a)
 		checkError("Scan", err)
-		if idx < l-1 {
-			idx++
-			continue
+		if n == 1 {
+			shared = a.ZFokTimestamp == cur.first.ZFokTimestamp
 		}
-		idx = 0
+		n++
 
-		ztime := a[0].Ztime
-		if begin < ztime {
-			if begin > 0 {
-				interpolation(as, ztime)
-				gocsv.MarshalWithoutHeaders(&as, f)
+		if begin < a.Ztime {
+			flush()
+			if begin > 0 && len(as) > 0 {
+				interpolation(s, as, a.Detailed)
+				checkError("Write", enc.Encode(s, &as))
 				as = as[:0]
 			}
-			begin = ztime
+			begin, base = a.Ztime, a.ZFokTimestamp
 		}
 
-		as = append(as, Accel{
-			X: a[0].Z, Y: a[1].Z, Z: a[2].Z,
-			OriginalTimestamp: time.Unix(ztime, 0).Local().Format("2006-01-02 15:04:05"),
-			Ztime:             ztime,
-			ZFokTimestamp:     a[0].ZFokTimestamp,
-		})
+		key, axis := a.ZFokTimestamp, cur.rows
+		if !shared {
+			key, axis = (a.ZFokTimestamp-base)/3, int((a.ZFokTimestamp-base)%3)
+		}
+		if cur.rows > 0 && key != cur.key {
+			flush()
+			if shared {
+				axis = 0
+			}
+		}
+		if axis > 2 || cur.set[axis] {
+			repeated++
+			continue
+		}
+		if cur.rows == 0 {
+			cur.key, cur.first = key, a
+			cur.first.ZFokTimestamp = a.ZFokTimestamp - int64(axis)
+			if shared {
+				cur.first.ZFokTimestamp = a.ZFokTimestamp
+			}
+		}
+		cur.v[axis], cur.set[axis] = float64(a.Z), true
+		cur.rows++
+	}
+	checkError("Read", rows.Err())
+	flush()
+
+	if incomplete > 0 {
+		log.Printf("%s: %d samples with missing axes (%s), the first at %s",
+			s.label, incomplete, incompletePolicy, timeLayout.formatDetailed(first))
+	}
+	if repeated > 0 {
+		log.Printf("%s: %d rows repeating an axis left out", s.label, repeated)
+	}
+}
+
+// triplet is a sample of three axes being assembled from its rows.
+type triplet struct {
+	key   int64
+	rows  int
+	v     [3]float64
+	set   [3]bool
+	first Accel // first row read, with the z_fok_timestamp of the sample
+}
+
+func (t *triplet) complete() bool {
+	return t.set[0] && t.set[1] && t.set[2]
+}
+
+func (t *triplet) sample(s *signal) Accel {
+	return Accel{
+		X:                 axisValue(s.normalizedValue(0, s.value(s.calibrate(0, t.first.Ztime, t.v[0])))),
+		Y:                 axisValue(s.normalizedValue(1, s.value(s.calibrate(1, t.first.Ztime, t.v[1])))),
+		Z:                 axisValue(s.normalizedValue(2, s.value(s.calibrate(2, t.first.Ztime, t.v[2])))),
+		OriginalTimestamp: timeLayout.formatTime(time.Unix(t.first.Ztime, 0)),
+		Ztime:             t.first.Ztime,
+		ZFokTimestamp:     t.first.ZFokTimestamp,
+		Detailed:          t.first.Detailed,
+	}
+}
+
+// round rounds v to the number of decimal places set by -precision, so
+// that text formats write the shortest representation.
+func round(v float64) float64 {
+	if precision < 0 {
+		return v
 	}
+	p := math.Pow10(precision)
+	return math.Round(v*p) / p
 }
 
-func interpolation(v interface{}, end int64) {
+// interpolation sets the detailed times of the samples of v, which span
+// the seconds from the first up to end, by -interpolation.
+func interpolation(s *signal, v interface{}, end time.Time) {
 	rv := reflect.ValueOf(v)
 	l := rv.Len()
-	begin := rv.Index(0).FieldByName("Ztime").Int()
-	period := float64((end - begin) * 1E+9)
-	lf := float64(l)
+	begin := rv.Index(0).FieldByName("Detailed").Interface().(time.Time)
+	set := func(i int, t time.Time) {
+		rv.Index(i).FieldByName("Detailed").Set(reflect.ValueOf(t))
+		rv.Index(i).FieldByName("DetailedTimestamp").SetString(timeLayout.formatDetailed(t))
+	}
+
+	switch strategy := interpolationStrategy; {
+	case strategy == "none":
+		for i := 0; i < l; i++ {
+			set(i, rv.Index(i).FieldByName("Detailed").Interface().(time.Time))
+		}
+	case strategy == "zfok" || strategy == "timestamp":
+		spacing := zfokSpacing
+		if strategy == "timestamp" {
+			spacing = zfokTimes
+		}
+		for i := 0; i < l; {
+			j := i + 1
+			for j < l && rv.Index(j).FieldByName("Ztime").Int() == rv.Index(i).FieldByName("Ztime").Int() {
+				j++
+			}
+			spacing(s, rv.Slice(i, j), set, i)
+			i = j
+		}
+	default:
+		period := float64(end.Sub(begin))
+		lf := float64(l)
+		if s.rate > 0 && strategy != "spread" {
+			period = lf * float64(time.Second) / s.rate
+		}
+		for i := 0; i < l; i++ {
+			set(i, begin.Add(time.Duration(float64(i)*period/lf)))
+		}
+	}
+}
+
+// zfokTimes places the samples of a second by their z_fok_timestamp taken
+// for the time they were sampled, in units of -zfok-scale: the first at
+// the time of the second and the others after it by the difference of
+// their times, so that the intervals between them are those recorded
+// rather than even. Seconds whose times decrease or do not fit in the
+// second are spread evenly.
+func zfokTimes(s *signal, sec reflect.Value, set func(int, time.Time), offset int) {
+	l := sec.Len()
+	begin := sec.Index(0).FieldByName("Detailed").Interface().(time.Time)
+	zfok := func(i int) int64 { return sec.Index(i).FieldByName("ZFokTimestamp").Int() }
+	fit := true
+	for i := 1; i < l; i++ {
+		d := time.Duration(zfok(i)-zfok(0)) * zfokScale
+		if zfok(i) < zfok(i-1) || d >= time.Second {
+			fit = false
+			break
+		}
+	}
 	for i := 0; i < l; i++ {
-		rv.Index(i).FieldByName("DetailedTimestamp").SetString(
-			time.Unix(begin, int64(float64(i)*period/lf)).Local().Format("2006-01-02 15:04:05.000000000"))
+		d := time.Duration(zfok(i)-zfok(0)) * zfokScale
+		if !fit {
+			d = time.Duration(i) * time.Second / time.Duration(l)
+		}
+		set(offset+i, begin.Add(d))
 	}
 }
 
-func queryVital(stmt *sqlx.NamedStmt, ztype int) *sqlx.Rows {
-	rows, err := stmt.Queryx(map[string]interface{}{"ztype": ztype})
-	checkError("Query", err)
-	return rows
+// zfokSpacing places the samples of a second by their z_fok_timestamp,
+// the sequence number of the sample, so that samples dropped leave their
+// place empty instead of stretching the others. The numbers step by the
+// smallest difference between them, of which there are as many in the
+// second as the sample rate of s, or as numbers from the first to the
+// last if it is unknown. Seconds whose numbers do not increase are
+// spread evenly.
+func zfokSpacing(s *signal, sec reflect.Value, set func(int, time.Time), offset int) {
+	l := sec.Len()
+	begin := sec.Index(0).FieldByName("Detailed").Interface().(time.Time)
+	zfok := func(i int) int64 { return sec.Index(i).FieldByName("ZFokTimestamp").Int() }
+	var step int64
+	for i := 1; i < l; i++ {
+		d := zfok(i) - zfok(i-1)
+		if d <= 0 {
+			step = 0
+			break
+		}
+		if step == 0 || d < step {
+			step = d
+		}
+	}
+	steps := float64(l)
+	if step > 0 {
+		steps = float64((zfok(l-1)-zfok(0))/step + 1)
+	}
+	if s.rate > 0 {
+		steps = s.rate
+	}
+	for i := 0; i < l; i++ {
+		n := float64(i)
+		if step > 0 {
+			n = float64((zfok(i) - zfok(0)) / step)
+		}
+		set(offset+i, begin.Add(time.Duration(n*float64(time.Second)/steps)))
+	}
 }
 
-func parseCommandLine() (string, string, string) {
+func parseCommandLine(args []string) Options {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `
 Usage of %s:
-  %s [options] vital_data
-`, path.Base(os.Args[0]), os.Args[0])
+  %s [export] [options] vital_data|directory|archive|url|-...
+  %s info [options] vital_data|directory|archive|url|-...
+  %s validate [options] vital_data|directory|archive|url|-...
+  %s list-types [options]
+  %s version
+
+
+`, path.Base(os.Args[0]), os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\n")
 	}
 
-	var d string
+	var (
+		d, f, delim, c, split, cols, tf, only, resample, resampleMethod, downsample, filter, filterOutput, notch, accelUnit, ecgUnit, epochStats, from, to, tz, dedup, postureAxes, key, keyFile, mode, watchDir, epoch, manifest, schema, signalsFile, calibrationFile, normalize, psdFormat, ztypes, metadata string
+		stdout, combined, datapackage, csvw, concat, force, salvage, verbose, all, events, rr, gaps, histogram, artifacts, sqi, posture, steps, nonWear, beats, hrv, byDevice, sampleIndex, accelMagnitude, merge, driftCorrection, raw, showVersion                                                            bool
+		level                                                                                                                                                                                                                                                                                                   int
+		hrvWindow, segmentGap, epochLength, postureEpoch, nonWearMin, smooth, psd                                                                                                                                                                                                                               time.Duration
+	)
 	flag.StringVar(&d, "d", "", "Output directory for csv data")
 	flag.StringVar(&d, "outDir", "", "Output directory for csv data(long option)")
-	flag.Parse()
+	flag.StringVar(&f, "format", "csv", "Output format("+strings.Join(formatNames(), ", ")+")")
+	flag.BoolVar(&stdout, "stdout", false, "Write to standard output(single file formats, or with -only)")
+	flag.StringVar(&only, "only", "", "Export only one signal("+strings.Join(signalNames(), ", ")+")")
+	flag.StringVar(&delim, "delimiter", ",", `Field delimiter of csv output("\t" for TSV)`)
+	flag.StringVar(&c, "compress", "", "Compress output files(gzip, zstd)")
+	flag.IntVar(&level, "level", 0, "Compression level(0 for the default level)")
+	flag.BoolVar(&combined, "combined", false, "Write both signals to one long format file(csv, jsonl, parquet)")
+	flag.BoolVar(&merge, "merge", false, "Write the ECG and the accel resampled onto one grid(-resample, or the sample rate of the ECG) to one wide csv file *.merged.csv")
+	flag.StringVar(&split, "split-by", "", "Split output files by hour or day")
+	flag.DurationVar(&segmentGap, "segment-gap", 0, "Split output files into sessions at gaps longer than this(e.g. 5m), named by their start time")
+	flag.BoolVar(&byDevice, "by-device", false, "Write the samples of each device of databases synced from several to their own files")
+	flag.StringVar(&cols, "columns", "", "Comma separated columns of csv output in order("+strings.Join(csvColumns(), ", ")+")")
+	flag.BoolVar(&sampleIndex, "sample-index", false, "Number the samples of each signal from 0 in a sample_index column(csv, jsonl)")
+	flag.DurationVar(&epochLength, "epoch", 0, "Write the statistics of the samples of each epoch of this length(e.g. 30s) instead of the samples(csv)")
+	flag.StringVar(&epochStats, "epoch-stats", strings.Join(epochStatistics, ","), "Comma separated statistics of -epoch("+strings.Join(epochStatistics, ", ")+")")
+	flag.BoolVar(&datapackage, "datapackage", false, "Write a Frictionless Data Package descriptor of csv output")
+	flag.BoolVar(&csvw, "csvw", false, "Write CSV on the Web metadata next to csv output")
+	flag.BoolVar(&events, "events", false, "Write the event markers of vital data to *.events.csv, and as annotations of EDF/BDF output")
+	flag.BoolVar(&rr, "rr", false, "Write the RR intervals of the beats detected by the device to *.rr.csv")
+	flag.BoolVar(&beats, "beats", false, "Detect the R peaks of the ECG and write them with the RR intervals and heart rate to *.beats.csv")
+	flag.BoolVar(&hrv, "hrv", false, "Write the heart rate variability of the beats detected(-beats) or recorded to *.hrv.csv")
+	flag.DurationVar(&hrvWindow, "hrv-window", 5*time.Minute, "Window of -hrv(0 for the whole recording)")
+	flag.BoolVar(&artifacts, "artifacts", false, "Write the seconds of each signal with flags of flatline, clipping and motion artifacts to *.artifacts.csv")
+	flag.DurationVar(&psd, "psd", 0, "Write the power spectral density of the ECG by frames of this length(e.g. 30s), by Welch's method, to *.psd.csv or *.psd.npz")
+	flag.StringVar(&psdFormat, "psd-format", "csv", "Format of -psd: "+strings.Join(psdFormats, ", "))
+	flag.BoolVar(&sqi, "sqi", false, "Write a signal quality index of each second of the ECG, by kurtosis and power ratio, and of the accel, by saturation, to *.sqi.csv")
+	flag.BoolVar(&posture, "posture", false, "Write the posture of each epoch of the accel(upright, supine, prone, left, right) to *.posture.csv")
+	flag.DurationVar(&postureEpoch, "posture-epoch", 30*time.Second, "Epoch of -posture")
+	flag.StringVar(&postureAxes, "posture-axes", "x,y,z", "Axes of the accel pointing to the left, the head and the front of the subject, for -posture(e.g. -y,x,z)")
+	flag.BoolVar(&steps, "steps", false, "Write the steps counted in each minute of the accel to *.steps.csv")
+	flag.BoolVar(&nonWear, "non-wear", false, "Write the periods the device is likely not worn, with the accel still and the ECG flat, to *.nonwear.csv")
+	flag.DurationVar(&nonWearMin, "non-wear-min", NONWEAR_MIN, "Shortest period of -non-wear")
+	flag.BoolVar(&gaps, "gaps", false, "Write the runs of seconds without samples of each signal to *.gaps.csv")
+	flag.BoolVar(&histogram, "histogram", false, "Write the distributions of the samples per second and of the intervals between samples of each signal to *.histogram.csv")
+	flag.StringVar(&metadata, "metadata", "", "Write the device and session tables of vital data next to the output(json, csv)")
+	flag.StringVar(&outOfOrderPolicy, "out-of-order", "sort", "Rows whose z_fok_timestamp regresses within their second("+strings.Join(outOfOrderPolicies, ", ")+")")
+	flag.StringVar(&incompletePolicy, "incomplete", "nan", "Samples of three axes missing some("+strings.Join(incompletePolicies, ", ")+")")
+	flag.StringVar(&resample, "resample", "", "Interpolate the samples onto a grid of a sample rate in Hz, for all signals or as comma separated name:rate pairs(e.g. ecg:250)")
+	flag.StringVar(&resampleMethod, "resample-method", "linear", "Interpolation of -resample("+strings.Join(resampleMethods, ", ")+")")
+	flag.StringVar(&downsample, "downsample", "", "Keep every nth sample, low-pass filtered against aliasing, for all signals or as comma separated name:n pairs(e.g. ecg:10)")
+	flag.StringVar(&filter, "filter", "", "Filter the ECG by a fourth order Butterworth filter(bandpass=low-high, highpass=low, lowpass=high in Hz, e.g. bandpass=0.5-40)")
+	flag.StringVar(&notch, "notch", "", "Remove the power line interference of the ECG by a notch filter at the frequency of the grid(50, 60) or of the grid of a region(e.g. eu, us)")
+	flag.StringVar(&filterOutput, "filter-output", "replace", "Write the filtered ECG instead of the value or next to it("+strings.Join(filterOutputs, ", ")+")")
+	flag.BoolVar(&accelMagnitude, "accel-magnitude", false, "Add the vector magnitude and ENMO of the accel in g in magnitude and enmo columns(csv, jsonl)")
+	flag.DurationVar(&smooth, "smooth", 0, "Add the moving average of each axis of the accel over this window(e.g. 1s) in x_smooth, y_smooth and z_smooth columns(csv, jsonl)")
+	flag.StringVar(&interpolationStrategy, "interpolation", "auto", "Spacing of the samples within a second("+strings.Join(interpolationStrategies, ", ")+")")
+	flag.DurationVar(&zfokScale, "zfok-scale", time.Millisecond, "Unit of the z_fok_timestamp with -interpolation timestamp(e.g. 1ms, 100us)")
+	flag.StringVar(&accelUnit, "accel-unit", "", "Unit of the accel written("+strings.Join(acceleration.names(), ", ")+"), converted from the unit recorded")
+	flag.StringVar(&ecgUnit, "ecg-unit", "", "Unit of the ECG written("+strings.Join(voltage.names(), ", ")+", raw for ADC counts by the gain and offset given by -signals), converted from the unit recorded")
+	flag.BoolVar(&raw, "raw", false, "Write values as ADC counts, by the gain and offset given by -signals, with the calibration in *.raw.json")
+	flag.IntVar(&precision, "precision", -1, "Decimal places of values(-1 for full precision)")
+	flag.StringVar(&key, "key", "", "Key of SQLCipher encrypted input(passphrase, or x'hex' for a raw key)")
+	flag.StringVar(&keyFile, "key-file", "", "File holding the key of SQLCipher encrypted input")
+	flag.BoolVar(&concat, "concat", false, "Merge the inputs, databases of one recording, into one export named after the first")
+	flag.BoolVar(&salvage, "salvage", false, "Export the readable rows of damaged databases, logging those skipped")
+	flag.BoolVar(&verbose, "verbose", false, "Log the sample rate detected of every signal written")
+	flag.BoolVar(&force, "force", false, "Convert inputs of a batch or -watch even if their outputs are newer")
+	flag.StringVar(&manifest, "manifest", "", "Convert the inputs listed in the csv(columns input, subject, prefix), writing their results to the output directory")
+	flag.StringVar(&watchDir, "watch", "", "Convert vital data arriving in the directory, then move it to its done or failed subdirectory")
+	flag.StringVar(&mode, "open-mode", "immutable", "Open mode of input(immutable, ro, rw)")
+	flag.StringVar(&schema, "schema", "", "JSON file naming the tables and columns of a database variant")
+	flag.StringVar(&signalsFile, "signals", "", "YAML or JSON file mapping ztype codes to signals(name, axes, unit, sample_rate)")
+	flag.StringVar(&normalize, "normalize", "", "Normalize the values of each channel over the recording, read twice: "+strings.Join(normalizeModes, ", "))
+	flag.StringVar(&calibrationFile, "calibration", "", "YAML or JSON file of the offsets, scales and temperature coefficients of the channels of each device, applied to the values recorded")
+	flag.BoolVar(&all, "all", false, "Export every signal recorded, naming those of unknown ztype codes ztype<code>")
+	flag.StringVar(&ztypes, "ztype", "", "Export more signals of one channel by ztype code, as comma separated code:name pairs(e.g. 12:emg)")
+	flag.StringVar(&from, "from", "", "Export only the samples from this time on(RFC 3339 or seconds since the Unix epoch)")
+	flag.StringVar(&to, "to", "", "Export only the samples before this time(RFC 3339 or seconds since the Unix epoch)")
+	flag.StringVar(&dedup, "dedup", "", "Drop the duplicate rows of databases synced twice, of the same time and z_fok_timestamp("+strings.Join(dedupModes, ", ")+": whatever their value)")
+	flag.StringVar(&epoch, "time-epoch", "auto", "Epoch of the times in the database(coredata, unix, auto)")
+	flag.BoolVar(&driftCorrection, "drift-correction", false, "Correct the times by the clock syncs of the device with the phone recorded, interpolated between the syncs")
+	flag.StringVar(&tz, "tz", "local", "Time zone of the times written(local, UTC, device for that recorded in the database, or a name such as Europe/Berlin)")
+	flag.StringVar(&tf, "time-format", "local", "Format of time and detailed_timestamp(local, local-offset, rfc3339, epoch-ms, epoch-ns), local with the UTC offset for recordings spanning a daylight saving time transition")
+	flag.BoolVar(&showVersion, "version", false, "Print the version, git commit and build date, and exit")
+	flag.CommandLine.Parse(args)
+
+	if showVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+
+	fm, ok := formats[f]
+	if !ok {
+		log.Fatalf("Unknown output format: %s", f)
+	}
+	if combined {
+		if !fm.long {
+			log.Fatalf("-combined is not supported by output format: %s", f)
+		}
+		enc := fm.encoder
+		fm.encoder = func(w io.Writer, opts *Options) encoder {
+			return newCombinedEncoder(enc(w, opts), opts)
+		}
+		fm.single = true
+	}
+	if schema != "" {
+		s, err := loadSchema(schema)
+		if err != nil {
+			log.Fatal(err)
+		}
+		vitalSchemas = []vitalSchema{s}
+	}
+	if signalsFile != "" {
+		if err := loadSignals(signalsFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if ztypes != "" {
+		if err := addZtypeSignals(ztypes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Signals without a code in the schema, such as HR and SpO2 in the
+	// built-in one, are not exported.
+	var signals []int
+	for _, t := range signalOrder {
+		if hasZtype(t) {
+			signals = append(signals, t)
+		}
+	}
+	if only != "" {
+		t, ok := signalNamed(only)
+		if !ok {
+			log.Fatalf("Unknown signal: %s", only)
+		}
+		if !hasZtype(t) {
+			log.Fatalf("No ztype code of %s is known, give it with -schema", only)
+		}
+		signals = []int{t}
+	}
+	if all && only != "" {
+		log.Fatal("-all cannot be used with -only")
+	}
+	if all && fm.ecgOnly {
+		log.Fatalf("-all is not supported by output format: %s", f)
+	}
+	if fm.ecgOnly {
+		if only != "" && only != "ecg" {
+			log.Fatalf("Output format %s does not support %s", f, only)
+		}
+		signals = []int{ECG_TYPE}
+	}
+	if merge {
+		if f != "csv" || combined || only != "" || all {
+			log.Fatal("-merge requires csv output, without -combined, -only or -all")
+		}
+		if !hasZtype(ECG_TYPE) || !hasZtype(ACCEL_TYPE) {
+			log.Fatal("-merge requires the ECG and the accel")
+		}
+		signals = []int{ECG_TYPE, ACCEL_TYPE}
+		fm.encoder, fm.single = newJoinedEncoder, true
+	}
+	if stdout && !fm.single && (len(signals) > 1 || all) {
+		log.Fatalf("-stdout requires -only with output format: %s", f)
+	}
+	if datapackage && (f != "csv" || stdout) {
+		log.Fatal("-datapackage requires csv output to files")
+	}
+	if csvw && (f != "csv" || stdout) {
+		log.Fatal("-csvw requires csv output to files")
+	}
+	if metadata != "" && !sessionFormats[metadata] {
+		log.Fatalf("Unknown metadata format: %s", metadata)
+	}
+	if metadata != "" && stdout {
+		log.Fatal("-metadata requires output to files")
+	}
+	if events && stdout {
+		log.Fatal("-events requires output to files")
+	}
+	if events && !hasEvents() {
+		log.Fatal("No event table is known, give it with -schema")
+	}
+	if raw {
+		if all {
+			log.Fatal("-raw cannot be used with -all")
+		}
+		for _, t := range signals {
+			s := signalTypes[t]
+			if s.gain == 0 {
+				log.Fatalf("No gain of %s is known, give it with -signals", s.name)
+			}
+			s.raw = true
+		}
+	}
+	if accelUnit != "" {
+		if err := setUnit(signalTypes[ACCEL_TYPE], "-accel-unit", accelUnit, acceleration); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if ecgUnit != "" {
+		if err := setUnit(signalTypes[ECG_TYPE], "-ecg-unit", ecgUnit, voltage); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var cal calibrations
+	if calibrationFile != "" {
+		if hasRaw(&Options{Signals: signals}) {
+			log.Fatal("-calibration cannot be used with -raw or -ecg-unit raw")
+		}
+		c, err := loadCalibration(calibrationFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cal = c
+	}
+	if normalize != "" {
+		if !slices.Contains(normalizeModes, normalize) {
+			log.Fatalf("Unknown mode of -normalize: %s", normalize)
+		}
+		if hasRaw(&Options{Signals: signals}) {
+			log.Fatal("-normalize cannot be used with -raw or -ecg-unit raw")
+		}
+		if posture {
+			log.Fatal("-normalize cannot be used with -posture")
+		}
+		for _, s := range signalTypes {
+			s.normalize = normalize
+		}
+	}
+	if accelMagnitude && signalTypes[ACCEL_TYPE].outputUnit() != "g" {
+		log.Fatal("-accel-magnitude requires the accel in g")
+	}
+	if byDevice && (stdout || salvage) {
+		log.Fatal("-by-device cannot be used with -stdout or -salvage")
+	}
+	if byDevice && !hasDevices() {
+		log.Fatal("No device column is known, give it with -schema")
+	}
+	if rr && stdout {
+		log.Fatal("-rr requires output to files")
+	}
+	if beats && stdout {
+		log.Fatal("-beats requires output to files")
+	}
+	if beats && !slices.Contains(signals, ECG_TYPE) && !all {
+		log.Fatal("-beats requires the ECG")
+	}
+	if hrv && stdout {
+		log.Fatal("-hrv requires output to files")
+	}
+	if hrv && !beats && !hasBeats() {
+		log.Fatal("-hrv requires -beats or a beat table, given with -schema")
+	}
+	if hrvWindow < 0 {
+		log.Fatal("Negative -hrv-window")
+	}
+	if artifacts && stdout {
+		log.Fatal("-artifacts requires output to files")
+	}
+	if posture && stdout {
+		log.Fatal("-posture requires output to files")
+	}
+	if posture && !slices.Contains(signals, ACCEL_TYPE) && !all {
+		log.Fatal("-posture requires the accel")
+	}
+	if postureEpoch <= 0 {
+		log.Fatal("-posture-epoch must be positive")
+	}
+	axes, err := parsePostureAxes(postureAxes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if steps && stdout {
+		log.Fatal("-steps requires output to files")
+	}
+	if steps && !slices.Contains(signals, ACCEL_TYPE) && !all {
+		log.Fatal("-steps requires the accel")
+	}
+	if steps && signalTypes[ACCEL_TYPE].outputUnit() != "g" {
+		log.Fatal("-steps requires the accel in g")
+	}
+	if nonWear {
+		if stdout {
+			log.Fatal("-non-wear requires output to files")
+		}
+		if !slices.Contains(signals, ACCEL_TYPE) && !slices.Contains(signals, ECG_TYPE) && !all {
+			log.Fatal("-non-wear requires the accel or the ECG")
+		}
+		if signalTypes[ACCEL_TYPE].outputUnit() != "g" || signalTypes[ECG_TYPE].outputUnit() != "mV" {
+			log.Fatal("-non-wear requires the accel in g and the ECG in mV")
+		}
+		if nonWearMin <= 0 {
+			log.Fatal("-non-wear-min must be positive")
+		}
+	} else {
+		nonWearMin = 0
+	}
+	if psd < 0 {
+		log.Fatal("Negative -psd")
+	}
+	if psd > 0 {
+		if stdout {
+			log.Fatal("-psd requires output to files")
+		}
+		if !slices.Contains(signals, ECG_TYPE) && !all {
+			log.Fatal("-psd requires the ECG")
+		}
+		if !slices.Contains(psdFormats, psdFormat) {
+			log.Fatalf("Unknown format of -psd-format: %s", psdFormat)
+		}
+	}
+	if sqi && stdout {
+		log.Fatal("-sqi requires output to files")
+	}
+	if histogram && stdout {
+		log.Fatal("-histogram requires output to files")
+	}
+	if gaps && stdout {
+		log.Fatal("-gaps requires output to files")
+	}
+	if rr && !hasBeats() {
+		log.Fatal("No beat table is known, give it with -schema")
+	}
+	if fm.local && (stdout || c != "" || isObject(d)) {
+		log.Fatalf("Output format %s is only written to local, uncompressed files", f)
+	}
+	if stdout && split != "" {
+		log.Fatal("-stdout cannot be used with -split-by")
+	}
+	if _, ok := splits[split]; split != "" && !ok {
+		log.Fatalf("Unknown split: %s", split)
+	}
+	if split != "" && fm.single {
+		log.Fatalf("-split-by is not supported by output format: %s", f)
+	}
+	if segmentGap < 0 || segmentGap > 0 && segmentGap < time.Second {
+		log.Fatal("-segment-gap must be at least a second")
+	}
+	if segmentGap > 0 && (stdout || split != "" || fm.single) {
+		log.Fatalf("-segment-gap cannot be used with -stdout, -split-by or output format: %s", f)
+	}
+	if !slices.Contains(outOfOrderPolicies, outOfOrderPolicy) {
+		log.Fatalf("Unknown policy of -out-of-order: %s", outOfOrderPolicy)
+	}
+	if !slices.Contains(incompletePolicies, incompletePolicy) {
+		log.Fatalf("Unknown policy of -incomplete: %s", incompletePolicy)
+	}
+	if !slices.Contains(interpolationStrategies, interpolationStrategy) {
+		log.Fatalf("Unknown strategy of -interpolation: %s", interpolationStrategy)
+	}
+	if zfokScale <= 0 {
+		log.Fatal("-zfok-scale must be positive")
+	}
+	resampleRates, err := parseSignalValues("resample", resample, func(hz float64) bool {
+		return hz > 0 && !math.IsInf(hz, 0)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	downsampleFactors, err := parseSignalValues("downsample", downsample, func(n float64) bool {
+		return n >= 1 && n == math.Trunc(n)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if merge {
+		if downsampleFactors != nil || len(resampleRates) > 1 || resampleRates != nil && resampleRates[""] == 0 {
+			log.Fatal("-merge requires a -resample rate of all signals, and cannot be used with -downsample")
+		}
+		if resampleRates == nil {
+			rate := signalTypes[ECG_TYPE].rate
+			if rate == 0 {
+				rate = MERGE_RATE
+			}
+			resampleRates = map[string]float64{"": rate}
+		}
+	}
+	if resampleRates != nil && downsampleFactors != nil {
+		log.Fatal("-downsample cannot be used with -resample")
+	}
+	filterSpec, err := parseFilter(filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if filterSpec, err = parseNotch(notch, filterSpec); err != nil {
+		log.Fatal(err)
+	}
+	if !slices.Contains(filterOutputs, filterOutput) {
+		log.Fatalf("Unknown output of -filter-output: %s", filterOutput)
+	}
+	if filterSpec != nil && filterOutput == "column" && (f != "csv" && f != "jsonl" || combined) {
+		log.Fatalf("-filter-output column is not supported by output format: %s", f)
+	}
+	if accelMagnitude && (f != "csv" && f != "jsonl" || combined) {
+		log.Fatalf("-accel-magnitude is not supported by output format: %s", f)
+	}
+	if driftCorrection && !hasSyncs() {
+		log.Fatal("No clock sync table is known, give it with -schema")
+	}
+	if smooth < 0 {
+		log.Fatal("Negative -smooth")
+	}
+	if smooth > 0 && (f != "csv" && f != "jsonl" || combined || merge || accelMagnitude) {
+		log.Fatal("-smooth requires csv or jsonl output, without -combined, -merge or -accel-magnitude")
+	}
+	if dedup != "" && !slices.Contains(dedupModes, dedup) {
+		log.Fatalf("Unknown mode of -dedup: %s", dedup)
+	}
+	var window timeRange
+	if window.from, err = parseTimeBound("-from", from); err != nil {
+		log.Fatal(err)
+	}
+	if window.to, err = parseTimeBound("-to", to); err != nil {
+		log.Fatal(err)
+	}
+	if !window.from.IsZero() && !window.to.IsZero() && !window.from.Before(window.to) {
+		log.Fatal("-from must be before -to")
+	}
+	if !slices.Contains(resampleMethods, resampleMethod) {
+		log.Fatalf("Unknown method of -resample-method: %s", resampleMethod)
+	}
+	if interpolationStrategy == "rate" {
+		if all {
+			log.Fatal("-interpolation rate cannot be used with -all")
+		}
+		for _, t := range signals {
+			if s := signalTypes[t]; s.rate == 0 && !s.sparse {
+				log.Fatalf("No sample rate of %s is known, give it with -signals", s.name)
+			}
+		}
+	}
+	tl, ok := timeFormats[tf]
+	if !ok {
+		log.Fatalf("Unknown time format: %s", tf)
+	}
+	timeLayout = tl
+	if strings.EqualFold(tz, "device") {
+		if !hasTimeZone() {
+			log.Fatal("No time zone column is known, give it with -schema")
+		}
+		deviceZone = true
+	} else if outputZone, err = parseZone(tz); err != nil {
+		log.Fatal(err)
+	}
+	columns, err := parseColumns(cols)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if columns != nil && f != "csv" {
+		log.Fatalf("-columns is not supported by output format: %s", f)
+	}
+	if sampleIndex && f != "csv" && f != "jsonl" {
+		log.Fatalf("-sample-index is not supported by output format: %s", f)
+	}
+	if sampleIndex && combined {
+		log.Fatal("-sample-index cannot be used with -combined")
+	}
+	var stats []string
+	if epochLength != 0 {
+		if epochLength < 0 {
+			log.Fatal("Negative -epoch")
+		}
+		if f != "csv" || combined || merge || columns != nil || sampleIndex || accelMagnitude || smooth > 0 || datapackage || csvw {
+			log.Fatal("-epoch requires csv output, without -combined, -merge, -columns, -sample-index, -accel-magnitude, -smooth, -datapackage or -csvw")
+		}
+		if filterSpec != nil && filterOutput == "column" {
+			log.Fatal("-epoch cannot be used with -filter-output column")
+		}
+		if stats, err = parseEpochStats(epochStats); err != nil {
+			log.Fatal(err)
+		}
+		fm.encoder = newEpochEncoder
+	}
+	comma, err := parseDelimiter(delim)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if comma == '\t' && f == "csv" {
+		fm.ext = ".tsv"
+	}
+	if merge {
+		if columns != nil || sampleIndex || accelMagnitude || datapackage || csvw {
+			log.Fatal("-merge cannot be used with -columns, -sample-index, -accel-magnitude, -datapackage or -csvw")
+		}
+		if filterSpec != nil && filterOutput == "column" {
+			log.Fatal("-merge cannot be used with -filter-output column")
+		}
+		fm.ext = MERGED_FILE_SUFFIX + fm.ext
+	}
+	var cm *compression
+	if c != "" {
+		cp, ok := compressions[c]
+		if !ok {
+			log.Fatalf("Unknown compression: %s", c)
+		}
+		cm = &cp
+		fm.ext += cm.ext
+	}
 
 	v := flag.Args()
-	if len(v) != 1 {
+	if watchDir != "" || manifest != "" {
+		if len(v) > 0 || concat || stdout || watchDir != "" && manifest != "" {
+			log.Fatal("-watch and -manifest cannot be used with inputs, each other, -concat or -stdout")
+		}
+	} else if len(v) == 0 {
 		flag.Usage()
 		os.Exit(ExitCode)
 	}
 
-	if _, err := os.Stat(v[0]); os.IsNotExist(err) {
+	if _, ok := openModes[mode]; !ok {
+		log.Fatalf("Unknown open mode: %s", mode)
+	}
+	if _, ok := timeEpochs[epoch]; !ok && epoch != "auto" {
+		log.Fatalf("Unknown time epoch: %s", epoch)
+	}
+	key = readKey(key, keyFile)
+
+	var inputs []string
+	for _, arg := range v {
+		vitals, err := findVitals(arg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		inputs = append(inputs, vitals...)
+	}
+	if concat {
+		for _, input := range inputs {
+			if input == STDIN_INPUT || isArchive(input) || isURL(input) || isObject(input) {
+				log.Fatalf("-concat does not support input: %s", input)
+			}
+		}
+	}
+	if stdout && len(inputs) > 1 && !concat {
+		log.Fatal("-stdout cannot be used with multiple inputs")
+	}
+
+	return Options{
+		Inputs: inputs, Concat: concat, Watch: watchDir, Manifest: manifest, Force: force, Verbose: verbose,
+		OutDir: d, Key: key, OpenMode: mode, Salvage: salvage, TimeEpoch: epoch, Range: window, Dedup: dedup, DriftCorrection: driftCorrection,
+
+		Format: fm, Stdout: stdout, Delimiter: comma,
+		Compression: cm, Level: level, SplitBy: split, SegmentGap: segmentGap, Epoch: epochLength, EpochStats: stats, Columns: columns, SampleIndex: sampleIndex,
+		Resample: resampleRates, ResampleMethod: resampleMethod, Downsample: downsampleFactors,
+		Filter: filterSpec, FilterOutput: filterOutput, AccelMagnitude: accelMagnitude, Smooth: smooth,
+		Signals: signals, All: all, DataPackage: datapackage, CSVW: csvw,
+		Metadata: metadata, Events: events, RR: rr, Gaps: gaps, Histogram: histogram, Artifacts: artifacts, SQI: sqi, Posture: posture, Steps: steps, NonWear: nonWearMin, PostureEpoch: postureEpoch, PostureAxes: axes, Beats: beats, PSD: psd, PSDFormat: psdFormat, HRV: hrv, HRVWindow: hrvWindow, Calibration: cal, Normalize: normalize, ByDevice: byDevice,
+	}
+}
+
+// Open modes of the input database. "immutable" tells SQLite the file
+// cannot change, so no locks, journal, WAL or SHM files are used; a WAL
+// not yet checkpointed into the database is ignored then, which "ro"
+// still reads.
+var openModes = map[string]url.Values{
+	"immutable": {"mode": {"ro"}, "immutable": {"1"}},
+	"ro":        {"mode": {"ro"}},
+	"rw":        {},
+}
+
+// readKey returns the SQLCipher key given by -key, or read from the file
+// given by -key-file.
+func readKey(key, keyFile string) string {
+	if keyFile == "" {
+		return key
+	}
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
 		log.Fatal(err)
 	}
+	return strings.TrimRight(string(b), "\r\n")
+}
+
+// inputDSN returns the SQLite URI of the vital database, opened as set by
+// -open-mode and keyed for SQLCipher if -key is given.
+func inputDSN(vital string, opts *Options) string {
+	q := url.Values{}
+	for k, v := range openModes[opts.OpenMode] {
+		q[k] = v
+	}
+	if opts.Key != "" {
+		// The driver quotes the key with double quotes.
+		q.Set("_pragma_key", strings.ReplaceAll(opts.Key, `"`, `""`))
+	}
+	p := filepath.ToSlash(vital)
+	if filepath.VolumeName(vital) != "" {
+		p = "/" + p
+	}
+	return "file:" + (&url.URL{Path: p}).EscapedPath() + "?" + q.Encode()
+}
+
+// findVitals returns path if it is a file, or the vital databases found
+// under path if it is a directory. Glob patterns, including "**" for any
+// number of directories, are expanded here so that they also work where
+// the shell does not expand them.
+func findVitals(path string) ([]string, error) {
+	if path == STDIN_INPUT || isURL(path) {
+		return []string{path}, nil
+	}
+	if isObject(path) {
+		return findObjects(path)
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) && strings.ContainsAny(path, "*?[{") {
+		matches, err := doublestar.FilepathGlob(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files match: %s", path)
+		}
+		var vitals []string
+		for _, m := range matches {
+			vs, err := findVitals(m)
+			if err != nil {
+				return nil, err
+			}
+			vitals = append(vitals, vs...)
+		}
+		return vitals, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	var vitals []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(filepath.Ext(p), VITAL_FILE_EXT) {
+			vitals = append(vitals, p)
+		}
+		return nil
+	})
+	return vitals, err
+}
 
-	vital := v[0]
+// forInput returns the options to convert the vital database at path,
+// with the output files named after its basename.
+func (opts Options) forInput(vital string) Options {
 	base := filepath.Base(vital)
-	ecg := filepath.Join(d, strings.TrimSuffix(base, filepath.Ext(base))+ECG_FILE_EXT)
-	accel := filepath.Join(d, strings.TrimSuffix(base, filepath.Ext(base))+ACCEL_FILE_EXT)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	if isPolar(vital) {
+		name = polarName(name)
+	}
+	return opts.named(vital, name)
+}
 
-	return vital, ecg, accel
+// named returns the options to convert vital to outputs named name.
+func (opts Options) named(vital, name string) Options {
+	ext := opts.Format.ext
+	opts.Vital, opts.Name = vital, name
+	opts.Outputs = make(map[int]string)
+	for _, t := range opts.Signals {
+		base := name + signalTypes[t].suffix
+		if opts.Format.single {
+			base = name
+		}
+		if opts.Format.record {
+			base = wfdbRecordName(base)
+		}
+		opts.Outputs[t] = joinOutput(opts.OutDir, base+ext)
+	}
+	return opts
+}
+
+// parseDelimiter accepts a single character, or "\t"/"tab" for TSV.
+func parseDelimiter(s string) (rune, error) {
+	switch s {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' || r[0] == utf8.RuneError {
+		return 0, fmt.Errorf("Invalid delimiter: %q", s)
+	}
+	return r[0], nil
+}
+
+// parseColumns splits a comma separated list of csv column names.
+func parseColumns(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	for _, c := range csvColumns() {
+		known[c] = true
+	}
+	columns := strings.Split(s, ",")
+	for i, c := range columns {
+		columns[i] = strings.TrimSpace(c)
+		if !known[columns[i]] {
+			return nil, fmt.Errorf("Unknown column: %q", c)
+		}
+	}
+	return columns, nil
 }
 
 func checkError(msg string, err error) {
 	if err != nil {
 		log.Print(msg+": ", err)
 		ExitCode = 1
+		runError.Lock()
+		if runError.err == nil {
+			runError.err = fmt.Errorf("%s: %v", msg, err)
+		}
+		runError.Unlock()
 		runtime.Goexit()
 	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	WATCH_DONE_DIR   = "done"
+	WATCH_FAILED_DIR = "failed"
+	WATCH_SETTLE     = 2 * time.Second // quiet time before a file is taken as complete
+)
+
+// watch converts every vital database arriving in dir, then moves it to
+// the done or failed subdirectory. A file is converted once it has not
+// been written for WATCH_SETTLE, so that uploads still in progress are
+// not read. Files already in dir are converted first. watch never
+// returns unless the watch cannot be set up.
+func watch(dir string, opts Options) {
+	for _, d := range []string{WATCH_DONE_DIR, WATCH_FAILED_DIR} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		log.Fatal(err)
+	}
+
+	ready := make(chan string)
+	go func() {
+		for p := range ready {
+			convertWatched(p, dir, opts)
+		}
+	}()
+
+	var (
+		mu     sync.Mutex
+		timers = make(map[string]*time.Timer)
+	)
+	settle := func(p string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if t, ok := timers[p]; ok {
+			t.Reset(WATCH_SETTLE)
+			return
+		}
+		timers[p] = time.AfterFunc(WATCH_SETTLE, func() {
+			mu.Lock()
+			delete(timers, p)
+			mu.Unlock()
+			ready <- p
+		})
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && isVital(e.Name()) {
+			settle(filepath.Join(dir, e.Name()))
+		}
+	}
+
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Has(fsnotify.Create|fsnotify.Write) && isVital(ev.Name) {
+				settle(ev.Name)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Print("Watch: ", err)
+		}
+	}
+}
+
+// convertWatched converts the database at p and moves it out of dir.
+func convertWatched(p, dir string, opts Options) {
+	if _, err := os.Stat(p); err != nil {
+		// Moved away or settled twice.
+		return
+	}
+	dest := WATCH_DONE_DIR
+	if r := run(opts.forInput(p), p); r.err != nil {
+		dest = WATCH_FAILED_DIR
+	}
+	if err := os.Rename(p, filepath.Join(dir, dest, filepath.Base(p))); err != nil {
+		log.Print("Move input: ", err)
+	}
+}
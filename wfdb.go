@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	WFDB_FORMAT          = 16
+	WFDB_ADC_RESOLUTION  = 16
+	WFDB_DIGITAL_MIN     = -32767
+	WFDB_DIGITAL_MAX     = 32767
+	WFDB_INVALID_SAMPLE  = -32768
+	WFDB_HEADER_FILE_EXT = ".hea"
+)
+
+// wfdbEncoder writes a PhysioNet WFDB record: the samples go to the .dat
+// file in format 16 (interleaved little-endian 16 bit), and a .hea header
+// file with the same record name is created next to it on Close. Seconds
+// are resampled to a fixed sampling frequency and missing seconds are
+// filled with invalid samples.
+type wfdbEncoder struct {
+	spool
+	w    io.Writer
+	name string
+	dat  string
+}
+
+// wfdbRecordName replaces the characters of the last element of path
+// that WFDB record names do not allow, all but letters, digits and
+// underscores, by underscores.
+func wfdbRecordName(path string) string {
+	dir, base := filepath.Split(path)
+	return dir + strings.Map(func(r rune) rune {
+		if r == '_' || r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, base)
+}
+
+func newWFDBEncoder(w io.Writer, opts *Options) encoder {
+	return &wfdbEncoder{w: w, name: opts.Name}
+}
+
+func (e *wfdbEncoder) Header(s *signal, v interface{}) error {
+	dat, err := outputPath(e.w, "wfdb")
+	if err != nil {
+		return err
+	}
+	e.dat = dat
+	return e.open(s)
+}
+
+func (e *wfdbEncoder) Encode(s *signal, v interface{}) error {
+	return e.encode(v)
+}
+
+func (e *wfdbEncoder) Close() error {
+	defer e.release()
+
+	rate := e.sampleRate()
+	nc := len(e.channels)
+	gain, baseline := make([]float64, nc), make([]int, nc)
+	for i := range e.channels {
+		min, max := e.physical(i)
+		gain[i] = (WFDB_DIGITAL_MAX - WFDB_DIGITAL_MIN) / (max - min)
+		baseline[i] = int(math.Round(WFDB_DIGITAL_MIN - min*gain[i]))
+	}
+
+	var (
+		frames   int64
+		prev     int64
+		initial  = make([]int16, nc)
+		checksum = make([]int16, nc)
+	)
+	w := bufio.NewWriter(e.w)
+	write := func(i int, d int16) error {
+		if frames == 0 {
+			initial[i] = d
+		}
+		checksum[i] += d
+		return binary.Write(w, binary.LittleEndian, d)
+	}
+
+	err := e.each(rate, func(sec spoolSecond, chs [][]float64) error {
+		if frames > 0 {
+			for n := (sec.ztime - prev - 1) * int64(rate); n > 0; n-- {
+				for i := range chs {
+					if err := write(i, WFDB_INVALID_SAMPLE); err != nil {
+						return err
+					}
+				}
+				frames++
+			}
+		}
+		prev = sec.ztime
+
+		for j := 0; j < rate; j++ {
+			for i, ch := range chs {
+				d := math.Round(ch[j]*gain[i]) + float64(baseline[i])
+				d = math.Max(WFDB_DIGITAL_MIN, math.Min(WFDB_DIGITAL_MAX, d))
+				if math.IsNaN(ch[j]) {
+					d = WFDB_INVALID_SAMPLE
+				}
+				if err := write(i, int16(d)); err != nil {
+					return err
+				}
+			}
+			frames++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	dat := e.dat
+	record := strings.TrimSuffix(filepath.Base(dat), filepath.Ext(dat))
+	var start time.Time
+	if len(e.seconds) > 0 {
+		start = time.Unix(e.seconds[0].ztime, 0).In(outputZone)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d %d %d %s %s\n", record, nc, rate, frames,
+		start.Format("15:04:05"), start.Format("02/01/2006"))
+	for i, c := range e.channels {
+		// Units are optional, and left out with their slash if unknown.
+		unit := ""
+		if c.unit != "" {
+			unit = "/" + asciiUnit(c.unit)
+		}
+		fmt.Fprintf(&b, "%s %d %g(%d)%s %d 0 %d %d 0 %s\n",
+			filepath.Base(dat), WFDB_FORMAT, gain[i], baseline[i], unit,
+			WFDB_ADC_RESOLUTION, initial[i], checksum[i], c.label)
+	}
+	hea := strings.TrimSuffix(dat, filepath.Ext(dat)) + WFDB_HEADER_FILE_EXT
+	return os.WriteFile(hea, []byte(b.String()), 0644)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	XLSX_TIME_FORMAT     = "yyyy-mm-dd hh:mm:ss"
+	XLSX_DETAILED_FORMAT = "yyyy-mm-dd hh:mm:ss.000"
+)
+
+// xlsxSheet is the sheet a signal is currently streamed to. A signal that
+// exceeds the row limit of a worksheet continues on a new sheet.
+type xlsxSheet struct {
+	name string
+	part int
+	sw   *excelize.StreamWriter
+	row  int
+	cols []interface{}
+}
+
+// xlsxEncoder writes one workbook with a sheet for each signal, named by
+// its label. The signals share the encoder, so every call is serialized.
+type xlsxEncoder struct {
+	sync.Mutex
+	w        io.Writer
+	f        *excelize.File
+	timeID   int
+	detailID int
+	sheets   map[string]*xlsxSheet
+	err      error
+}
+
+func newXLSXEncoder(w io.Writer, opts *Options) encoder {
+	e := &xlsxEncoder{w: w, f: excelize.NewFile(), sheets: make(map[string]*xlsxSheet)}
+
+	// Sheets are created up front to keep their order independent of
+	// which signal arrives first.
+	for _, t := range opts.Signals {
+		if _, err := e.f.NewSheet(signalTypes[t].label); err != nil {
+			e.err = err
+		}
+	}
+	if err := e.f.DeleteSheet("Sheet1"); err != nil {
+		e.err = err
+	}
+
+	tf, df := XLSX_TIME_FORMAT, XLSX_DETAILED_FORMAT
+	var err error
+	if e.timeID, err = e.f.NewStyle(&excelize.Style{CustomNumFmt: &tf}); err != nil {
+		e.err = err
+	}
+	if e.detailID, err = e.f.NewStyle(&excelize.Style{CustomNumFmt: &df}); err != nil {
+		e.err = err
+	}
+	return e
+}
+
+func (e *xlsxEncoder) Header(s *signal, v interface{}) error {
+	e.Lock()
+	defer e.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+
+	sh := &xlsxSheet{name: s.label, cols: []interface{}{"time", "timestamp", "z_fok_timestamp"}}
+	for _, c := range s.channels() {
+		sh.cols = append(sh.cols, c.name)
+	}
+	sh.cols = append(sh.cols, "detailed_timestamp")
+	e.sheets[sh.name] = sh
+	return e.startSheet(sh)
+}
+
+func (e *xlsxEncoder) startSheet(s *xlsxSheet) error {
+	name := s.name
+	if s.part > 0 {
+		name = fmt.Sprintf("%s (%d)", s.name, s.part+1)
+		if _, err := e.f.NewSheet(name); err != nil {
+			return err
+		}
+	}
+
+	sw, err := e.f.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	if err := sw.SetColWidth(1, len(s.cols), 20); err != nil {
+		return err
+	}
+	s.sw, s.row = sw, 1
+	return e.setRow(s, s.cols)
+}
+
+func (e *xlsxEncoder) setRow(s *xlsxSheet, values []interface{}) error {
+	if s.row > excelize.TotalRows {
+		if err := s.sw.Flush(); err != nil {
+			return err
+		}
+		s.part++
+		if err := e.startSheet(s); err != nil {
+			return err
+		}
+	}
+
+	cell, err := excelize.CoordinatesToCellName(1, s.row)
+	if err != nil {
+		return err
+	}
+	s.row++
+	return s.sw.SetRow(cell, values)
+}
+
+func (e *xlsxEncoder) Encode(s *signal, v interface{}) error {
+	e.Lock()
+	defer e.Unlock()
+
+	sh := e.sheets[s.label]
+	return eachRow(v, func(ztime, zfok int64, detailed time.Time, vs ...float64) error {
+		return e.setRow(sh, e.row(ztime, zfok, detailed, vs...))
+	})
+}
+
+func (e *xlsxEncoder) row(ztime, zfok int64, detailed time.Time, vs ...float64) []interface{} {
+	row := []interface{}{
+		excelize.Cell{StyleID: e.timeID, Value: xlsxTime(time.Unix(ztime, 0))},
+		ztime,
+		zfok,
+	}
+	for _, v := range vs {
+		if math.IsNaN(v) {
+			row = append(row, nil) // an empty cell
+			continue
+		}
+		row = append(row, v)
+	}
+	return append(row, excelize.Cell{StyleID: e.detailID, Value: xlsxTime(detailed)})
+}
+
+func (e *xlsxEncoder) Close() error {
+	defer e.f.Close()
+	for _, s := range e.sheets {
+		if err := s.sw.Flush(); err != nil {
+			return err
+		}
+	}
+	return e.f.Write(e.w)
+}
+
+// xlsxTime returns t in wall clock time of the time zone of -tz, as Excel
+// has no notion of time zones.
+func xlsxTime(t time.Time) time.Time {
+	l := t.In(outputZone)
+	return time.Date(l.Year(), l.Month(), l.Day(), l.Hour(), l.Minute(), l.Second(), l.Nanosecond(), time.UTC)
+}